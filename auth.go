@@ -0,0 +1,27 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+)
+
+// basicAuth only passes requests to next that carry the given basic auth credentials.
+func basicAuth(next http.Handler, user string, pass string) http.Handler {
+	// compare hashes so the comparison doesn't leak the length of the credentials
+	userHash := sha256.Sum256([]byte(user))
+	passHash := sha256.Sum256([]byte(pass))
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		reqUser, reqPass, ok := req.BasicAuth()
+		reqUserHash := sha256.Sum256([]byte(reqUser))
+		reqPassHash := sha256.Sum256([]byte(reqPass))
+		userMatch := subtle.ConstantTimeCompare(userHash[:], reqUserHash[:]) == 1
+		passMatch := subtle.ConstantTimeCompare(passHash[:], reqPassHash[:]) == 1
+		if !ok || !userMatch || !passMatch {
+			resp.Header().Set("WWW-Authenticate", `Basic realm="metrics", charset="UTF-8"`)
+			http.Error(resp, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(resp, req)
+	})
+}
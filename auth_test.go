@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBasicAuth(t *testing.T) {
+	handler := basicAuth(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Write([]byte("metrics"))
+	}), "prometheus", "secret")
+	tests := []struct {
+		name string
+		user string
+		pass string
+		set  bool
+		want int
+	}{
+		{"correct", "prometheus", "secret", true, http.StatusOK},
+		{"wrong password", "prometheus", "guess", true, http.StatusUnauthorized},
+		{"wrong user", "grafana", "secret", true, http.StatusUnauthorized},
+		{"empty", "", "", true, http.StatusUnauthorized},
+		{"no credentials", "", "", false, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if test.set {
+			req.SetBasicAuth(test.user, test.pass)
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		if resp.Code != test.want {
+			t.Errorf("%s: got %d, want %d", test.name, resp.Code, test.want)
+		}
+		challenge := resp.Header().Get("WWW-Authenticate")
+		if test.want == http.StatusUnauthorized && challenge == "" {
+			t.Errorf("%s: 401 without a WWW-Authenticate header", test.name)
+		}
+		if test.want == http.StatusOK && resp.Body.String() != "metrics" {
+			t.Errorf("%s: got body %q", test.name, resp.Body.String())
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/tedpearson/ambientweatherexporter/weather"
+)
+
+// Config holds the options that can be read from the --config file. The yaml keys are
+// the names of the matching command line flags. A flag given on the command line or
+// through its AWE_ environment variable always takes precedence over the file, and
+// options missing from the file keep the flag default.
+type Config struct {
+	// Port overrides the -port default.
+	Port *int `yaml:"port"`
+	// ListenAddress overrides the -listen-address default.
+	ListenAddress *string `yaml:"listen-address"`
+	// Prefix overrides the -prefix default.
+	Prefix *string `yaml:"prefix"`
+	// Verbose overrides the -verbose default.
+	Verbose *bool `yaml:"verbose"`
+	// LogLevel overrides the -log-level default.
+	LogLevel *string `yaml:"log-level"`
+	// LogFormat overrides the -log-format default.
+	LogFormat *string `yaml:"log-format"`
+	// StationName overrides the -station-name default.
+	StationName *string `yaml:"station-name"`
+	// Units overrides the -units default.
+	Units *string `yaml:"units"`
+	// StaleAfter overrides the -stale-after default, e.g. "10m".
+	StaleAfter *string `yaml:"stale-after"`
+	// HealthMaxAge overrides the -health-max-age default, e.g. "15m".
+	HealthMaxAge *string `yaml:"health-max-age"`
+	// ReportPath overrides the -report-path default.
+	ReportPath *string `yaml:"report-path"`
+	// EcowittPath overrides the -ecowitt-path default.
+	EcowittPath *string `yaml:"ecowitt-path"`
+	// TLSCert overrides the -tls-cert default.
+	TLSCert *string `yaml:"tls-cert"`
+	// TLSKey overrides the -tls-key default.
+	TLSKey *string `yaml:"tls-key"`
+	// TLSMinVersion overrides the -tls-min-version default.
+	TLSMinVersion *string `yaml:"tls-min-version"`
+	// MetricsPath overrides the -metrics-path default.
+	MetricsPath *string `yaml:"metrics-path"`
+	// MetricsUser overrides the -metrics-user default.
+	MetricsUser *string `yaml:"metrics-user"`
+	// MetricsPass overrides the -metrics-pass default.
+	MetricsPass *string `yaml:"metrics-pass"`
+	// OpenMetrics overrides the -openmetrics default.
+	OpenMetrics *bool `yaml:"openmetrics"`
+	// MQTTBroker overrides the -mqtt-broker default.
+	MQTTBroker *string `yaml:"mqtt-broker"`
+	// MQTTTopicPrefix overrides the -mqtt-topic-prefix default.
+	MQTTTopicPrefix *string `yaml:"mqtt-topic-prefix"`
+	// MQTTTopic overrides the -mqtt-topic default.
+	MQTTTopic *string `yaml:"mqtt-topic"`
+	// MQTTHADiscovery overrides the -mqtt-ha-discovery default.
+	MQTTHADiscovery *bool `yaml:"mqtt-ha-discovery"`
+	// MQTTClientID overrides the -mqtt-client-id default.
+	MQTTClientID *string `yaml:"mqtt-client-id"`
+	// MQTTUser overrides the -mqtt-user default.
+	MQTTUser *string `yaml:"mqtt-user"`
+	// MQTTPass overrides the -mqtt-pass default.
+	MQTTPass *string `yaml:"mqtt-pass"`
+	// GraphiteAddress overrides the -graphite-address default.
+	GraphiteAddress *string `yaml:"graphite-address"`
+	// GraphitePrefix overrides the -graphite-prefix default.
+	GraphitePrefix *string `yaml:"graphite-prefix"`
+	// StatsDAddress overrides the -statsd-address default.
+	StatsDAddress *string `yaml:"statsd-address"`
+	// StatsDPrefix overrides the -statsd-prefix default.
+	StatsDPrefix *string `yaml:"statsd-prefix"`
+	// StatsDTags overrides the -statsd-tags default.
+	StatsDTags *bool `yaml:"statsd-tags"`
+	// CSVFile overrides the -csv-file default.
+	CSVFile *string `yaml:"csv-file"`
+	// CSVMaxBytes overrides the -csv-max-bytes default.
+	CSVMaxBytes *int64 `yaml:"csv-max-bytes"`
+	// SQLiteDB overrides the -sqlite-db default.
+	SQLiteDB *string `yaml:"sqlite-db"`
+	// RemoteWriteURL overrides the -remote-write-url default.
+	RemoteWriteURL *string `yaml:"remote-write-url"`
+	// RemoteWriteUser overrides the -remote-write-user default.
+	RemoteWriteUser *string `yaml:"remote-write-user"`
+	// RemoteWritePassword overrides the -remote-write-password default.
+	RemoteWritePassword *string `yaml:"remote-write-password"`
+	// RemoteWriteInterval overrides the -remote-write-interval default, e.g. "1m".
+	RemoteWriteInterval *string `yaml:"remote-write-interval"`
+	// LightningWebhookURL overrides the -lightning-webhook-url default.
+	LightningWebhookURL *string `yaml:"lightning-webhook-url"`
+	// LightningDistance overrides the -lightning-distance default.
+	LightningDistance *float64 `yaml:"lightning-distance"`
+	// LightningDebounce overrides the -lightning-debounce default, e.g. "15m".
+	LightningDebounce *string `yaml:"lightning-debounce"`
+	// OTLPEndpoint overrides the -otlp-endpoint default.
+	OTLPEndpoint *string `yaml:"otlp-endpoint"`
+	// OTLPInterval overrides the -otlp-interval default, e.g. "1m".
+	OTLPInterval *string `yaml:"otlp-interval"`
+	// PushgatewayURL overrides the -pushgateway-url default.
+	PushgatewayURL *string `yaml:"pushgateway-url"`
+	// PushInterval overrides the -push-interval default, e.g. "15s".
+	PushInterval *string `yaml:"push-interval"`
+	// PushJob overrides the -push-job default.
+	PushJob *string `yaml:"push-job"`
+	// EventsMaxClients overrides the -events-max-clients default.
+	EventsMaxClients *int `yaml:"events-max-clients"`
+	// InfluxURL overrides the -influx-url default.
+	InfluxURL *string `yaml:"influx-url"`
+	// InfluxToken overrides the -influx-token default.
+	InfluxToken *string `yaml:"influx-token"`
+	// InfluxOrg overrides the -influx-org default.
+	InfluxOrg *string `yaml:"influx-org"`
+	// InfluxBucket overrides the -influx-bucket default.
+	InfluxBucket *string `yaml:"influx-bucket"`
+	// InfluxFlushInterval overrides the -influx-flush-interval default, e.g. "10s".
+	InfluxFlushInterval *string `yaml:"influx-flush-interval"`
+	// BatteryLowVoltage overrides the -battery-low-voltage default.
+	BatteryLowVoltage *float64 `yaml:"battery-low-voltage"`
+	// AltitudeMeters overrides the -altitude-meters default.
+	AltitudeMeters *float64 `yaml:"altitude-meters"`
+	// LuxPerWm2 overrides the -lux-per-wm2 default.
+	LuxPerWm2 *float64 `yaml:"lux-per-wm2"`
+	// Latitude overrides the -latitude default.
+	Latitude *float64 `yaml:"latitude"`
+	// Longitude overrides the -longitude default.
+	Longitude *float64 `yaml:"longitude"`
+	// GDDBase overrides the -gdd-base default.
+	GDDBase *float64 `yaml:"gdd-base"`
+	// Timezone overrides the -timezone default.
+	Timezone *string `yaml:"timezone"`
+	// DecimalComma overrides the -decimal-comma default.
+	DecimalComma *bool `yaml:"decimal-comma"`
+	// Duplicates overrides the -duplicates default.
+	Duplicates *string `yaml:"duplicates"`
+	// MaxBodyBytes overrides the -max-body-bytes default.
+	MaxBodyBytes *int64 `yaml:"max-body-bytes"`
+	// ForwardURL adds to the -forward-url flags, unless they are given on the command line.
+	ForwardURL *[]string `yaml:"forward-url"`
+	// ForwardAttempts overrides the -forward-attempts default.
+	ForwardAttempts *int `yaml:"forward-attempts"`
+	// ForwardRetryDelay overrides the -forward-retry-delay default, e.g. "5s".
+	ForwardRetryDelay *string `yaml:"forward-retry-delay"`
+	// ForwardBufferDir overrides the -forward-buffer-dir default.
+	ForwardBufferDir *string `yaml:"forward-buffer-dir"`
+	// ForwardBufferBytes overrides the -forward-buffer-bytes default.
+	ForwardBufferBytes *int64 `yaml:"forward-buffer-bytes"`
+	// PWSWeatherID overrides the -pwsweather-id default.
+	PWSWeatherID *string `yaml:"pwsweather-id"`
+	// PWSWeatherPassword overrides the -pwsweather-password default.
+	PWSWeatherPassword *string `yaml:"pwsweather-password"`
+	// ReadTimeout overrides the -read-timeout default, e.g. "10s".
+	ReadTimeout *string `yaml:"read-timeout"`
+	// WriteTimeout overrides the -write-timeout default, e.g. "30s".
+	WriteTimeout *string `yaml:"write-timeout"`
+	// IdleTimeout overrides the -idle-timeout default, e.g. "2m".
+	IdleTimeout *string `yaml:"idle-timeout"`
+	// Pprof overrides the -pprof default.
+	Pprof *bool `yaml:"pprof"`
+	// NoRemoteLabel overrides the -no-remote-label default.
+	NoRemoteLabel *bool `yaml:"no-remote-label"`
+	// LegacyLabelNames overrides the -legacy-label-names default.
+	LegacyLabelNames *bool `yaml:"legacy-label-names"`
+	// AllowCIDR adds to the -allow-cidr flags, unless they are given on the command line.
+	AllowCIDR *[]string `yaml:"allow-cidr"`
+	// Label adds to the -label flags, unless they are given on the command line.
+	Label *[]string `yaml:"label"`
+
+	// Stations maps the PASSKEY, mac or stationtype of a reporting station to the value
+	// of its 'name' label. Stations that aren't listed use -station-name.
+	Stations map[string]string `yaml:"stations"`
+
+	// Sensors maps the channel of a multi-channel temperature or humidity sensor to
+	// the value of its 'sensor' label. Channels that aren't listed keep their number.
+	Sensors map[string]string `yaml:"sensors"`
+
+	// Thresholds are conditions on report fields, e.g. {field: tempf, op: "<", value: 32},
+	// exported as threshold_breach series that are 1 while the condition holds.
+	Thresholds []weather.Threshold `yaml:"thresholds"`
+}
+
+// loadConfig reads the yaml config file at path.
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// applyConfig sets every flag that is present in the config file but wasn't given
+// on the command line.
+func applyConfig(config *Config, flags *flag.FlagSet) error {
+	explicit := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	value := reflect.ValueOf(config).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Tag.Get("yaml")
+		field := value.Field(i)
+		// only the pointer fields are backed by a flag
+		if field.Kind() != reflect.Pointer || field.IsNil() || explicit[name] {
+			continue
+		}
+		// repeatable flags are set once per list item
+		items := []any{field.Elem().Interface()}
+		if list, ok := items[0].([]string); ok {
+			items = nil
+			for _, item := range list {
+				items = append(items, item)
+			}
+		}
+		for _, item := range items {
+			if err := flags.Set(name, fmt.Sprint(item)); err != nil {
+				return fmt.Errorf("invalid value for %s in config file: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// envFlags maps environment variables to the flag they provide a value for.
+var envFlags = map[string]string{
+	"AWE_PORT":         "port",
+	"AWE_PREFIX":       "prefix",
+	"AWE_STATION_NAME": "station-name",
+	"AWE_VERBOSE":      "verbose",
+}
+
+// applyEnv sets the flags that have an environment variable set. It must be called
+// before flags.Parse, so flags given on the command line take precedence.
+func applyEnv(flags *flag.FlagSet) error {
+	for env, name := range envFlags {
+		value, ok := os.LookupEnv(env)
+		if !ok {
+			continue
+		}
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %w", value, env, err)
+		}
+	}
+	return nil
+}
+
+// describeChanges summarizes the difference between two station name mappings.
+func describeChanges(old map[string]string, new map[string]string) string {
+	var changes []string
+	for key, name := range new {
+		oldName, ok := old[key]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("added %s", name))
+		} else if oldName != name {
+			changes = append(changes, fmt.Sprintf("renamed %s to %s", oldName, name))
+		}
+	}
+	for key, name := range old {
+		if _, ok := new[key]; !ok {
+			changes = append(changes, fmt.Sprintf("removed %s", name))
+		}
+	}
+	if len(changes) == 0 {
+		return "no changes"
+	}
+	sort.Strings(changes)
+	return strings.Join(changes, ", ")
+}
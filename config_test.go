@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"testing"
+)
+
+// newTestFlags returns a flag set with the flags that have an environment variable.
+func newTestFlags() (*flag.FlagSet, *int, *string) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	port := flags.Int("port", 6255, "")
+	prefix := flags.String("prefix", "weather", "")
+	flags.String("station-name", "", "")
+	flags.Bool("verbose", false, "")
+	return flags, port, prefix
+}
+
+func TestEnvPrecedence(t *testing.T) {
+	configPort, configPrefix := 7000, "config"
+	tests := []struct {
+		name       string
+		env        string
+		args       []string
+		config     *Config
+		wantPort   int
+		wantPrefix string
+	}{
+		{"default", "", nil, &Config{}, 6255, "weather"},
+		{"config", "", nil, &Config{Port: &configPort, Prefix: &configPrefix}, 7000, "config"},
+		{"env over config", "8000", nil, &Config{Port: &configPort, Prefix: &configPrefix}, 8000, "config"},
+		{"flag over env", "8000", []string{"-port", "9000"}, &Config{Port: &configPort}, 9000, "weather"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.env != "" {
+				t.Setenv("AWE_PORT", test.env)
+			}
+			flags, port, prefix := newTestFlags()
+			if err := applyEnv(flags); err != nil {
+				t.Fatal(err)
+			}
+			if err := flags.Parse(test.args); err != nil {
+				t.Fatal(err)
+			}
+			if err := applyConfig(test.config, flags); err != nil {
+				t.Fatal(err)
+			}
+			if *port != test.wantPort || *prefix != test.wantPrefix {
+				t.Errorf("got port %d prefix %q, want %d %q", *port, *prefix, test.wantPort, test.wantPrefix)
+			}
+		})
+	}
+}
+
+func TestEnvInvalid(t *testing.T) {
+	t.Setenv("AWE_PORT", "eighty")
+	flags, _, _ := newTestFlags()
+	if err := applyEnv(flags); err == nil {
+		t.Error("an unparseable AWE_PORT was accepted")
+	}
+}
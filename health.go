@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// liveHandler answers liveness probes, it is always ok while the process serves http.
+func liveHandler() http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		resp.Write([]byte("ok\n"))
+	})
+}
+
+// healthHandler answers health checks with the age of the last report of any station. It
+// fails when that is older than maxAge, counting from started until the first report, and
+// is always ok when maxAge is 0.
+func healthHandler(lastReport func() time.Time, maxAge time.Duration, started time.Time) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		last := lastReport()
+		since := last
+		if since.IsZero() {
+			since = started
+		}
+		age := time.Since(since)
+		status := "ok, "
+		if maxAge > 0 && age > maxAge {
+			resp.WriteHeader(http.StatusServiceUnavailable)
+			status = ""
+		}
+		if last.IsZero() {
+			fmt.Fprintf(resp, "%sno reports in %s\n", status, age.Round(time.Second))
+			return
+		}
+		fmt.Fprintf(resp, "%slast report %s ago\n", status, age.Round(time.Second))
+	})
+}
+
+// readyHandler answers readiness probes, it is only ok while ready is set.
+func readyHandler(ready *atomic.Bool) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if !ready.Load() {
+			resp.WriteHeader(http.StatusServiceUnavailable)
+			resp.Write([]byte("not ready\n"))
+			return
+		}
+		resp.Write([]byte("ready\n"))
+	})
+}
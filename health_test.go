@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthHandler(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name       string
+		lastReport time.Time
+		maxAge     time.Duration
+		started    time.Time
+		wantCode   int
+		wantBody   string
+	}{
+		{"fresh", now.Add(-time.Minute), 5 * time.Minute, now.Add(-time.Hour), http.StatusOK, "ok, last report 1m0s ago\n"},
+		{"stale", now.Add(-10 * time.Minute), 5 * time.Minute, now.Add(-time.Hour), http.StatusServiceUnavailable, "last report 10m0s ago\n"},
+		{"disabled", now.Add(-10 * time.Minute), 0, now.Add(-time.Hour), http.StatusOK, "ok, last report 10m0s ago\n"},
+		{"starting", time.Time{}, 5 * time.Minute, now.Add(-time.Minute), http.StatusOK, "ok, no reports in 1m0s\n"},
+		{"never reported", time.Time{}, 5 * time.Minute, now.Add(-10 * time.Minute), http.StatusServiceUnavailable, "no reports in 10m0s\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lastReport := func() time.Time { return test.lastReport }
+			resp := httptest.NewRecorder()
+			healthHandler(lastReport, test.maxAge, test.started).ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if resp.Code != test.wantCode {
+				t.Errorf("got %d, want %d", resp.Code, test.wantCode)
+			}
+			if got := resp.Body.String(); got != test.wantBody {
+				t.Errorf("got %q, want %q", got, test.wantBody)
+			}
+		})
+	}
+}
+
+func TestLiveAndReadyHandlers(t *testing.T) {
+	resp := httptest.NewRecorder()
+	liveHandler().ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	if resp.Code != http.StatusOK {
+		t.Errorf("livez: got %d, want 200", resp.Code)
+	}
+
+	var ready atomic.Bool
+	for _, test := range []struct {
+		ready    bool
+		wantCode int
+	}{
+		{false, http.StatusServiceUnavailable},
+		{true, http.StatusOK},
+	} {
+		ready.Store(test.ready)
+		resp := httptest.NewRecorder()
+		readyHandler(&ready).ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if resp.Code != test.wantCode || !strings.HasSuffix(resp.Body.String(), "ready\n") {
+			t.Errorf("ready %v: got %d %q, want %d", test.ready, resp.Code, resp.Body.String(), test.wantCode)
+		}
+	}
+}
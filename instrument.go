@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// httpMetrics counts and times the requests to the http handlers.
+type httpMetrics struct {
+	duration *prometheus.HistogramVec
+	requests *prometheus.CounterVec
+}
+
+func newHTTPMetrics(factory *promauto.Factory, metric_prefix string, constLabels prometheus.Labels) *httpMetrics {
+	return &httpMetrics{
+		duration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "http_request_duration_seconds",
+			Help:        "duration of http requests in seconds",
+			Namespace:   metric_prefix,
+			ConstLabels: constLabels,
+			Buckets:     prometheus.DefBuckets,
+		}, []string{"handler", "code"}),
+		requests: factory.NewCounterVec(prometheus.CounterOpts{
+			Name:        "http_requests_total",
+			Help:        "number of http requests",
+			Namespace:   metric_prefix,
+			ConstLabels: constLabels,
+		}, []string{"handler", "code"}),
+	}
+}
+
+// instrument wraps next to update the metrics under the handler label name. The name
+// is fixed per handler rather than the request path, to keep the label cardinality low.
+func (m *httpMetrics) instrument(name string, next http.Handler) http.Handler {
+	labels := prometheus.Labels{"handler": name}
+	return promhttp.InstrumentHandlerDuration(m.duration.MustCurryWith(labels),
+		promhttp.InstrumentHandlerCounter(m.requests.MustCurryWith(labels), next))
+}
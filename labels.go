@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tedpearson/ambientweatherexporter/weather"
+)
+
+// labelNamePattern matches valid prometheus label names
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedLabels are the label names the exporter sets itself, a static label may not reuse them.
+var reservedLabels = map[string]bool{
+	weather.RemoteAddressLabel: true, weather.LegacyRemoteAddressLabel: true, "name": true, "sensor": true, "type": true, "period": true,
+	"location": true, "status": true, "reason": true, "handler": true, "code": true,
+	"mac": true, "latitude": true, "longitude": true, "altitude": true,
+	"version": true, "goversion": true, "builddate": true, "target": true,
+	"threshold": true, "job": true, "instance": true,
+	"trend": true, "forecast": true,
+}
+
+// parseLabels parses the key=value pairs of the -label flags.
+func parseLabels(pairs []string) (prometheus.Labels, error) {
+	labels := prometheus.Labels{}
+	for _, pair := range pairs {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("label must be key=value: %s", pair)
+		}
+		if !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("invalid label name: %s", name)
+		}
+		if reservedLabels[name] {
+			return nil, fmt.Errorf("label name is used by the exporter: %s", name)
+		}
+		if _, ok := labels[name]; ok {
+			return nil, fmt.Errorf("duplicate label: %s", name)
+		}
+		labels[name] = value
+	}
+	return labels, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseLabels(t *testing.T) {
+	labels, err := parseLabels([]string{"site=roof", "region=eu-west", "empty="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := prometheus.Labels{"site": "roof", "region": "eu-west", "empty": ""}
+	if !maps.Equal(labels, want) {
+		t.Errorf("got %v, want %v", labels, want)
+	}
+
+	for _, pairs := range [][]string{
+		{"site"},
+		{"1site=roof"},
+		{"site-name=roof"},
+		{"__site=roof"},
+		{"sensor=outdoor"},
+		{"remote_address=10.0.0.1"},
+		{"target=upstream"},
+		{"threshold=frost"},
+		{"job=weather"},
+		{"instance=roof"},
+		{"trend=rising"},
+		{"forecast=fine"},
+		{"site=roof", "site=garden"},
+	} {
+		if _, err := parseLabels(pairs); err == nil {
+			t.Errorf("%v: got no error", pairs)
+		}
+	}
+}
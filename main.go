@@ -1,15 +1,29 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"database/sql"
 	"flag"
 	"fmt"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
-	"log"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	_ "modernc.org/sqlite"
 
 	"github.com/tedpearson/ambientweatherexporter/weather"
 )
@@ -22,27 +36,580 @@ var (
 
 func main() {
 	port := flag.Int("port", 2184, "Http server port to listen on")
+	listenAddress := flag.String("listen-address", "",
+		"Address to bind the http server to, e.g. 127.0.0.1. Empty listens on all interfaces")
 	prefix := flag.String("prefix", "",
 		"add metrics prefix %s_(metric_name)")
 	be_verbose := flag.Bool("verbose", false,
-		"More verbose logging.")
+		"More verbose logging, same as -log-level debug.")
+	logLevel := flag.String("log-level", "info",
+		"Log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text",
+		"Log format: text or json")
 	name := flag.String("station-name", "",
 		"Weather station name for the 'name' label on the metrics")
+	units := flag.String("units", weather.UnitsImperial,
+		"Units for the metrics: imperial or metric")
+	staleAfter := flag.Duration("stale-after", 0,
+		"Remove the metrics of a station that hasn't reported for this long, 0 disables")
+	healthMaxAge := flag.Duration("health-max-age", 0,
+		"Fail /healthz when no station has reported for this long, 0 disables")
+	ecowittPath := flag.String("ecowitt-path", "/data/ecowitt",
+		"Http path to receive reports in the Ecowitt protocol on, empty disables")
+	tlsCert := flag.String("tls-cert", "",
+		"TLS certificate file, serves https when set together with -tls-key")
+	tlsKey := flag.String("tls-key", "",
+		"TLS private key file, serves https when set together with -tls-cert")
+	tlsMinVersion := flag.String("tls-min-version", "1.2",
+		"Minimum TLS version: 1.0, 1.1, 1.2 or 1.3")
+	metricsUser := flag.String("metrics-user", "",
+		"Require this basic auth user on the metrics endpoint, needs -metrics-pass")
+	metricsPass := flag.String("metrics-pass", "",
+		"Require this basic auth password on the metrics endpoint, needs -metrics-user")
+	openMetrics := flag.Bool("openmetrics", false,
+		"Serve the OpenMetrics format to scrapers that ask for it, with exemplars of the observation time")
+	var staticLabels stringList
+	flag.Var(&staticLabels, "label",
+		"Add this label to every metric, e.g. site=roof. Repeat for more labels")
+	noRemoteLabel := flag.Bool("no-remote-label", false,
+		"Leave the remote_address label out of the metrics, e.g. with a single station on a dynamic IP")
+	legacyLabelNames := flag.Bool("legacy-label-names", false,
+		"Name the remote_address label remote_adress, as earlier releases did, for existing dashboards")
+	var allowCIDRs stringList
+	flag.Var(&allowCIDRs, "allow-cidr",
+		"Only accept reports from this network, e.g. 192.168.1.0/24. Repeat for more networks")
+	mqttBroker := flag.String("mqtt-broker", "",
+		"Publish observations to this MQTT broker, e.g. tcp://localhost:1883")
+	mqttTopicPrefix := flag.String("mqtt-topic-prefix", "weather",
+		"Topic prefix for published observations")
+	mqttTopic := flag.String("mqtt-topic", "",
+		"Topic template for published observations, e.g. weather/{name}/{metric}/{sensor}, replaces -mqtt-topic-prefix")
+	mqttHADiscovery := flag.Bool("mqtt-ha-discovery", false,
+		"Announce every published value to Home Assistant with MQTT discovery")
+	mqttClientID := flag.String("mqtt-client-id", "ambientweatherexporter",
+		"MQTT client id")
+	mqttUser := flag.String("mqtt-user", "", "MQTT user name")
+	mqttPass := flag.String("mqtt-pass", "", "MQTT password")
+	graphiteAddress := flag.String("graphite-address", "",
+		"Send observations to the plaintext port of this Graphite server, e.g. localhost:2003")
+	graphitePrefix := flag.String("graphite-prefix", "weather",
+		"Path prefix for the values sent to Graphite")
+	statsdAddress := flag.String("statsd-address", "",
+		"Send observations as gauges to this StatsD server, e.g. localhost:8125")
+	statsdPrefix := flag.String("statsd-prefix", "weather",
+		"Name prefix for the gauges sent to StatsD")
+	statsdTags := flag.Bool("statsd-tags", false,
+		"Send the station and labels as DogStatsD tags instead of in the gauge name")
+	csvFile := flag.String("csv-file", "",
+		"Append the raw fields of every report to this CSV file")
+	csvMaxBytes := flag.Int64("csv-max-bytes", weather.DefaultCSVMaxBytes,
+		"Rotate the CSV file when it would grow beyond this size, keeping 3 old files")
+	sqliteDB := flag.String("sqlite-db", "",
+		"Store the values of every report in this SQLite database, it is created when missing")
+	remoteWriteURL := flag.String("remote-write-url", "",
+		"Send all metrics to this Prometheus remote write endpoint, e.g. http://localhost:9090/api/v1/write")
+	remoteWriteUser := flag.String("remote-write-user", "", "Basic auth user for -remote-write-url")
+	remoteWritePassword := flag.String("remote-write-password", "", "Basic auth password for -remote-write-url")
+	remoteWriteInterval := flag.Duration("remote-write-interval", 0,
+		"Send the metrics on this interval instead of after every report")
+	lightningWebhookURL := flag.String("lightning-webhook-url", "",
+		"POST a JSON alert to this url when lightning strikes nearby")
+	lightningDistance := flag.Float64("lightning-distance", weather.DefaultLightningDistance,
+		"Distance in km within which a lightning strike triggers an alert")
+	lightningDebounce := flag.Duration("lightning-debounce", weather.DefaultLightningDebounce,
+		"Minimum time between two lightning alerts of a station")
+	otlpEndpoint := flag.String("otlp-endpoint", "",
+		"Export the gauges as OpenTelemetry metrics to this OTLP http endpoint, e.g. http://localhost:4318")
+	otlpInterval := flag.Duration("otlp-interval", time.Minute,
+		"How often the gauges are exported to -otlp-endpoint")
+	pushgatewayURL := flag.String("pushgateway-url", "",
+		"Push all metrics to the Prometheus Pushgateway at this url, e.g. http://localhost:9091")
+	pushInterval := flag.Duration("push-interval", weather.DefaultPushInterval,
+		"How often the metrics are pushed to -pushgateway-url")
+	pushJob := flag.String("push-job", "ambientweatherexporter",
+		"Job the metrics are pushed to -pushgateway-url as")
+	eventsMaxClients := flag.Int("events-max-clients", weather.DefaultMaxSubscribers,
+		"Maximum number of clients of the /events stream")
+	influxURL := flag.String("influx-url", "",
+		"Write observations to the InfluxDB v2 at this url, e.g. http://localhost:8086")
+	influxToken := flag.String("influx-token", "", "InfluxDB api token")
+	influxOrg := flag.String("influx-org", "", "InfluxDB organization")
+	influxBucket := flag.String("influx-bucket", "weather", "InfluxDB bucket")
+	influxFlushInterval := flag.Duration("influx-flush-interval", 10*time.Second,
+		"How often collected observations are written to InfluxDB")
+	batteryLowVoltage := flag.Float64("battery-low-voltage", weather.DefaultBatteryLowVoltage,
+		"Battery voltage at or below which a voltage-reporting sensor counts as low")
+	altitudeMeters := flag.Float64("altitude-meters", 0,
+		"Station altitude in meters, used to calculate the sea-level pressure. 0 disables it")
+	luxPerWm2 := flag.Float64("lux-per-wm2", weather.DefaultLuxPerWm2,
+		"Lux per W/m2 of solar radiation, used to approximate the illuminance")
+	latitude := flag.Float64("latitude", math.NaN(),
+		"Station latitude in degrees, used for the evapotranspiration without a solar radiation sensor, the sunrise and sunset and station_info")
+	longitude := flag.Float64("longitude", math.NaN(),
+		"Station longitude in degrees, used for the sunrise and sunset and station_info")
+	gddBase := flag.Float64("gdd-base", weather.DefaultGDDBase,
+		"Base temperature in fahrenheit of the growing degree days")
+	timezone := flag.String("timezone", "",
+		"Timezone whose midnight starts a new day for the daily values, e.g. America/Chicago. Empty uses the local timezone")
+	decimalComma := flag.Bool("decimal-comma", false,
+		"Accept a comma as the decimal separator in reported values, e.g. 1013,2")
+	duplicates := flag.String("duplicates", weather.DuplicatesFirst,
+		"Which value to use of a field that is in a report more than once: first or last")
+	maxBodyBytes := flag.Int64("max-body-bytes", weather.DefaultMaxBodyBytes,
+		"Largest accepted report body in bytes, larger reports get 413 Request Entity Too Large")
+	var forwardURLs stringList
+	flag.Var(&forwardURLs, "forward-url",
+		"Relay every report to this server, e.g. the AmbientWeather.net ingest endpoint. Repeat for more servers")
+	pwsWeatherID := flag.String("pwsweather-id", "",
+		"Upload every report to the PWSWeather station with this id, needs -pwsweather-password")
+	pwsWeatherPassword := flag.String("pwsweather-password", "",
+		"API key of the PWSWeather station, needs -pwsweather-id")
+	forwardAttempts := flag.Int("forward-attempts", weather.DefaultForwardAttempts,
+		"How often a report is tried to be forwarded before it is counted as failed")
+	forwardRetryDelay := flag.Duration("forward-retry-delay", weather.DefaultForwardRetryDelay,
+		"Delay before retrying a failed forward, doubled after every next failure")
+	forwardBufferDir := flag.String("forward-buffer-dir", "",
+		"Keep reports that could not be forwarded in this directory and replay them when the target recovers")
+	forwardBufferBytes := flag.Int64("forward-buffer-bytes", weather.DefaultForwardBufferBytes,
+		"Disk space the buffered reports of a target may use, the oldest are dropped beyond it")
+	metricsPath := flag.String("metrics-path", "/metrics",
+		"Http path to serve the prometheus metrics on")
+	reportPath := flag.String("report-path", weather.DefaultReportPath,
+		"Http path the station sends its reports to")
+	readTimeout := flag.Duration("read-timeout", 10*time.Second,
+		"Longest time to read a request, including its body")
+	writeTimeout := flag.Duration("write-timeout", 30*time.Second,
+		"Longest time to write a response")
+	idleTimeout := flag.Duration("idle-timeout", 2*time.Minute,
+		"How long an idle keep-alive connection is kept open")
+	enablePprof := flag.Bool("pprof", false,
+		"Serve profiling data on /debug/pprof/, for diagnosing leaks. Don't expose it publicly")
+	configFile := flag.String("config", "",
+		"Yaml file with options, flags given on the command line take precedence")
 	versionFlag := flag.Bool("v", false, "Show version and exit")
+	if err := applyEnv(flag.CommandLine); err != nil {
+		fatal("failed to read environment", "error", err)
+	}
 	flag.Parse()
 
-	log.SetFlags(log.Flags() &^ (log.Ldate | log.Ltime))
-	log.Println(fmt.Sprintf("ambientweatherexporter version %s built on %s with %s", version, buildDate, goVersion))
-
 	if *versionFlag {
+		fmt.Printf("ambientweatherexporter version %s built on %s with %s\n", version, buildDate, goVersion)
 		os.Exit(0)
 	}
+	config := &Config{}
+	if *configFile != "" {
+		var err error
+		config, err = loadConfig(*configFile)
+		if err != nil {
+			fatal("failed to load config", "error", err)
+		}
+		if err := applyConfig(config, flag.CommandLine); err != nil {
+			fatal("failed to load config", "error", err)
+		}
+	}
+	if *be_verbose {
+		*logLevel = "debug"
+	}
+	logger, err := newLogger(*logLevel, *logFormat)
+	if err != nil {
+		fatal("invalid logging options", "error", err)
+	}
+	slog.SetDefault(logger)
+	slog.Info("starting ambientweatherexporter", "version", version, "build_date", buildDate, "go_version", goVersion)
+
+	if *units != weather.UnitsImperial && *units != weather.UnitsMetric {
+		fatal("unknown units, must be "+weather.UnitsImperial+" or "+weather.UnitsMetric, "units", *units)
+	}
+	if *duplicates != weather.DuplicatesFirst && *duplicates != weather.DuplicatesLast {
+		fatal("unknown -duplicates, must be "+weather.DuplicatesFirst+" or "+weather.DuplicatesLast, "duplicates", *duplicates)
+	}
+	if !strings.HasPrefix(*reportPath, "/") || !strings.HasSuffix(*reportPath, "/") {
+		fatal("-report-path must start and end with /", "report_path", *reportPath)
+	}
+	if !strings.HasPrefix(*metricsPath, "/") {
+		fatal("-metrics-path must start with /", "metrics_path", *metricsPath)
+	}
 	registry := prometheus.NewRegistry()
 	factory := promauto.With(registry)
-	http.Handle("/data/report/", weather.NewParser(*name, *prefix, *be_verbose, &factory))
-	http.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
-	err := http.ListenAndServe(fmt.Sprintf(":%d", *port), nil)
+	constLabels, err := parseLabels(staticLabels)
+	if err != nil {
+		fatal("invalid -label", "error", err)
+	}
+	newBuildInfo(&factory, *prefix, constLabels)
+	parser := weather.NewParser(*name, *prefix, *units, weather.LabelOptions{
+		Const:           constLabels,
+		NoRemoteAddress: *noRemoteLabel,
+		LegacyNames:     *legacyLabelNames,
+	}, &factory)
+	parser.SetStationNames(config.Stations)
+	parser.SetSensorNames(config.Sensors)
+	if err := parser.SetThresholds(config.Thresholds); err != nil {
+		fatal("invalid thresholds in config file", "error", err)
+	}
+	parser.SetBatteryLowVoltage(*batteryLowVoltage)
+	parser.SetAltitude(*altitudeMeters)
+	parser.SetLuxPerWm2(*luxPerWm2)
+	parser.SetGDDBase(*gddBase)
+	parser.SetExemplars(*openMetrics)
+	parser.SetDecimalComma(*decimalComma)
+	parser.SetDuplicates(*duplicates)
+	parser.SetMaxSubscribers(*eventsMaxClients)
+	if *lightningWebhookURL != "" {
+		parser.SetLightningWebhook(*lightningWebhookURL, *lightningDistance, *lightningDebounce)
+	}
+	if *maxBodyBytes <= 0 {
+		fatal("-max-body-bytes must be positive", "max_body_bytes", *maxBodyBytes)
+	}
+	parser.SetMaxBodyBytes(*maxBodyBytes)
+	if *timezone != "" {
+		location, err := time.LoadLocation(*timezone)
+		if err != nil {
+			fatal("invalid -timezone", "timezone", *timezone, "error", err)
+		}
+		parser.SetTimezone(location)
+	}
+	if err := validateCoordinates(*latitude, *longitude); err != nil {
+		fatal("invalid station location", "latitude", *latitude, "longitude", *longitude, "error", err)
+	}
+	if !math.IsNaN(*latitude) {
+		parser.SetLatitude(*latitude)
+	}
+	if !math.IsNaN(*longitude) {
+		parser.SetLongitude(*longitude)
+	}
+	allowedNetworks, err := weather.ParseNetworks(allowCIDRs)
 	if err != nil {
-		panic(err)
+		fatal("invalid -allow-cidr", "error", err)
+	}
+	parser.SetAllowedNetworks(allowedNetworks)
+	parser.ExpireStale(*staleAfter)
+	if *mqttBroker != "" {
+		client := weather.NewMQTTClient(*mqttBroker, *mqttClientID, *mqttUser, *mqttPass)
+		publisher := weather.NewMQTTPublisher(client, *mqttTopicPrefix)
+		publisher.SetTopicTemplate(*mqttTopic)
+		if *mqttHADiscovery {
+			publisher.SetHomeAssistantDiscovery(*units)
+		}
+		parser.AddObserver(publisher)
+	}
+	var forwarder *weather.Forwarder
+	if len(forwardURLs) > 0 {
+		forwarder, err = weather.NewForwarder(forwardURLs, *prefix, constLabels, &factory)
+		if err != nil {
+			fatal("invalid -forward-url", "error", err)
+		}
+		if *forwardAttempts < 1 {
+			fatal("-forward-attempts must be at least 1")
+		}
+		forwarder.SetRetry(*forwardAttempts, *forwardRetryDelay)
+		if *forwardBufferDir != "" {
+			if err := forwarder.SetBuffer(*forwardBufferDir, *forwardBufferBytes); err != nil {
+				fatal("failed to open -forward-buffer-dir", "error", err)
+			}
+		}
+		parser.SetForwarder(forwarder)
+	}
+	if *pwsWeatherID != "" || *pwsWeatherPassword != "" {
+		if *pwsWeatherID == "" || *pwsWeatherPassword == "" {
+			fatal("both -pwsweather-id and -pwsweather-password are needed to upload to PWSWeather")
+		}
+		parser.AddObserver(weather.NewPWSWeatherUploader(*pwsWeatherID, *pwsWeatherPassword, *units, *prefix, constLabels, &factory))
+	}
+	if *graphiteAddress != "" {
+		parser.AddObserver(weather.NewGraphiteSink(weather.NewGraphiteWriter(*graphiteAddress), *graphitePrefix))
+	}
+	// the sinks name the remote address like the metrics label, or leave it out as well
+	remoteTag := parser.RemoteAddressLabel()
+	if *noRemoteLabel {
+		remoteTag = ""
+	}
+	if *statsdAddress != "" {
+		statsd, err := weather.NewStatsDSink(*statsdAddress, *statsdPrefix, *statsdTags, remoteTag)
+		if err != nil {
+			fatal("invalid -statsd-address", "error", err)
+		}
+		parser.AddObserver(statsd)
+	}
+	var csvLog *weather.CSVLog
+	if *csvFile != "" {
+		csvLog, err = weather.OpenCSVLog(*csvFile, *csvMaxBytes)
+		if err != nil {
+			fatal("failed to open -csv-file", "error", err)
+		}
+		parser.SetCSVLog(csvLog)
+	}
+	if *sqliteDB != "" {
+		db, err := sql.Open("sqlite", *sqliteDB)
+		if err != nil {
+			fatal("failed to open -sqlite-db", "error", err)
+		}
+		// a single connection keeps writes from waiting on SQLite's file lock
+		db.SetMaxOpenConns(1)
+		sink, err := weather.NewSQLiteSink(db)
+		if err != nil {
+			fatal("failed to open -sqlite-db", "error", err)
+		}
+		parser.AddObserver(sink)
+	}
+	if *remoteWriteURL != "" {
+		if *remoteWriteInterval < 0 {
+			fatal("-remote-write-interval can't be negative", "remote_write_interval", *remoteWriteInterval)
+		}
+		parser.AddObserver(weather.NewRemoteWriteSink(registry, *remoteWriteURL, *remoteWriteUser, *remoteWritePassword, *remoteWriteInterval))
+	}
+	if *pushgatewayURL != "" {
+		if *pushInterval <= 0 {
+			fatal("-push-interval must be positive", "push_interval", *pushInterval)
+		}
+		// the instance is the station, or the host when the exporter serves several
+		instance := *name
+		if instance == "" {
+			instance, _ = os.Hostname()
+		}
+		weather.NewPusher(registry, *pushgatewayURL, *pushJob, instance, *pushInterval, *prefix, constLabels, &factory)
+	}
+	var meterProvider *sdkmetric.MeterProvider
+	if *otlpEndpoint != "" {
+		if *otlpInterval <= 0 {
+			fatal("-otlp-interval must be positive", "otlp_interval", *otlpInterval)
+		}
+		meterProvider, err = weather.NewOTLPMeterProvider(*otlpEndpoint, *otlpInterval)
+		if err != nil {
+			fatal("invalid -otlp-endpoint", "error", err)
+		}
+		meter := meterProvider.Meter("github.com/tedpearson/ambientweatherexporter")
+		parser.AddObserver(weather.NewOTelSink(meter, *prefix, remoteTag, constLabels))
+	}
+	var influx *weather.InfluxSink
+	if *influxURL != "" {
+		writer := weather.NewInfluxWriter(*influxURL, *influxToken, *influxOrg, *influxBucket)
+		influx = weather.NewInfluxSink(writer, remoteTag, *influxFlushInterval)
+		parser.AddObserver(influx)
+	}
+	parser.SetReportPath(*reportPath)
+	httpMetrics := newHTTPMetrics(&factory, *prefix, constLabels)
+	// not the default mux, net/http/pprof registers itself there
+	mux := http.NewServeMux()
+	mux.Handle(*reportPath, httpMetrics.instrument("report", parser))
+	mux.Handle(weather.LatestPath, httpMetrics.instrument("latest", parser.LatestHandler()))
+	// not instrumented, a stream lasts as long as the client stays, and the wrapper hides
+	// the connection the stream clears the write timeout of
+	mux.Handle(weather.EventsPath, parser.EventsHandler())
+	mux.Handle(weather.JSONPath, httpMetrics.instrument("json", weather.NewJSONHandler(parser)))
+	mux.Handle(weather.WundergroundPath, httpMetrics.instrument("wunderground", weather.NewWundergroundHandler(parser)))
+	weatherCloud := httpMetrics.instrument("weathercloud", weather.NewWeatherCloudHandler(parser))
+	mux.Handle(weather.WeatherCloudPath, weatherCloud)
+	mux.Handle(weather.WeatherCloudPath+"/", weatherCloud)
+	if *ecowittPath != "" {
+		mux.Handle(*ecowittPath, httpMetrics.instrument("ecowitt", weather.NewEcowittHandler(parser)))
+	}
+	var metricsHandler http.Handler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: *openMetrics})
+	if *metricsUser != "" || *metricsPass != "" {
+		if *metricsUser == "" || *metricsPass == "" {
+			fatal("both -metrics-user and -metrics-pass are needed for basic auth")
+		}
+		metricsHandler = basicAuth(metricsHandler, *metricsUser, *metricsPass)
+	}
+	mux.Handle(*metricsPath, httpMetrics.instrument("metrics", metricsHandler))
+	var ready atomic.Bool
+	mux.Handle("/livez", liveHandler())
+	mux.Handle("/healthz", healthHandler(parser.LastReport, *healthMaxAge, time.Now()))
+	mux.Handle("/readyz", readyHandler(&ready))
+	if *enablePprof {
+		slog.Warn("serving profiling data on /debug/pprof/, don't expose it publicly")
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	addr := net.JoinHostPort(*listenAddress, strconv.Itoa(*port))
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		fatal("invalid -listen-address", "listen_address", *listenAddress, "error", err)
+	}
+	// JoinHostPort brackets anything with a colon, only IPv6 addresses may have one
+	if strings.Contains(*listenAddress, ":") && net.ParseIP(*listenAddress) == nil {
+		fatal("invalid -listen-address, give the port with -port", "listen_address", *listenAddress)
+	}
+	// timeouts keep slow clients from tying up connections
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: *readTimeout,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+	}
+	useTLS := *tlsCert != "" || *tlsKey != ""
+	if useTLS {
+		if *tlsCert == "" || *tlsKey == "" {
+			fatal("both -tls-cert and -tls-key are needed to serve https")
+		}
+		minVersion, ok := tlsVersions[*tlsMinVersion]
+		if !ok {
+			fatal("unknown TLS version", "tls_min_version", *tlsMinVersion)
+		}
+		server.TLSConfig = &tls.Config{MinVersion: minVersion}
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal("failed to listen", "address", addr, "error", err)
+	}
+	ready.Store(true)
+	serverErr := make(chan error, 1)
+	go func() {
+		if useTLS {
+			serverErr <- server.ServeTLS(listener, *tlsCert, *tlsKey)
+		} else {
+			serverErr <- server.Serve(listener)
+		}
+	}()
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			reloadStationNames(*configFile, parser)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-serverErr:
+		fatal("http server failed", "error", err)
+	case sig := <-signals:
+		slog.Info("shutting down", "signal", sig.String())
+	}
+	ready.Store(false)
+	// let in-flight reports and scrapes finish
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	// event streams never finish by themselves
+	server.RegisterOnShutdown(parser.CloseEvents)
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("failed to shut down cleanly", "error", err)
+	}
+	if forwarder != nil {
+		// forwards the queued reports, or buffers them when the time is up
+		if err := forwarder.Close(ctx); err != nil {
+			slog.Warn("failed to forward every queued report", "error", err)
+		}
+	}
+	if influx != nil {
+		if err := influx.Flush(); err != nil {
+			slog.Warn("failed to write to InfluxDB", "error", err)
+		}
+	}
+	if csvLog != nil {
+		csvLog.Close()
+	}
+	if meterProvider != nil {
+		// exports the values of the last reports
+		if err := meterProvider.Shutdown(context.Background()); err != nil {
+			slog.Warn("failed to export to OpenTelemetry", "error", err)
+		}
+	}
+}
+
+// reloadStationNames re-reads the station and sensor names from the config file,
+// keeping the current names when the file can't be read.
+func reloadStationNames(configFile string, parser *weather.Parser) {
+	if configFile == "" {
+		slog.Warn("received SIGHUP but there is no -config file to reload")
+		return
+	}
+	config, err := loadConfig(configFile)
+	if err != nil {
+		slog.Warn("failed to reload config, keeping the current names", "config", configFile, "error", err)
+		return
+	}
+	slog.Info("reloaded station names", "config", configFile, "changes", describeChanges(parser.StationNames(), config.Stations))
+	parser.SetStationNames(config.Stations)
+	slog.Info("reloaded sensor names", "config", configFile, "changes", describeChanges(parser.SensorNames(), config.Sensors))
+	parser.SetSensorNames(config.Sensors)
+}
+
+// newLogger creates the logger for the -log-level and -log-format options.
+func newLogger(level string, format string) (*slog.Logger, error) {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: logLevel}
+	switch format {
+	case "text":
+		// the time is left out like before, journald already adds it
+		opts.ReplaceAttr = func(groups []string, attr slog.Attr) slog.Attr {
+			if attr.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return attr
+		}
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, must be text or json", format)
+	}
+}
+
+// fatal logs an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// shutdownTimeout is how long in-flight requests get to finish on shutdown.
+const shutdownTimeout = 10 * time.Second
+
+// stringList is a flag that can be repeated to collect several values.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// validateCoordinates checks the -latitude and -longitude flags, NaN is a flag that isn't set.
+func validateCoordinates(latitude float64, longitude float64) error {
+	if latitude < -90 || latitude > 90 {
+		return fmt.Errorf("-latitude must be between -90 and 90")
+	}
+	if longitude < -180 || longitude > 180 {
+		return fmt.Errorf("-longitude must be between -180 and 180")
+	}
+	return nil
+}
+
+// newBuildInfo registers a build_info gauge carrying the version information as labels.
+func newBuildInfo(factory *promauto.Factory, metric_prefix string, constLabels prometheus.Labels) prometheus.Gauge {
+	labels := prometheus.Labels{
+		"version":   version,
+		"goversion": goVersion,
+		"builddate": buildDate,
+	}
+	for name, value := range constLabels {
+		labels[name] = value
 	}
+	gauge := factory.NewGauge(prometheus.GaugeOpts{
+		Name:        "build_info",
+		Help:        "build information about the running ambientweatherexporter",
+		Namespace:   metric_prefix,
+		ConstLabels: labels,
+	})
+	gauge.Set(1)
+	return gauge
 }
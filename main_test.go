@@ -0,0 +1,47 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNewBuildInfo(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	factory := promauto.With(registry)
+	newBuildInfo(&factory, "weather", prometheus.Labels{"site": "garden"})
+
+	want := `
+# HELP weather_build_info build information about the running ambientweatherexporter
+# TYPE weather_build_info gauge
+weather_build_info{builddate="unknown",goversion="unknown",site="garden",version="development"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(want), "weather_build_info"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateCoordinates(t *testing.T) {
+	tests := []struct {
+		latitude, longitude float64
+		ok                  bool
+	}{
+		{52.37, 4.89, true},
+		{-90, -180, true},
+		{90, 180, true},
+		{math.NaN(), math.NaN(), true},
+		{90.1, 4.89, false},
+		{-91, 4.89, false},
+		{52.37, 180.5, false},
+		{52.37, -181, false},
+	}
+	for _, test := range tests {
+		if err := validateCoordinates(test.latitude, test.longitude); (err == nil) != test.ok {
+			t.Errorf("validateCoordinates(%v, %v) = %v, want ok %v", test.latitude, test.longitude, err, test.ok)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package weather
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseNetworks parses CIDRs like 192.168.1.0/24 or 2001:db8::/32. A plain address
+// is taken as a network with only that address.
+func ParseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address: %s", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// SetAllowedNetworks restricts the report endpoints to senders from networks.
+// An empty list allows every sender.
+func (p *Parser) SetAllowedNetworks(networks []*net.IPNet) {
+	p.allowedNetworks = networks
+}
+
+// allowed checks the sender of req against the allowed networks and responds with
+// 403 Forbidden when it isn't allowed to report.
+func (p *Parser) allowed(resp http.ResponseWriter, req *http.Request) bool {
+	if len(p.allowedNetworks) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, network := range p.allowedNetworks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+	p.Log("rejected report, not in an allowed network", "remote_address", req.RemoteAddr)
+	http.Error(resp, "Forbidden", http.StatusForbidden)
+	return false
+}
@@ -0,0 +1,49 @@
+package weather
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseNetworksInvalid(t *testing.T) {
+	for _, cidr := range []string{"192.168.1.0/33", "garden", "192.168.1", "2001:db8::/129", ""} {
+		if _, err := ParseNetworks([]string{cidr}); err == nil {
+			t.Errorf("%q was accepted", cidr)
+		}
+	}
+}
+
+func TestAllowedNetworks(t *testing.T) {
+	networks, err := ParseNetworks([]string{"192.168.1.0/24", "10.0.0.7", "2001:db8::/32", "fd00::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	parser.SetAllowedNetworks(networks)
+	tests := []struct {
+		remoteAddr string
+		want       int
+	}{
+		{"192.168.1.5:54321", http.StatusNoContent},
+		{"10.0.0.7:54321", http.StatusNoContent},
+		{"[2001:db8::5]:54321", http.StatusNoContent},
+		{"[fd00::1]:54321", http.StatusNoContent},
+		{"192.168.2.5:54321", http.StatusForbidden},
+		{"10.0.0.8:54321", http.StatusForbidden},
+		{"[2001:db9::5]:54321", http.StatusForbidden},
+		{"[fd00::2]:54321", http.StatusForbidden},
+		// malformed addresses are never allowed
+		{"garden:54321", http.StatusForbidden},
+		{"", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/data/report/?tempf=71.2", nil)
+		req.RemoteAddr = test.remoteAddr
+		resp := httptest.NewRecorder()
+		parser.ServeHTTP(resp, req)
+		if resp.Code != test.want {
+			t.Errorf("%q: got %d, want %d", test.remoteAddr, resp.Code, test.want)
+		}
+	}
+}
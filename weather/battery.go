@@ -0,0 +1,24 @@
+package weather
+
+// DefaultBatteryLowVoltage is the voltage at or below which a single cell sensor battery is low.
+const DefaultBatteryLowVoltage = 1.2
+
+// SetBatteryLowVoltage sets the voltage at or below which the battery of a
+// voltage-reporting sensor counts as low.
+func (p *Parser) SetBatteryLowVoltage(voltage float64) {
+	p.batteryLowVoltage = voltage
+}
+
+// normalizeBattery turns the value of a battery field into 1 = ok; 0 = low. Most sensors
+// send that status directly, but some probes send their battery voltage instead, e.g. 1.3.
+// Any value other than 0 or 1 is taken as a voltage and compared with the low voltage
+// threshold, in which case voltage is true.
+func (p *Parser) normalizeBattery(value float64) (ok float64, voltage bool) {
+	if value == 0 || value == 1 {
+		return value, false
+	}
+	if value > p.batteryLowVoltage {
+		return 1, true
+	}
+	return 0, true
+}
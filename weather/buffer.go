@@ -0,0 +1,122 @@
+package weather
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultForwardBufferBytes caps the disk space the reports buffered for a target may use.
+const DefaultForwardBufferBytes = 50 << 20
+
+// forwardReplayInterval is how often a target with buffered reports is checked for
+// recovery when no new reports arrive.
+const forwardReplayInterval = time.Minute
+
+// unsafeFileChars matches the characters of a target name that are replaced to use it
+// as a directory name
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// forwardBuffer keeps the reports a target couldn't receive in a directory, a file per
+// report named by the time it was buffered, so they can be replayed in order. It is only
+// used by the worker of its target.
+type forwardBuffer struct {
+	dir      string
+	maxBytes int64
+	// files are the buffered reports, oldest first
+	files []bufferedReport
+	size  int64
+	seq   int
+}
+
+type bufferedReport struct {
+	name string
+	size int64
+}
+
+// openForwardBuffer opens the buffer of a target in a subdirectory of dir, picking up the
+// reports that were buffered before a restart. The subdirectory is named after the unique
+// name of the target, see forwardTargetName, so targets that only differ in their query
+// don't share a buffer.
+func openForwardBuffer(dir string, targetName string, maxBytes int64) (*forwardBuffer, error) {
+	b := &forwardBuffer{
+		dir:      filepath.Join(dir, strings.Trim(unsafeFileChars.ReplaceAllString(targetName, "_"), "_")),
+		maxBytes: maxBytes,
+	}
+	// the reports hold the PASSKEY, keep them private
+	if err := os.MkdirAll(b.dir, 0o700); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		b.files = append(b.files, bufferedReport{name: entry.Name(), size: info.Size()})
+		b.size += info.Size()
+	}
+	sort.Slice(b.files, func(i, j int) bool { return b.files[i].name < b.files[j].name })
+	return b, nil
+}
+
+// len returns the number of buffered reports.
+func (b *forwardBuffer) len() int {
+	return len(b.files)
+}
+
+// push buffers a report after the others, evicting the oldest reports while the buffer
+// is over its size. It returns the number of evicted reports.
+func (b *forwardBuffer) push(request forwardRequest) (int, error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return 0, err
+	}
+	b.seq++
+	name := fmt.Sprintf("%020d-%06d.json", time.Now().UnixNano(), b.seq%1000000)
+	if err := os.WriteFile(filepath.Join(b.dir, name), data, 0o600); err != nil {
+		return 0, err
+	}
+	b.files = append(b.files, bufferedReport{name: name, size: int64(len(data))})
+	b.size += int64(len(data))
+	evicted := 0
+	for b.size > b.maxBytes && len(b.files) > 1 {
+		if err := b.pop(); err != nil {
+			return evicted, err
+		}
+		evicted++
+	}
+	return evicted, nil
+}
+
+// oldest returns the report that was buffered first.
+func (b *forwardBuffer) oldest() (forwardRequest, error) {
+	var request forwardRequest
+	data, err := os.ReadFile(filepath.Join(b.dir, b.files[0].name))
+	if err != nil {
+		return request, err
+	}
+	err = json.Unmarshal(data, &request)
+	return request, err
+}
+
+// pop removes the report that was buffered first.
+func (b *forwardBuffer) pop() error {
+	if err := os.Remove(filepath.Join(b.dir, b.files[0].name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	b.size -= b.files[0].size
+	b.files = b.files[1:]
+	return nil
+}
@@ -0,0 +1,136 @@
+package weather
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestForwardBufferEvictsOldest(t *testing.T) {
+	dir := t.TempDir()
+	request := func(i int) forwardRequest {
+		return forwardRequest{Method: http.MethodGet, URL: "http://example.com/data/report/?tempf=" + strconv.Itoa(i), RemoteAddress: "192.168.1.5"}
+	}
+	buffer, err := openForwardBuffer(dir, "example.com", 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := buffer.push(request(0)); err != nil {
+		t.Fatal(err)
+	}
+	// room for three reports
+	buffer.maxBytes = 3 * buffer.size
+	evicted := 0
+	for i := 1; i < 5; i++ {
+		n, err := buffer.push(request(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		evicted += n
+	}
+	if evicted != 2 || buffer.len() != 3 {
+		t.Fatalf("got %d evicted and %d buffered reports, want 2 and 3", evicted, buffer.len())
+	}
+
+	// a restart picks up the buffered reports in order
+	reopened, err := openForwardBuffer(dir, "example.com", buffer.maxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 2; i < 5; i++ {
+		got, err := reopened.oldest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != request(i) {
+			t.Errorf("got %+v, want %+v", got, request(i))
+		}
+		if err := reopened.pop(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reopened.len() != 0 || reopened.size != 0 {
+		t.Errorf("got %d reports of %d bytes left, want none", reopened.len(), reopened.size)
+	}
+}
+
+func TestForwardBufferPerTarget(t *testing.T) {
+	const wunderground = "wunderground=https://rtupdate.wunderground.com/weatherstation/updateweatherstation.php"
+	forwarder := newTestForwarder(t, wunderground+"?ID=KXX1&PASSWORD=a", wunderground+"?ID=KXX2&PASSWORD=b")
+	dir := t.TempDir()
+	if err := forwarder.SetBuffer(dir, DefaultForwardBufferBytes); err != nil {
+		t.Fatal(err)
+	}
+	first, second := forwarder.targets[0].buffer.dir, forwarder.targets[1].buffer.dir
+	if first == second {
+		t.Errorf("both targets buffer in %s", first)
+	}
+	for _, buffer := range []string{first, second} {
+		if filepath.Dir(buffer) != dir || strings.Contains(buffer, "PASSWORD") {
+			t.Errorf("got buffer %s, want a directory in %s without the credentials", buffer, dir)
+		}
+	}
+}
+
+func TestForwardReplaysAfterOutage(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	var mu sync.Mutex
+	var received []string
+	backend := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if down.Load() {
+			resp.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		mu.Lock()
+		received = append(received, req.URL.Query().Get("tempf"))
+		mu.Unlock()
+	}))
+	defer backend.Close()
+	forwarder := newTestForwarder(t, backend.URL)
+	forwarder.SetRetry(1, 0)
+	if err := forwarder.SetBuffer(t.TempDir(), DefaultForwardBufferBytes); err != nil {
+		t.Fatal(err)
+	}
+	target := forwarder.targets[0].name
+
+	for i := 0; i < 3; i++ {
+		req, values := newReportRequest(t, "/data/report/?PASSKEY=AB&tempf="+strconv.Itoa(70+i))
+		forwarder.Forward(req, values, true)
+	}
+	waitFor(t, "the reports to be buffered", func() bool {
+		return testutil.ToFloat64(forwarder.buffered.WithLabelValues(target)) == 3
+	})
+
+	// the next report replays the buffered ones before it
+	down.Store(false)
+	req, values := newReportRequest(t, "/data/report/?PASSKEY=AB&tempf=73")
+	forwarder.Forward(req, values, true)
+	waitFor(t, "the replay", func() bool {
+		return testutil.ToFloat64(forwarder.sent.WithLabelValues(target)) == 4
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"70", "71", "72", "73"}
+	if len(received) != len(want) {
+		t.Fatalf("got reports %v, want %v", received, want)
+	}
+	for i := range want {
+		if received[i] != want[i] {
+			t.Fatalf("got reports %v, want %v", received, want)
+		}
+	}
+	if got := testutil.ToFloat64(forwarder.buffered.WithLabelValues(target)); got != 0 {
+		t.Errorf("got %v buffered reports after the replay, want 0", got)
+	}
+	if got := testutil.ToFloat64(forwarder.failures.WithLabelValues(target)); got != 0 {
+		t.Errorf("got %v failures, want 0", got)
+	}
+}
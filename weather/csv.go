@@ -0,0 +1,175 @@
+package weather
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCSVMaxBytes is the size at which the CSV log is rotated.
+const DefaultCSVMaxBytes = 10 << 20
+
+// csvBackups is how many rotated CSV logs are kept, as <file>.1 (the newest) to <file>.3.
+const csvBackups = 3
+
+// csvFields are the report fields that get a column in the CSV log, in a fixed order so
+// every file has the same header. New fields are only ever added at the end. The PASSKEY
+// is left out, the station is identified by its address and name.
+var csvFields = []string{
+	"dateutc", "stationtype", "mac",
+	"tempf", "humidity", "tempinf", "humidityin", "baromrelin", "baromabsin",
+	"winddir", "winddir_avg2m", "winddir_avg10m", "windgustdir",
+	"windspeedmph", "windspdmph_avg2m", "windspdmph_avg10m", "windgustmph", "maxdailygust",
+	"rainratein", "hourlyrainin", "eventrainin", "dailyrainin", "weeklyrainin", "monthlyrainin",
+	"yearlyrainin", "totalrainin", "solarradiation", "uv",
+	"lightning_day", "lightning_distance", "lightning_time",
+	"pm25", "pm25_24h", "pm25_in", "pm25_in_24h", "pm10_aqin", "co2", "co2_in", "co2_in_24h",
+	"battout", "battin", "batt_lightning",
+}
+
+func init() {
+	for i := 1; i <= 10; i++ {
+		iStr := strconv.Itoa(i)
+		csvFields = append(csvFields, "temp"+iStr+"f", "humidity"+iStr, "batt"+iStr,
+			"soiltemp"+iStr+"f", "soilhum"+iStr, "battsm"+iStr, "leafwetness"+iStr, "battleaf"+iStr)
+	}
+	for i := 1; i <= 4; i++ {
+		iStr := strconv.Itoa(i)
+		csvFields = append(csvFields, "leak"+iStr, "batleak"+iStr)
+	}
+}
+
+// csvHeader returns the header row of the CSV log.
+func csvHeader() []string {
+	return append([]string{"received", RemoteAddressLabel, "name"}, csvFields...)
+}
+
+// CSVLog appends a row with the raw fields of every report to a file, and rotates the
+// file when it grows beyond its maximum size.
+type CSVLog struct {
+	path     string
+	maxBytes int64
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+}
+
+// OpenCSVLog opens the CSV log at path, appending to it when it exists. A file with a
+// different header, e.g. from an older release, is rotated first.
+func OpenCSVLog(path string, maxBytes int64) (*CSVLog, error) {
+	l := &CSVLog{path: path, maxBytes: maxBytes}
+	if header, err := readCSVHeader(path); err == nil && header != "" && header != strings.Join(csvHeader(), ",") {
+		if err := l.rotate(); err != nil {
+			return nil, err
+		}
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// SetCSVLog appends every report to log.
+func (p *Parser) SetCSVLog(log *CSVLog) {
+	p.csvLog = log
+}
+
+// Write appends a row for a report received from remote_address.
+func (l *CSVLog) Write(received time.Time, remote_address string, name string, values url.Values) {
+	row := []string{received.UTC().Format(time.RFC3339), remote_address, name}
+	for _, field := range csvFields {
+		row = append(row, stripNewlines(values.Get(field)))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.write(row); err != nil {
+		slog.Warn("failed to write the CSV log", "path", l.path, "error", err)
+	}
+}
+
+func (l *CSVLog) write(row []string) error {
+	var line strings.Builder
+	writer := csv.NewWriter(&line)
+	writer.Write(row)
+	writer.Flush()
+	if l.size > 0 && l.size+int64(line.Len()) > l.maxBytes {
+		l.file.Close()
+		if err := l.rotate(); err != nil {
+			return err
+		}
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.WriteString(line.String())
+	l.size += int64(n)
+	return err
+}
+
+// open opens the file for appending and writes the header to a new file.
+func (l *CSVLog) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.size = info.Size()
+	if l.size == 0 {
+		var header strings.Builder
+		writer := csv.NewWriter(&header)
+		writer.Write(csvHeader())
+		writer.Flush()
+		n, err := file.WriteString(header.String())
+		l.size += int64(n)
+		return err
+	}
+	return nil
+}
+
+// rotate moves the file to <file>.1, shifting the older backups and dropping the oldest.
+func (l *CSVLog) rotate() error {
+	for i := csvBackups - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Close closes the file.
+func (l *CSVLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// readCSVHeader returns the first line of the file at path.
+func readCSVHeader(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+	if !scanner.Scan() {
+		return "", scanner.Err()
+	}
+	return scanner.Text(), nil
+}
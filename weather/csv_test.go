@@ -0,0 +1,136 @@
+package weather
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readCSV returns the rows of the CSV file at path.
+func readCSV(t *testing.T, path string) [][]string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func TestCSVLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reports.csv")
+	log, err := OpenCSVLog(path, DefaultCSVMaxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	log.Write(received, "192.168.1.5", "garden", url.Values{"PASSKEY": {"48:3F:DA:54:2C:6E"}, "tempf": {"71.2"}, "humidity": {"40"}})
+	// a station with other fields writes the same columns
+	log.Write(received, "192.168.1.6", "", url.Values{"uv": {"4"}, "unknownfield": {"1"}})
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := readCSV(t, path)
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, want a header and 2 rows", len(rows))
+	}
+	if !slices.Equal(rows[0], csvHeader()) {
+		t.Errorf("got header %v, want %v", rows[0], csvHeader())
+	}
+	column := func(row []string, field string) string {
+		return row[slices.Index(rows[0], field)]
+	}
+	for _, test := range []struct {
+		row   []string
+		field string
+		want  string
+	}{
+		{rows[1], "received", "2024-06-01T12:00:00Z"},
+		{rows[1], RemoteAddressLabel, "192.168.1.5"},
+		{rows[1], "name", "garden"},
+		{rows[1], "tempf", "71.2"},
+		{rows[1], "humidity", "40"},
+		{rows[1], "uv", ""},
+		{rows[2], "tempf", ""},
+		{rows[2], "uv", "4"},
+	} {
+		if got := column(test.row, test.field); got != test.want {
+			t.Errorf("%s = %q, want %q", test.field, got, test.want)
+		}
+	}
+	for _, row := range rows {
+		if slices.Contains(row, "48:3F:DA:54:2C:6E") {
+			t.Errorf("the PASSKEY was logged: %v", row)
+		}
+	}
+
+	// reopening appends without a second header
+	log, err = OpenCSVLog(path, DefaultCSVMaxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Write(received, "192.168.1.5", "garden", url.Values{"tempf": {"72.5"}})
+	log.Close()
+	if rows := readCSV(t, path); len(rows) != 4 {
+		t.Errorf("got %d rows after reopening, want 4", len(rows))
+	}
+}
+
+func TestCSVLogRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reports.csv")
+	// room for the header and about two rows
+	headerSize := int64(len(strings.Join(csvHeader(), ",")) + 1)
+	log, err := OpenCSVLog(path, headerSize+2*(int64(len(csvFields))+40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		log.Write(received, "192.168.1.5", "", url.Values{"tempf": {fmt.Sprint(70 + i)}})
+	}
+	log.Close()
+
+	for _, name := range []string{path, path + ".1", path + ".2", path + ".3"} {
+		rows := readCSV(t, name)
+		if len(rows) < 2 || !slices.Equal(rows[0], csvHeader()) {
+			t.Errorf("%s: got %d rows starting with %v, want a header and rows", filepath.Base(name), len(rows), rows[0])
+		}
+	}
+	if _, err := os.Stat(path + ".4"); !os.IsNotExist(err) {
+		t.Errorf("got a fourth backup, want %d", csvBackups)
+	}
+	// the newest row is in the current file
+	rows := readCSV(t, path)
+	if got := rows[len(rows)-1][slices.Index(rows[0], "tempf")]; got != "79" {
+		t.Errorf("got tempf %s in the last row, want 79", got)
+	}
+}
+
+func TestCSVLogRotatesAnOtherHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reports.csv")
+	if err := os.WriteFile(path, []byte("received,remote_adress,tempf\n2024-06-01T12:00:00Z,192.168.1.5,71.2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	log, err := OpenCSVLog(path, DefaultCSVMaxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Close()
+	if rows := readCSV(t, path); len(rows) != 1 || !slices.Equal(rows[0], csvHeader()) {
+		t.Errorf("got %v, want a new file with the current header", rows)
+	}
+	if rows := readCSV(t, path+".1"); rows[0][1] != "remote_adress" {
+		t.Errorf("the old file wasn't kept: %v", rows)
+	}
+}
@@ -0,0 +1,89 @@
+package weather
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// EcowittHandler accepts reports pushed by Ecowitt gateways (GW1000, GW2000, ...) in the
+// Ecowitt protocol and feeds them through the Parser.
+type EcowittHandler struct {
+	parser *Parser
+}
+
+func NewEcowittHandler(parser *Parser) *EcowittHandler {
+	return &EcowittHandler{parser: parser}
+}
+
+func (h *EcowittHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if !h.parser.allowed(resp, req) || !reportMethod(resp, req) {
+		return
+	}
+	remote_address, values, err := h.parser.readReport(resp, req)
+	if h.parser.rejectTooLarge(resp, remote_address, err) {
+		return
+	}
+	// ecowitt gateways expect a 200 response
+	resp.WriteHeader(http.StatusOK)
+	translated := h.parser.translateFields(values, ecowittFields)
+	h.parser.countReport(remote_address, translated, err)
+	h.parser.handleReport(req, remote_address, translated, false)
+}
+
+// ecowittFields holds the Ecowitt fields whose name or encoding differs from the
+// Ambient Weather protocol. Fields that are not listed are passed through unchanged.
+var ecowittFields = map[string]fieldTranslation{
+	"wh65batt":         {"battout", batteryFromLowFlag},
+	"wh26batt":         {"battout", batteryFromLowFlag},
+	"wh80batt":         {"battout", batteryFromVoltage(2.4)},
+	"wh25batt":         {"battin", batteryFromLowFlag},
+	"wh57batt":         {"batt_lightning", batteryFromLevel},
+	"lightning_num":    {"lightning_day", nil},
+	"lightning":        {"lightning_distance", nil},
+	"pm25_ch1":         {"pm25", nil},
+	"pm25_avg_24h_ch1": {"pm25_24h", nil},
+}
+
+func init() {
+	for i := 1; i <= 8; i++ {
+		iStr := strconv.Itoa(i)
+		ecowittFields["batt"+iStr] = fieldTranslation{"batt" + iStr, batteryFromLowFlag}
+		ecowittFields["soilmoisture"+iStr] = fieldTranslation{"soilhum" + iStr, nil}
+		// soil and leaf probe voltages are passed on, the parser applies the low voltage threshold
+		ecowittFields["soilbatt"+iStr] = fieldTranslation{"battsm" + iStr, nil}
+		ecowittFields["tf_ch"+iStr] = fieldTranslation{"soiltemp" + iStr + "f", nil}
+		ecowittFields["leafwetness_ch"+iStr] = fieldTranslation{"leafwetness" + iStr, nil}
+		ecowittFields["leaf_batt"+iStr] = fieldTranslation{"battleaf" + iStr, nil}
+	}
+	for i := 1; i <= 4; i++ {
+		iStr := strconv.Itoa(i)
+		ecowittFields["leak_ch"+iStr] = fieldTranslation{"leak" + iStr, nil}
+		ecowittFields["leakbatt"+iStr] = fieldTranslation{"batleak" + iStr, batteryFromLevel}
+	}
+}
+
+// batteryFromLowFlag converts an Ecowitt 0 = ok; 1 = low flag to 1 = ok; 0 = low.
+func batteryFromLowFlag(low float64) float64 {
+	if low == 0 {
+		return 1
+	}
+	return 0
+}
+
+// batteryFromLevel converts an Ecowitt 0-5 battery level to 1 = ok; 0 = low.
+func batteryFromLevel(level float64) float64 {
+	if level > 1 {
+		return 1
+	}
+	return 0
+}
+
+// batteryFromVoltage returns a converter from a battery voltage to 1 = ok; 0 = low.
+func batteryFromVoltage(low float64) func(float64) float64 {
+	return func(voltage float64) float64 {
+		if voltage > low {
+			return 1
+		}
+		return 0
+	}
+}
@@ -0,0 +1,55 @@
+package weather
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestEcowittReport feeds a report POSTed by a GW2000 gateway through the handler.
+func TestEcowittReport(t *testing.T) {
+	fixture, err := os.ReadFile("testdata/ecowitt_gw2000_report.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	req := httptest.NewRequest(http.MethodPost, "/data/report/", strings.NewReader(strings.TrimSpace(string(fixture))))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = "192.168.1.30:51234"
+	resp := httptest.NewRecorder()
+	NewEcowittHandler(parser).ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("got response %d, want 200", resp.Code)
+	}
+
+	const remote = "192.168.1.30"
+	for _, test := range []struct {
+		gauge  *stationGaugeVec
+		labels []string
+		want   float64
+	}{
+		{parser.temperature, []string{remote, "", "outdoor"}, 68.4},
+		{parser.temperature, []string{remote, "", "indoor"}, 74.3},
+		{parser.temperature, []string{remote, "", "1"}, 70.7},
+		{parser.humidity, []string{remote, "", "outdoor"}, 61},
+		{parser.humidity, []string{remote, "", "soil1"}, 34},
+		{parser.barometer, []string{remote, "", "relative"}, 29.917},
+		{parser.windSpeedMph, []string{remote, "", "gusts"}, 6.93},
+		{parser.rainIn, []string{remote, "", "daily"}, 0.118},
+		{parser.pm25, []string{remote, "", "outdoor", "current"}, 8},
+		{parser.pm25, []string{remote, "", "outdoor", "avg24h"}, 9.2},
+		{parser.lightning_strikes, []string{remote, "", "day"}, 3},
+		{parser.lightning_distance, []string{remote, ""}, 14},
+		// wh65batt=0, batt1=0 and wh57batt=5 are ok, wh25batt=1 is low
+		{parser.battery, []string{remote, "", "outdoor"}, 1},
+		{parser.battery, []string{remote, "", "indoor"}, 0},
+		{parser.battery, []string{remote, "", "lightning"}, 1},
+		{parser.battery, []string{remote, "", "1"}, 1},
+	} {
+		if got := gaugeValue(test.gauge, test.labels...); !approxEqual(got, test.want, 0.001) {
+			t.Errorf("%v: got %v, want %v", test.labels, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package weather
+
+import (
+	"math"
+	"time"
+)
+
+// maxET0Interval limits the time a single report accounts for in the daily evapotranspiration,
+// so a station that was offline for hours doesn't add its last rate for all that time.
+const maxET0Interval = 30 * time.Minute
+
+// et0Inputs holds the readings the reference evapotranspiration is calculated from.
+type et0Inputs struct {
+	tempF          float64
+	humidity       float64
+	windSpeedMph   float64
+	solarRadiation float64
+	pressureKPa    float64
+	hasHumidity    bool
+	hasWind        bool
+	hasSolar       bool
+}
+
+// dailyET0 is the evapotranspiration of a station accumulated since local midnight.
+type dailyET0 struct {
+	day   string
+	total float64
+	last  time.Time
+	tMinF float64
+	tMaxF float64
+}
+
+// SetLatitude sets the station latitude in degrees, needed to estimate the
+// evapotranspiration of stations without a solar radiation sensor.
+func (p *Parser) SetLatitude(latitude float64) {
+	p.latitude = &latitude
+}
+
+// accumulateET0 adds the evapotranspiration since the previous report of a station to
+// its daily total and returns the total in mm. It returns false when there is no
+// method that works with the available readings.
+func (p *Parser) accumulateET0(remote_address string, received time.Time, in et0Inputs) (float64, bool) {
+	p.et0Mu.Lock()
+	defer p.et0Mu.Unlock()
+	local := received.In(p.timezone)
+	day := local.Format(time.DateOnly)
+	state, ok := p.et0[remote_address]
+	if !ok || state.day != day {
+		midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, p.timezone)
+		last := received
+		if ok && state.last.Before(midnight) {
+			last = midnight
+		}
+		state = &dailyET0{day: day, last: last, tMinF: in.tempF, tMaxF: in.tempF}
+		p.et0[remote_address] = state
+	}
+	state.tMinF = math.Min(state.tMinF, in.tempF)
+	state.tMaxF = math.Max(state.tMaxF, in.tempF)
+
+	var ratePerHour float64
+	switch {
+	case in.hasSolar && in.hasWind && in.hasHumidity:
+		ratePerHour = calculateET0PenmanMonteith(in.tempF, in.humidity, in.windSpeedMph, in.solarRadiation, in.pressureKPa)
+	case p.latitude != nil:
+		ratePerHour = calculateET0Hargreaves(state.tMinF, state.tMaxF, *p.latitude, local.YearDay()) / 24
+	default:
+		return 0, false
+	}
+	elapsed := received.Sub(state.last)
+	if elapsed > maxET0Interval {
+		elapsed = maxET0Interval
+	}
+	if elapsed > 0 {
+		state.total += ratePerHour * elapsed.Hours()
+	}
+	state.last = received
+	return state.total, true
+}
+
+// calculateET0PenmanMonteith returns the hourly reference evapotranspiration in mm/h
+// following the FAO-56 Penman-Monteith equation for hourly time steps (equation 53),
+// see https://www.fao.org/3/x0490e/x0490e08.htm. The wind is taken as measured at 2 m and
+// the net radiation as the shortwave radiation absorbed by grass, the net longwave
+// radiation is left out.
+func calculateET0PenmanMonteith(tempF float64, rh float64, windSpeedMph float64, solarRadiation float64, pressureKPa float64) float64 {
+	t := fahrenheitToCelsius(tempF)
+	es := 0.6108 * math.Exp(17.27*t/(t+237.3))
+	ea := es * rh / 100
+	slope := 4098 * es / math.Pow(t+237.3, 2)
+	gamma := 0.000665 * pressureKPa
+	u2 := mphToMs(windSpeedMph)
+	// W/m2 to MJ/m2 per hour, with the albedo of grass of 0.23
+	rn := 0.77 * solarRadiation * 0.0036
+	g := 0.5 * rn
+	if solarRadiation > 0 {
+		g = 0.1 * rn
+	}
+	et0 := (0.408*slope*(rn-g) + gamma*37/(t+273)*u2*(es-ea)) / (slope + gamma*(1+0.34*u2))
+	return math.Max(et0, 0)
+}
+
+// calculateET0Hargreaves returns the daily reference evapotranspiration in mm/day from the
+// minimum and maximum temperature of the day, following the Hargreaves equation (FAO-56
+// equation 52). It needs no solar radiation or wind, but is less accurate.
+func calculateET0Hargreaves(tMinF float64, tMaxF float64, latitude float64, dayOfYear int) float64 {
+	tMin := fahrenheitToCelsius(tMinF)
+	tMax := fahrenheitToCelsius(tMaxF)
+	ra := extraterrestrialRadiation(latitude, dayOfYear)
+	return 0.0023 * ((tMin+tMax)/2 + 17.8) * math.Sqrt(tMax-tMin) * 0.408 * ra
+}
+
+// extraterrestrialRadiation returns the daily solar radiation at the top of the atmosphere
+// in MJ/m2 per day, following FAO-56 equation 21.
+func extraterrestrialRadiation(latitude float64, dayOfYear int) float64 {
+	phi := latitude * math.Pi / 180
+	j := float64(dayOfYear)
+	dr := 1 + 0.033*math.Cos(2*math.Pi*j/365)
+	declination := 0.409 * math.Sin(2*math.Pi*j/365-1.39)
+	// the sunset hour angle, clamped for the polar day and night
+	omega := math.Acos(math.Max(-1, math.Min(1, -math.Tan(phi)*math.Tan(declination))))
+	ra := 24 * 60 / math.Pi * 0.0820 * dr *
+		(omega*math.Sin(phi)*math.Sin(declination) + math.Cos(phi)*math.Cos(declination)*math.Sin(omega))
+	return math.Max(ra, 0)
+}
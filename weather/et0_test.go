@@ -0,0 +1,72 @@
+package weather
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCalculateET0PenmanMonteith(t *testing.T) {
+	// FAO-56 example 19, 14-15h at N'Diaye: 38°C, 52%, 3.3 m/s and 2.450 MJ/m2 per hour give
+	// 0.63 mm/h. Leaving out the net longwave radiation estimates a little more.
+	got := calculateET0PenmanMonteith(celsiusToFahrenheit(38), 52, 3.3/0.44704, 2.450/0.0036, 101.2)
+	if !approxEqual(got, 0.63, 0.05) {
+		t.Errorf("ET0 of FAO-56 example 19 = %v mm/h, want about 0.63", got)
+	}
+	if got := calculateET0PenmanMonteith(50, 100, 0, 0, 101.3); got != 0 {
+		t.Errorf("ET0 of saturated air at night = %v mm/h, want 0", got)
+	}
+}
+
+func TestExtraterrestrialRadiation(t *testing.T) {
+	// FAO-56 example 8: 20°S on 3 September
+	if got := extraterrestrialRadiation(-20, 246); !approxEqual(got, 32.2, 0.05) {
+		t.Errorf("Ra = %v MJ/m2 per day, want 32.2", got)
+	}
+	// the polar night
+	if got := extraterrestrialRadiation(80, 355); got != 0 {
+		t.Errorf("Ra of the polar night = %v, want 0", got)
+	}
+}
+
+func TestParseET0(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	remote := "192.168.1.5"
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	parser.now = func() time.Time { return now }
+	full := url.Values{"tempf": {"86"}, "humidity": {"40"}, "windspeedmph": {"5"}, "solarradiation": {"800"}}
+	rate := calculateET0PenmanMonteith(86, 40, 5, 800, 101.3)
+
+	parser.Parse(remote, full)
+	if got := gaugeValue(parser.evapotranspiration, remote, ""); got != 0 {
+		t.Errorf("ET0 of the first report = %v, want 0", got)
+	}
+	now = now.Add(10 * time.Minute)
+	parser.Parse(remote, full)
+	if got := gaugeValue(parser.evapotranspiration, remote, ""); !approxEqual(got, rate/6, 1e-9) {
+		t.Errorf("ET0 after 10 minutes = %v, want %v", got, rate/6)
+	}
+	// an offline station only accounts for maxET0Interval
+	now = now.Add(3 * time.Hour)
+	parser.Parse(remote, full)
+	if got := gaugeValue(parser.evapotranspiration, remote, ""); !approxEqual(got, rate/6+rate/2, 1e-9) {
+		t.Errorf("ET0 after 3 hours offline = %v, want %v", got, rate/6+rate/2)
+	}
+	// without solar radiation and latitude there is no method
+	parser.Parse(remote, url.Values{"tempf": {"86"}, "humidity": {"40"}})
+	if hasSeries(parser.evapotranspiration, remote, "") {
+		t.Errorf("ET0 is exported without solar radiation or latitude")
+	}
+	// Hargreaves with the latitude
+	parser.SetLatitude(52)
+	parser.Parse(remote, url.Values{"tempf": {"86"}, "humidity": {"40"}})
+	if !hasSeries(parser.evapotranspiration, remote, "") {
+		t.Errorf("ET0 is missing with the latitude")
+	}
+	// a new day starts from midnight
+	now = time.Date(2024, 6, 2, 0, 10, 0, 0, time.UTC)
+	parser.Parse(remote, full)
+	if got := gaugeValue(parser.evapotranspiration, remote, ""); !approxEqual(got, rate/6, 1e-9) {
+		t.Errorf("ET0 10 minutes after midnight = %v, want %v", got, rate/6)
+	}
+}
@@ -0,0 +1,150 @@
+package weather
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// EventsPath streams every observation as a Server-Sent Event.
+const EventsPath = "/events"
+
+// DefaultMaxSubscribers caps the clients of the events stream.
+const DefaultMaxSubscribers = 16
+
+// eventsKeepAlive is how often an idle stream sends a comment, so proxies don't close it.
+const eventsKeepAlive = 30 * time.Second
+
+// subscriberBuffer is how many observations a slow client may fall behind before the
+// newest ones are dropped for it.
+const subscriberBuffer = 16
+
+var (
+	errTooManySubscribers = errors.New("too many subscribers")
+	errEventsClosed       = errors.New("the events stream is closed")
+)
+
+// observationEvent is the JSON data of an event, a latestObservation with its station.
+type observationEvent struct {
+	RemoteAddress string `json:"remote_address"`
+	latestObservation
+}
+
+// SetMaxSubscribers caps the number of clients of the events stream.
+func (p *Parser) SetMaxSubscribers(max int) {
+	p.subscribersMu.Lock()
+	defer p.subscribersMu.Unlock()
+	p.maxSubscribers = max
+}
+
+// subscribe returns a channel that receives every following observation, and a function
+// that ends the subscription. The channel is closed when the subscription ends or the
+// stream is closed.
+func (p *Parser) subscribe() (<-chan Observation, func(), error) {
+	p.subscribersMu.Lock()
+	defer p.subscribersMu.Unlock()
+	if p.subscribersClosed {
+		return nil, nil, errEventsClosed
+	}
+	if len(p.subscribers) >= p.maxSubscribers {
+		return nil, nil, errTooManySubscribers
+	}
+	ch := make(chan Observation, subscriberBuffer)
+	p.subscribers[ch] = struct{}{}
+	unsubscribe := func() {
+		p.subscribersMu.Lock()
+		defer p.subscribersMu.Unlock()
+		if _, ok := p.subscribers[ch]; ok {
+			delete(p.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, unsubscribe, nil
+}
+
+// hasSubscribers reports whether any client is streaming the events.
+func (p *Parser) hasSubscribers() bool {
+	p.subscribersMu.Lock()
+	defer p.subscribersMu.Unlock()
+	return len(p.subscribers) > 0
+}
+
+// publish sends an observation to every subscriber without waiting for slow ones.
+func (p *Parser) publish(observation Observation) {
+	p.subscribersMu.Lock()
+	defer p.subscribersMu.Unlock()
+	for ch := range p.subscribers {
+		select {
+		case ch <- observation:
+		default:
+		}
+	}
+}
+
+// CloseEvents ends every events stream and refuses new clients, so the server can shut
+// down without waiting for them.
+func (p *Parser) CloseEvents() {
+	p.subscribersMu.Lock()
+	defer p.subscribersMu.Unlock()
+	p.subscribersClosed = true
+	for ch := range p.subscribers {
+		delete(p.subscribers, ch)
+		close(ch)
+	}
+}
+
+// EventsHandler streams every observation as an "observation" event with the JSON of
+// /latest plus the remote_address, e.g.
+// {"remote_address": "192.168.1.5", "name": "roof", "received": "...", "values": {"temperature_outdoor": 71.2}}
+func (p *Parser) EventsHandler() http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			resp.Header().Set("Allow", "GET")
+			http.Error(resp, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		observations, unsubscribe, err := p.subscribe()
+		if err != nil {
+			http.Error(resp, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer unsubscribe()
+		controller := http.NewResponseController(resp)
+		// the stream outlives the server's write timeout
+		controller.SetWriteDeadline(time.Time{})
+		resp.Header().Set("Content-Type", "text/event-stream")
+		resp.Header().Set("Cache-Control", "no-cache")
+		resp.WriteHeader(http.StatusOK)
+		fmt.Fprint(resp, ": connected\n\n")
+		if err := controller.Flush(); err != nil {
+			return
+		}
+		keepAlive := time.NewTicker(eventsKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-req.Context().Done():
+				return
+			case <-keepAlive.C:
+				fmt.Fprint(resp, ": keep-alive\n\n")
+			case observation, ok := <-observations:
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(observationEvent{
+					RemoteAddress:     observation.RemoteAddress,
+					latestObservation: newLatestObservation(observation),
+				})
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(resp, "event: observation\ndata: %s\n\n", data)
+			}
+			if err := controller.Flush(); err != nil {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,103 @@
+package weather
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvent returns the type and data of the next event of an SSE stream, skipping comments.
+func readEvent(t *testing.T, reader *bufio.Reader) (string, string) {
+	t.Helper()
+	var event, data string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && data != "":
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	server := httptest.NewServer(parser.EventsHandler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("got Content-Type %s, want text/event-stream", got)
+	}
+	// the stream is subscribed once the headers are sent
+	parser.Parse("192.168.1.5", url.Values{"tempf": {"71.2"}})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		event, data := readEvent(t, bufio.NewReader(resp.Body))
+		if event != "observation" {
+			t.Errorf("got event %q, want observation", event)
+		}
+		var observation observationEvent
+		if err := json.Unmarshal([]byte(data), &observation); err != nil {
+			t.Error(err)
+			return
+		}
+		if observation.RemoteAddress != "192.168.1.5" || observation.Values["temperature_outdoor"] != 71.2 {
+			t.Errorf("got %s", data)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("no event was received")
+	}
+
+	// the subscription ends with the client
+	resp.Body.Close()
+	waitFor(t, "the unsubscribe", func() bool { return !parser.hasSubscribers() })
+}
+
+func TestEventsMaxSubscribers(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	parser.SetMaxSubscribers(1)
+	server := httptest.NewServer(parser.EventsHandler())
+	defer server.Close()
+
+	first, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Body.Close()
+	second, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.Body.Close()
+	if second.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("got %d for a subscriber over the cap, want 503", second.StatusCode)
+	}
+
+	// closing ends the streams
+	parser.CloseEvents()
+	if _, err := bufio.NewReader(first.Body).ReadString('\x00'); err == nil {
+		t.Error("the stream didn't end")
+	}
+}
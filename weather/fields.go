@@ -0,0 +1,52 @@
+package weather
+
+// fieldGauge describes a report field that is set straight into one series of a gauge.
+type fieldGauge struct {
+	field string
+	gauge *stationGaugeVec
+	// labels follow the remote_address and name labels
+	labels []string
+	// convert converts the value to the configured units, nil keeps it as is
+	convert func(float64, error) (float64, error)
+	// deleteAbsent removes the series when the field is missing from the report,
+	// otherwise the series keeps its last value
+	deleteAbsent bool
+}
+
+// fieldGauges returns the fields that need no calculation, adding a sensor that reports
+// a plain value only takes a new entry here.
+func (p *Parser) fieldGauges() []fieldGauge {
+	return []fieldGauge{
+		{field: "tempinf", gauge: p.temperature, labels: []string{"indoor"}, convert: p.convertTemperature},
+		{field: "humidityin", gauge: p.humidity, labels: []string{"indoor"}},
+		{field: "baromrelin", gauge: p.barometer, labels: []string{"relative"}, convert: p.convertPressure},
+		{field: "baromabsin", gauge: p.barometer, labels: []string{"absolute"}, convert: p.convertPressure},
+		{field: "winddir", gauge: p.windDir, labels: []string{"current"}},
+		{field: "winddir_avg10m", gauge: p.windDir, labels: []string{"avg10m"}},
+		{field: "windgustdir", gauge: p.windDir, labels: []string{"gust"}, deleteAbsent: true},
+		{field: "winddir_avg2m", gauge: p.windDir, labels: []string{"avg2m"}, deleteAbsent: true},
+		{field: "windgustmph", gauge: p.windSpeedMph, labels: []string{"gusts"}, convert: p.convertSpeed},
+		{field: "maxdailygust", gauge: p.windSpeedMph, labels: []string{"daily_max"}, convert: p.convertSpeed, deleteAbsent: true},
+		{field: "windspdmph_avg2m", gauge: p.windSpeedMph, labels: []string{"avg2m"}, convert: p.convertSpeed, deleteAbsent: true},
+		{field: "windspdmph_avg10m", gauge: p.windSpeedMph, labels: []string{"avg10m"}, convert: p.convertSpeed, deleteAbsent: true},
+		{field: "solarradiation", gauge: p.solarRadiation},
+		{field: "hourlyrainin", gauge: p.rainIn, labels: []string{"hourly"}, convert: p.convertRain},
+		{field: "dailyrainin", gauge: p.rainIn, labels: []string{"daily"}, convert: p.convertRain},
+		{field: "weeklyrainin", gauge: p.rainIn, labels: []string{"weekly"}, convert: p.convertRain},
+		{field: "monthlyrainin", gauge: p.rainIn, labels: []string{"monthly"}, convert: p.convertRain},
+		{field: "yearlyrainin", gauge: p.rainIn, labels: []string{"yearly"}, convert: p.convertRain},
+		{field: "totalrainin", gauge: p.rainIn, labels: []string{"total"}, convert: p.convertRain},
+		{field: "eventrainin", gauge: p.rainIn, labels: []string{"event"}, convert: p.convertRain},
+		{field: "lightning_day", gauge: p.lightning_strikes, labels: []string{"day"}},
+		{field: "lightning_distance", gauge: p.lightning_distance},
+		{field: "lightning_time", gauge: p.lightning_last_strike},
+		{field: "pm25", gauge: p.pm25, labels: []string{"outdoor", "current"}, deleteAbsent: true},
+		{field: "pm25_24h", gauge: p.pm25, labels: []string{"outdoor", "avg24h"}, deleteAbsent: true},
+		{field: "pm25_in", gauge: p.pm25, labels: []string{"indoor", "current"}, deleteAbsent: true},
+		{field: "pm25_in_24h", gauge: p.pm25, labels: []string{"indoor", "avg24h"}, deleteAbsent: true},
+		{field: "pm10_aqin", gauge: p.pm10, deleteAbsent: true},
+		{field: "co2", gauge: p.co2, labels: []string{"outdoor", "current"}, deleteAbsent: true},
+		{field: "co2_in", gauge: p.co2, labels: []string{"indoor", "current"}, deleteAbsent: true},
+		{field: "co2_in_24h", gauge: p.co2, labels: []string{"indoor", "avg24h"}, deleteAbsent: true},
+	}
+}
@@ -0,0 +1,35 @@
+package weather
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestParseGolden scrapes the metrics of a full report, changes to the field table must
+// leave them as they are unless the golden file is updated with -update.
+func TestParseGolden(t *testing.T) {
+	parser, registry := newTestParser(t, UnitsImperial, LabelOptions{})
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	parser.now = func() time.Time { return now }
+	parser.Parse("192.168.1.5", readFixture(t, "ambient_report.txt"))
+
+	got := gatherText(t, registry)
+	golden := filepath.Join("testdata", "ambient_report.golden")
+	if *update {
+		if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("metrics differ from %s, rerun with -update if the change is intended:\n%s", golden, got)
+	}
+}
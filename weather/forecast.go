@@ -0,0 +1,178 @@
+package weather
+
+import (
+	"math"
+	"slices"
+	"time"
+)
+
+const (
+	// pressureTrendWindow is how far back the pressure trend looks.
+	pressureTrendWindow = 3 * time.Hour
+	// pressureTrendMinSpan is how much history the trend needs before it is exported.
+	pressureTrendMinSpan = time.Hour
+	// pressureSampleInterval thins the history to at most a sample per minute.
+	pressureSampleInterval = time.Minute
+	// pressureSteadyHPa is the change in hPa per 3 hours within which the pressure
+	// counts as steady, as in the Zambretti forecaster.
+	pressureSteadyHPa = 1.6
+)
+
+// Pressure trends, the 'trend' label of forecast_info.
+const (
+	TrendFalling = "falling"
+	TrendSteady  = "steady"
+	TrendRising  = "rising"
+)
+
+// zambrettiForecasts are the forecasts of the Zambretti forecaster by letter.
+var zambrettiForecasts = map[byte]string{
+	'A': "Settled fine",
+	'B': "Fine weather",
+	'C': "Becoming fine",
+	'D': "Fine, becoming less settled",
+	'E': "Fine, possible showers",
+	'F': "Fairly fine, improving",
+	'G': "Fairly fine, possible showers early",
+	'H': "Fairly fine, showery later",
+	'I': "Showery early, improving",
+	'J': "Changeable, mending",
+	'K': "Fairly fine, showers likely",
+	'L': "Rather unsettled clearing later",
+	'M': "Unsettled, probably improving",
+	'N': "Showery, bright intervals",
+	'O': "Showery, becoming less settled",
+	'P': "Changeable, some rain",
+	'Q': "Unsettled, short fine intervals",
+	'R': "Unsettled, rain later",
+	'S': "Unsettled, some rain",
+	'T': "Mostly very unsettled",
+	'U': "Occasional rain, worsening",
+	'V': "Rain at times, very unsettled",
+	'W': "Rain at frequent intervals",
+	'X': "Rain, very unsettled",
+	'Y': "Stormy, may improve",
+	'Z': "Stormy, much rain",
+}
+
+// zambrettiLetters are the letters of the forecasts for a trend, ordered by the
+// Zambretti number from high to low pressure.
+var zambrettiLetters = map[string]string{
+	TrendFalling: "ABDHORUVX",
+	TrendSteady:  "ABEKNPSWXZ",
+	TrendRising:  "ABCFGIJLMQTYZ",
+}
+
+// pressureSample is a relative pressure reading of a station.
+type pressureSample struct {
+	time     time.Time
+	pressure float64 // inHg
+}
+
+// pressureHistory is the recent relative pressure of a station, oldest first, and the
+// labels of its current forecast_info series.
+type pressureHistory struct {
+	samples  []pressureSample
+	forecast []string
+}
+
+// updatePressureTrend adds a relative pressure reading of a station to its history and
+// sets the pressure_trend_inhg_per_3h and forecast_info gauges once the history spans
+// pressureTrendMinSpan.
+func (p *Parser) updatePressureTrend(remote_address string, name string, received time.Time, pressureInHg float64) {
+	p.pressureMu.Lock()
+	defer p.pressureMu.Unlock()
+	// stations behind the same address are told apart by name
+	history, ok := p.pressure[remote_address][name]
+	if !ok {
+		if p.pressure[remote_address] == nil {
+			p.pressure[remote_address] = map[string]*pressureHistory{}
+		}
+		history = &pressureHistory{}
+		p.pressure[remote_address][name] = history
+	}
+	trend, ok := history.add(received, pressureInHg)
+	if !ok {
+		return
+	}
+	p.pressureTrend.WithLabelValues(remote_address, name).Set(trend)
+	direction := pressureTrendDirection(trend)
+	forecast := []string{remote_address, name, direction, zambrettiForecast(inHgToHPa(pressureInHg), direction)}
+	if history.forecast != nil && !slices.Equal(history.forecast, forecast) {
+		p.forecast.DeleteLabelValues(history.forecast...)
+	}
+	p.forecast.WithLabelValues(forecast...).Set(1)
+	history.forecast = forecast
+}
+
+// add records a reading, dropping the readings that fell out of the window, and returns
+// the trend in inHg per 3 hours, ok is false while the history is too short.
+func (h *pressureHistory) add(received time.Time, pressureInHg float64) (trend float64, ok bool) {
+	if n := len(h.samples); n == 0 || received.Sub(h.samples[n-1].time) >= pressureSampleInterval {
+		h.samples = append(h.samples, pressureSample{time: received, pressure: pressureInHg})
+	}
+	start := 0
+	for start < len(h.samples) && received.Sub(h.samples[start].time) > pressureTrendWindow {
+		start++
+	}
+	h.samples = h.samples[start:]
+	if len(h.samples) < 2 || h.samples[len(h.samples)-1].time.Sub(h.samples[0].time) < pressureTrendMinSpan {
+		return 0, false
+	}
+	return pressureSlope(h.samples) * pressureTrendWindow.Seconds(), true
+}
+
+// pressureSlope returns the least squares slope of the readings in inHg per second, which
+// is less sensitive to the noise of single readings than the difference of two.
+func pressureSlope(samples []pressureSample) float64 {
+	origin := samples[0].time
+	var sumT, sumP float64
+	for _, sample := range samples {
+		sumT += sample.time.Sub(origin).Seconds()
+		sumP += sample.pressure
+	}
+	meanT := sumT / float64(len(samples))
+	meanP := sumP / float64(len(samples))
+	var covariance, variance float64
+	for _, sample := range samples {
+		dt := sample.time.Sub(origin).Seconds() - meanT
+		covariance += dt * (sample.pressure - meanP)
+		variance += dt * dt
+	}
+	if variance == 0 {
+		return 0
+	}
+	return covariance / variance
+}
+
+// pressureTrendDirection classifies a trend in inHg per 3 hours.
+func pressureTrendDirection(trendInHg float64) string {
+	switch trendHPa := inHgToHPa(trendInHg); {
+	case trendHPa <= -pressureSteadyHPa:
+		return TrendFalling
+	case trendHPa >= pressureSteadyHPa:
+		return TrendRising
+	}
+	return TrendSteady
+}
+
+// zambrettiForecast returns the forecast of the Zambretti forecaster for a sea-level
+// pressure in hPa and its trend, with the widely used linear fit of its dial.
+func zambrettiForecast(pressureHPa float64, trend string) string {
+	var z float64
+	switch trend {
+	case TrendFalling:
+		z = 127 - 0.12*pressureHPa
+	case TrendRising:
+		z = 185 - 0.16*pressureHPa
+	default:
+		z = 144 - 0.13*pressureHPa
+	}
+	// the numbers of the forecasts for falling, steady and rising pressure start at
+	// 1, 10 and 20
+	first := map[string]float64{TrendFalling: 1, TrendSteady: 10, TrendRising: 20}[trend]
+	letters := zambrettiLetters[trend]
+	index := int(math.Floor(z - first))
+	index = max(0, min(index, len(letters)-1))
+	return zambrettiForecasts[letters[index]]
+}
@@ -0,0 +1,133 @@
+package weather
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestZambrettiForecast(t *testing.T) {
+	tests := []struct {
+		pressureHPa float64
+		trend       string
+		want        string
+	}{
+		{1013, TrendSteady, "Fine, possible showers"},
+		{1000, TrendFalling, "Occasional rain, worsening"},
+		{1030, TrendRising, "Settled fine"},
+		// beyond the ends of the dial
+		{950, TrendRising, "Stormy, much rain"},
+		{1060, TrendFalling, "Settled fine"},
+	}
+	for _, test := range tests {
+		if got := zambrettiForecast(test.pressureHPa, test.trend); got != test.want {
+			t.Errorf("%v hPa %s: got %q, want %q", test.pressureHPa, test.trend, got, test.want)
+		}
+	}
+}
+
+func TestPressureTrendDirection(t *testing.T) {
+	for _, test := range []struct {
+		trendInHg float64
+		want      string
+	}{
+		{-0.1, TrendFalling},
+		{-0.03, TrendSteady},
+		{0, TrendSteady},
+		{0.03, TrendSteady},
+		{0.1, TrendRising},
+	} {
+		if got := pressureTrendDirection(test.trendInHg); got != test.want {
+			t.Errorf("%v inHg per 3h: got %s, want %s", test.trendInHg, got, test.want)
+		}
+	}
+}
+
+func TestParsePressureTrend(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	parser.now = func() time.Time { return now }
+	const remote = "192.168.1.5"
+	report := func(pressureInHg float64) {
+		parser.Parse(remote, url.Values{"baromrelin": {strconv.FormatFloat(pressureInHg, 'f', -1, 64)}})
+	}
+
+	// the pressure falls 0.03 inHg every 10 minutes, 0.54 inHg per 3 hours
+	pressure := 30.0
+	for i := 0; i < 6; i++ {
+		report(pressure)
+		now = now.Add(10 * time.Minute)
+		pressure -= 0.03
+	}
+	if hasSeries(parser.pressureTrend, remote, "") {
+		t.Fatal("got a trend from less than an hour of history")
+	}
+	for i := 0; i < 7; i++ {
+		report(pressure)
+		now = now.Add(10 * time.Minute)
+		pressure -= 0.03
+	}
+	if got := gaugeValue(parser.pressureTrend, remote, ""); !approxEqual(got, -0.54, 0.001) {
+		t.Errorf("got a trend of %v inHg per 3h, want -0.54", got)
+	}
+	last := pressure + 0.03
+	falling := zambrettiForecast(inHgToHPa(last), TrendFalling)
+	if !hasSeries(parser.forecast, remote, "", TrendFalling, falling) {
+		t.Errorf("missing forecast_info{trend=%q,forecast=%q}", TrendFalling, falling)
+	}
+
+	// after 3 hours of steady pressure the fall is out of the window
+	for i := 0; i < 19; i++ {
+		report(last)
+		now = now.Add(10 * time.Minute)
+	}
+	if got := gaugeValue(parser.pressureTrend, remote, ""); got != 0 {
+		t.Errorf("got a trend of %v inHg per 3h, want 0", got)
+	}
+	// the forecast of the falling pressure is replaced
+	if got := testutil.CollectAndCount(parser.forecast.GaugeVec); got != 1 {
+		t.Errorf("got %d forecast_info series, want 1", got)
+	}
+	steady := zambrettiForecast(inHgToHPa(last), TrendSteady)
+	if !hasSeries(parser.forecast, remote, "", TrendSteady, steady) {
+		t.Errorf("missing forecast_info{trend=%q,forecast=%q}", TrendSteady, steady)
+	}
+}
+
+func TestPressureTrendPerStationName(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	parser.SetStationNames(map[string]string{"AA": "garden", "BB": "roof"})
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	parser.now = func() time.Time { return now }
+	// two stations behind the same address, the pressure of the garden falls and the
+	// pressure of the roof stays the same
+	const remote = "192.168.1.5"
+	pressure := 30.0
+	for i := 0; i < 13; i++ {
+		parser.Parse(remote, url.Values{"PASSKEY": {"AA"}, "baromrelin": {strconv.FormatFloat(pressure, 'f', -1, 64)}})
+		parser.Parse(remote, url.Values{"PASSKEY": {"BB"}, "baromrelin": {"30"}})
+		now = now.Add(10 * time.Minute)
+		pressure -= 0.03
+	}
+	if got := gaugeValue(parser.pressureTrend, remote, "garden"); !approxEqual(got, -0.54, 0.001) {
+		t.Errorf("got a trend of %v inHg per 3h in the garden, want -0.54", got)
+	}
+	if got := gaugeValue(parser.pressureTrend, remote, "roof"); got != 0 {
+		t.Errorf("got a trend of %v inHg per 3h on the roof, want 0", got)
+	}
+}
+
+func TestPressureHistoryThinsSamples(t *testing.T) {
+	var history pressureHistory
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	// a report every 16 seconds, like Ambient Weather stations send them
+	for i := 0; i < 60*60/16; i++ {
+		history.add(start.Add(time.Duration(i)*16*time.Second), 30)
+	}
+	if got := len(history.samples); got > 60 {
+		t.Errorf("got %d samples for an hour, want at most one a minute", got)
+	}
+}
@@ -0,0 +1,445 @@
+package weather
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// forwardTimeout limits how long a forwarded report may take.
+const forwardTimeout = 10 * time.Second
+
+// forwardQueueSize is how many reports per target wait to be forwarded, while a target is
+// retried the reports after it queue up to this many and are dropped when it is full.
+const forwardQueueSize = 100
+
+// Default forward retries: a failed forward is tried again after DefaultForwardRetryDelay,
+// and twice as long after each next failure, until DefaultForwardAttempts attempts failed.
+const (
+	DefaultForwardAttempts   = 3
+	DefaultForwardRetryDelay = 5 * time.Second
+)
+
+// The protocols a report can be forwarded in.
+const (
+	// ForwardAmbient relays the report as the station sent it.
+	ForwardAmbient = "ambient"
+	// ForwardWunderground uploads the report in the Weather Underground protocol.
+	ForwardWunderground = "wunderground"
+	// ForwardEcowitt posts the report in the Ecowitt protocol.
+	ForwardEcowitt = "ecowitt"
+)
+
+// Forwarder relays the reports a station sends to other servers, e.g. AmbientWeather.net,
+// so the station can keep using the cloud while it reports to the exporter.
+type Forwarder struct {
+	targets    []*forwardTarget
+	client     *http.Client
+	attempts   int
+	retryDelay time.Duration
+	bufferDir  string
+	bufferMax  int64
+	start      sync.Once
+	sent       *prometheus.CounterVec
+	failures   *prometheus.CounterVec
+	dropped    *prometheus.CounterVec
+	buffered   *prometheus.GaugeVec
+
+	// stop is closed by Close, the targets then forward their queued reports and return
+	stop      chan struct{}
+	closeOnce sync.Once
+	running   sync.WaitGroup
+	// ctx is cancelled to stop the targets, it interrupts the retries and the requests
+	// in flight
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// forwardTarget is a server reports are forwarded to and the protocol it speaks.
+type forwardTarget struct {
+	protocol string
+	url      *url.URL
+	// name identifies the target in the metrics and logs, see forwardTargetName
+	name   string
+	queue  chan forwardRequest
+	buffer *forwardBuffer
+}
+
+// forwardRequest is a report waiting to be forwarded to a target.
+type forwardRequest struct {
+	Method        string `json:"method"`
+	URL           string `json:"url"`
+	Body          string `json:"body,omitempty"`
+	RemoteAddress string `json:"remote_address"`
+}
+
+// NewForwarder relays reports to every target. A target is a url, optionally preceded by
+// its protocol, e.g. wunderground=https://rtupdate.wunderground.com/weatherstation/updateweatherstation.php?ID=X&PASSWORD=Y.
+// For the default ambient protocol the path and query of each report are appended to
+// the url, so it is usually only the scheme and host. The other protocols send the
+// report to the url as it is, keeping the fields of its query, e.g. the credentials.
+func NewForwarder(targets []string, metric_prefix string, constLabels prometheus.Labels, factory *promauto.Factory) (*Forwarder, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	forwarder := &Forwarder{
+		client:     &http.Client{Timeout: forwardTimeout},
+		stop:       make(chan struct{}),
+		ctx:        ctx,
+		cancel:     cancel,
+		attempts:   DefaultForwardAttempts,
+		retryDelay: DefaultForwardRetryDelay,
+		sent: factory.NewCounterVec(prometheus.CounterOpts{
+			Name:        "forward_success_total",
+			Help:        "number of reports forwarded by target",
+			Namespace:   metric_prefix,
+			ConstLabels: constLabels,
+		}, []string{"target"}),
+		failures: factory.NewCounterVec(prometheus.CounterOpts{
+			Name:        "forward_failures_total",
+			Help:        "number of reports that could not be forwarded by target",
+			Namespace:   metric_prefix,
+			ConstLabels: constLabels,
+		}, []string{"target"}),
+		dropped: factory.NewCounterVec(prometheus.CounterOpts{
+			Name:        "forward_dropped_total",
+			Help:        "number of reports dropped because the forward queue or buffer of the target was full",
+			Namespace:   metric_prefix,
+			ConstLabels: constLabels,
+		}, []string{"target"}),
+		buffered: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "forward_buffered_reports",
+			Help:        "number of reports buffered on disk until the target recovers",
+			Namespace:   metric_prefix,
+			ConstLabels: constLabels,
+		}, []string{"target"}),
+	}
+	for _, target := range targets {
+		protocol := ForwardAmbient
+		if name, rest, ok := strings.Cut(target, "="); ok && !strings.Contains(name, ":") {
+			protocol, target = name, rest
+		}
+		if protocol != ForwardAmbient && protocol != ForwardWunderground && protocol != ForwardEcowitt {
+			return nil, fmt.Errorf("unknown forward protocol %s, must be ambient, wunderground or ecowitt", protocol)
+		}
+		targetURL, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		if targetURL.Scheme != "http" && targetURL.Scheme != "https" {
+			return nil, fmt.Errorf("forward url must be http or https: %s", target)
+		}
+		name := forwardTargetName(protocol, targetURL)
+		if slices.ContainsFunc(forwarder.targets, func(t *forwardTarget) bool { return t.name == name }) {
+			return nil, fmt.Errorf("duplicate forward url: %s", name)
+		}
+		destination := &forwardTarget{
+			protocol: protocol,
+			url:      targetURL,
+			name:     name,
+			queue:    make(chan forwardRequest, forwardQueueSize),
+		}
+		forwarder.targets = append(forwarder.targets, destination)
+		// export the series of every target from the start
+		forwarder.sent.WithLabelValues(name)
+		forwarder.failures.WithLabelValues(name)
+		forwarder.dropped.WithLabelValues(name)
+	}
+	return forwarder, nil
+}
+
+// forwardTargetName identifies a target by the host and path of its url and a short hash of
+// its protocol and whole url, which tells apart targets that only differ in their query, e.g.
+// two Weather Underground stations. The query is left out because it can hold credentials.
+func forwardTargetName(protocol string, targetURL *url.URL) string {
+	sum := sha256.Sum256([]byte(protocol + "=" + targetURL.String()))
+	return targetURL.Host + targetURL.Path + "#" + hex.EncodeToString(sum[:4])
+}
+
+// SetRetry sets how often a forward is attempted and the delay before the first retry,
+// which doubles after every failed retry. It must be called before the first Forward.
+func (f *Forwarder) SetRetry(attempts int, retryDelay time.Duration) {
+	f.attempts = attempts
+	f.retryDelay = retryDelay
+}
+
+// SetBuffer keeps the reports that failed every attempt in dir, up to maxBytes per target,
+// and replays them in order once their target recovers. The oldest reports are dropped
+// when a buffer is full. It must be called before the first Forward.
+func (f *Forwarder) SetBuffer(dir string, maxBytes int64) error {
+	for _, target := range f.targets {
+		buffer, err := openForwardBuffer(dir, target.name, maxBytes)
+		if err != nil {
+			return err
+		}
+		target.buffer = buffer
+		f.buffered.WithLabelValues(target.name).Set(float64(buffer.len()))
+	}
+	return nil
+}
+
+// SetForwarder relays every report through forwarder, whichever protocol it came in.
+func (p *Parser) SetForwarder(forwarder *Forwarder) {
+	p.forwarder = forwarder
+}
+
+// Forward queues req to be relayed to every target in the background, the targets are
+// sent to concurrently. values holds the fields of the report in the Ambient Weather
+// protocol, which are translated for the other protocols. ambient is set when req is an
+// Ambient Weather report, it is then relayed as it is to the ambient targets. Its body
+// must already have been parsed with ParseForm, the form is sent in its place.
+func (f *Forwarder) Forward(req *http.Request, values url.Values, ambient bool) {
+	f.start.Do(func() {
+		for _, target := range f.targets {
+			f.running.Add(1)
+			go func(target *forwardTarget) {
+				defer f.running.Done()
+				f.run(target)
+			}(target)
+		}
+	})
+	select {
+	case <-f.stop:
+		slog.Warn("forwarder is closed, dropping report", "remote_address", req.RemoteAddr)
+		for _, target := range f.targets {
+			f.dropped.WithLabelValues(target.name).Inc()
+		}
+		return
+	default:
+	}
+	for _, target := range f.targets {
+		select {
+		case target.queue <- target.request(req, values, ambient):
+		default:
+			f.dropped.WithLabelValues(target.name).Inc()
+			slog.Warn("forwarding is falling behind, dropping report", "target", target.name, "remote_address", req.RemoteAddr)
+		}
+	}
+}
+
+// run forwards the queued reports of a target one at a time until the forwarder is
+// closed, and then the reports that are still queued.
+func (f *Forwarder) run(target *forwardTarget) {
+	ticker := time.NewTicker(forwardReplayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stop:
+			f.drain(target)
+			return
+		case <-f.ctx.Done():
+			f.drain(target)
+			return
+		case request := <-target.queue:
+			f.forward(target, request)
+		case <-ticker.C:
+			if target.buffer != nil && target.buffer.len() > 0 {
+				f.replay(target)
+			}
+		}
+	}
+}
+
+// forward sends a report to a target, retrying it when it fails. With a buffer, a report
+// that still fails is buffered, and while there are buffered reports new ones are buffered
+// behind them to keep their order.
+func (f *Forwarder) forward(target *forwardTarget, request forwardRequest) {
+	if target.buffer != nil && target.buffer.len() > 0 {
+		f.bufferReport(target, request)
+		f.replay(target)
+		return
+	}
+	attempts, err := retryWithBackoff(f.ctx, f.attempts, f.retryDelay, func() error {
+		return f.send(request)
+	})
+	if err == nil {
+		f.sent.WithLabelValues(target.name).Inc()
+		return
+	}
+	// the url holds the PASSKEY, so only log the host
+	slog.Warn("failed to forward report", "target", target.name, "remote_address", request.RemoteAddress, "attempts", attempts, "error", err)
+	if target.buffer != nil {
+		f.bufferReport(target, request)
+		return
+	}
+	f.failures.WithLabelValues(target.name).Inc()
+}
+
+// drain forwards the reports that are queued for a target. Once the context of the
+// forwarder is cancelled the rest are buffered, or dropped without a buffer.
+func (f *Forwarder) drain(target *forwardTarget) {
+	for {
+		select {
+		case request := <-target.queue:
+			if f.ctx.Err() == nil {
+				f.forward(target, request)
+			} else if target.buffer != nil {
+				f.bufferReport(target, request)
+			} else {
+				f.dropped.WithLabelValues(target.name).Inc()
+				slog.Warn("shutting down, dropping queued report", "target", target.name, "remote_address", request.RemoteAddress)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Close stops forwarding new reports and forwards the queued ones. When ctx is done
+// first, the retries and requests in flight are cancelled, the reports that are left
+// are buffered when there is a buffer, and the error of ctx is returned.
+func (f *Forwarder) Close(ctx context.Context) error {
+	f.closeOnce.Do(func() {
+		close(f.stop)
+	})
+	// without a report there is no target running, and none may start anymore
+	f.start.Do(func() {})
+	done := make(chan struct{})
+	go func() {
+		f.running.Wait()
+		close(done)
+	}()
+	defer f.cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		f.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// bufferReport adds a report to the buffer of a target.
+func (f *Forwarder) bufferReport(target *forwardTarget, request forwardRequest) {
+	evicted, err := target.buffer.push(request)
+	if err != nil {
+		f.failures.WithLabelValues(target.name).Inc()
+		slog.Warn("failed to buffer report", "target", target.name, "error", err)
+	}
+	f.dropped.WithLabelValues(target.name).Add(float64(evicted))
+	f.buffered.WithLabelValues(target.name).Set(float64(target.buffer.len()))
+}
+
+// replay sends the buffered reports of a target oldest first, until one fails.
+func (f *Forwarder) replay(target *forwardTarget) {
+	defer func() {
+		f.buffered.WithLabelValues(target.name).Set(float64(target.buffer.len()))
+	}()
+	for target.buffer.len() > 0 {
+		request, err := target.buffer.oldest()
+		if err != nil {
+			// an unreadable report can never be sent
+			slog.Warn("failed to read buffered report", "target", target.name, "error", err)
+			f.failures.WithLabelValues(target.name).Inc()
+		} else if err := f.send(request); err != nil {
+			slog.Debug("target still unreachable, keeping buffered reports", "target", target.name, "buffered", target.buffer.len(), "error", err)
+			return
+		} else {
+			f.sent.WithLabelValues(target.name).Inc()
+		}
+		if err := target.buffer.pop(); err != nil {
+			slog.Warn("failed to remove buffered report", "target", target.name, "error", err)
+			return
+		}
+	}
+	slog.Info("replayed the buffered reports", "target", target.name)
+}
+
+// retryWithBackoff calls send until it succeeds or failed attempts times, waiting delay
+// after the first failure and twice as long after every next one. It returns the number
+// of attempts and the error of the last one, or the error of ctx when it is done while
+// waiting.
+func retryWithBackoff(ctx context.Context, attempts int, delay time.Duration, send func() error) (int, error) {
+	for attempt := 1; ; attempt++ {
+		err := send()
+		if err == nil || attempt >= attempts {
+			return attempt, err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, ctx.Err()
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// request returns the request that forwards a report to the target.
+func (t *forwardTarget) request(req *http.Request, values url.Values, ambient bool) forwardRequest {
+	forwardURL := *t.url
+	switch t.protocol {
+	case ForwardWunderground:
+		query := reverseFields(values, wundergroundFields)
+		query.Del("PASSKEY")
+		for key, value := range t.url.Query() {
+			query[key] = value
+		}
+		if !query.Has("action") {
+			query.Set("action", "updateraw")
+		}
+		forwardURL.RawQuery = query.Encode()
+		return forwardRequest{Method: http.MethodGet, URL: forwardURL.String(), RemoteAddress: req.RemoteAddr}
+	case ForwardEcowitt:
+		body := reverseFields(values, ecowittFields).Encode()
+		return forwardRequest{Method: http.MethodPost, URL: forwardURL.String(), Body: body, RemoteAddress: req.RemoteAddr}
+	}
+	forwardURL.RawPath = ""
+	if !ambient {
+		// a report of another protocol is sent as the query of an Ambient Weather report
+		query := url.Values{}
+		for key, value := range values {
+			query[key] = value
+		}
+		// the weather underground credentials are for weather underground only
+		for _, key := range wundergroundCredentials {
+			query.Del(key)
+		}
+		forwardURL.Path = strings.TrimSuffix(t.url.Path, "/") + DefaultReportPath
+		forwardURL.RawQuery = query.Encode()
+		return forwardRequest{Method: http.MethodGet, URL: forwardURL.String(), RemoteAddress: req.RemoteAddr}
+	}
+	forwardURL.Path = strings.TrimSuffix(t.url.Path, "/") + req.URL.Path
+	forwardURL.RawQuery = req.URL.RawQuery
+	request := forwardRequest{Method: req.Method, URL: forwardURL.String(), RemoteAddress: req.RemoteAddr}
+	if req.Method == http.MethodPost || req.Method == http.MethodPut {
+		request.Body = req.PostForm.Encode()
+	}
+	return request
+}
+
+func (f *Forwarder) send(request forwardRequest) error {
+	req, err := http.NewRequestWithContext(f.ctx, request.Method, request.URL, strings.NewReader(request.Body))
+	if err != nil {
+		return err
+	}
+	if request.Body != "" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		// the error includes the url with the PASSKEY
+		if urlErr, ok := err.(*url.Error); ok {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("server responded %s", resp.Status)
+	}
+	return nil
+}
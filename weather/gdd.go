@@ -0,0 +1,56 @@
+package weather
+
+import (
+	"math"
+	"time"
+)
+
+// DefaultGDDBase is the base temperature in fahrenheit below which a plant doesn't grow.
+const DefaultGDDBase = 50
+
+// dailyTemperature is the minimum and maximum outdoor temperature of a station since local midnight.
+type dailyTemperature struct {
+	day   string
+	tMinF float64
+	tMaxF float64
+}
+
+// SetGDDBase sets the base temperature in fahrenheit of the growing degree days.
+func (p *Parser) SetGDDBase(baseF float64) {
+	p.gddBase = baseF
+}
+
+// updateGDD records the outdoor temperature of a station and returns the growing degree
+// days of today so far. When the report starts a new day, finished holds the growing
+// degree days of the previous day.
+func (p *Parser) updateGDD(remote_address string, received time.Time, tempF float64) (today float64, finished float64, rolledOver bool) {
+	p.dailyTempsMu.Lock()
+	defer p.dailyTempsMu.Unlock()
+	day := received.In(p.timezone).Format(time.DateOnly)
+	temps, ok := p.dailyTemps[remote_address]
+	if !ok || temps.day != day {
+		if ok {
+			finished = calculateGDD(temps.tMinF, temps.tMaxF, p.gddBase)
+			rolledOver = true
+		}
+		temps = &dailyTemperature{day: day, tMinF: tempF, tMaxF: tempF}
+		p.dailyTemps[remote_address] = temps
+	}
+	temps.tMinF = math.Min(temps.tMinF, tempF)
+	temps.tMaxF = math.Max(temps.tMaxF, tempF)
+	return calculateGDD(temps.tMinF, temps.tMaxF, p.gddBase), finished, rolledOver
+}
+
+// calculateGDD returns the growing degree days in fahrenheit of a day with the given
+// minimum and maximum temperature, following the simple averaging method.
+func calculateGDD(tMinF float64, tMaxF float64, baseF float64) float64 {
+	return math.Max((tMinF+tMaxF)/2-baseF, 0)
+}
+
+// convertDegreeDays converts fahrenheit degree days to the configured units.
+func (p *Parser) convertDegreeDays(degreeDaysF float64) float64 {
+	if p.units == UnitsMetric {
+		return degreeDaysF * 5 / 9
+	}
+	return degreeDaysF
+}
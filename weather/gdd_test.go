@@ -0,0 +1,58 @@
+package weather
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCalculateGDD(t *testing.T) {
+	tests := []struct {
+		tMinF, tMaxF, want float64
+	}{
+		{50, 70, 10},
+		{60, 90, 25},
+		// a day below the base doesn't count negative
+		{30, 50, 0},
+	}
+	for _, test := range tests {
+		if got := calculateGDD(test.tMinF, test.tMaxF, DefaultGDDBase); got != test.want {
+			t.Errorf("calculateGDD(%v, %v) = %v, want %v", test.tMinF, test.tMaxF, got, test.want)
+		}
+	}
+}
+
+func TestParseGDDRollsOverAtLocalMidnight(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	timezone, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no timezone database:", err)
+	}
+	parser.SetTimezone(timezone)
+	remote := "192.168.1.5"
+	report := func(at time.Time, tempF string) {
+		parser.now = func() time.Time { return at }
+		parser.Parse(remote, url.Values{"tempf": {tempF}})
+	}
+
+	// 2024-06-01 in New York, midnight there is 04:00 UTC
+	report(time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC), "60")
+	report(time.Date(2024, 6, 1, 20, 0, 0, 0, time.UTC), "80")
+	report(time.Date(2024, 6, 2, 3, 59, 0, 0, time.UTC), "64")
+	if got := gaugeValue(parser.growingDegreeDays, remote, ""); got != 20 {
+		t.Errorf("growing_degree_days before midnight = %v, want 20", got)
+	}
+	if got := testutil.ToFloat64(parser.growingDegreeDaysSum.WithLabelValues(remote, "")); got != 0 {
+		t.Errorf("growing_degree_days_total before midnight = %v, want 0", got)
+	}
+
+	report(time.Date(2024, 6, 2, 4, 1, 0, 0, time.UTC), "56")
+	if got := gaugeValue(parser.growingDegreeDays, remote, ""); got != 6 {
+		t.Errorf("growing_degree_days after midnight = %v, want 6", got)
+	}
+	if got := testutil.ToFloat64(parser.growingDegreeDaysSum.WithLabelValues(remote, "")); got != 20 {
+		t.Errorf("growing_degree_days_total after midnight = %v, want 20", got)
+	}
+}
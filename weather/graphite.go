@@ -0,0 +1,144 @@
+package weather
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// graphiteTimeout limits how long connecting to and writing to Graphite may take.
+const graphiteTimeout = 10 * time.Second
+
+// GraphiteWriter sends lines in the Graphite plaintext protocol.
+type GraphiteWriter interface {
+	Write(lines string) error
+}
+
+// GraphiteSink sends every value of a report to Graphite as
+// <prefix>.<station>.<metric>[.<label value>...] <value> <timestamp>, where station is the
+// station name or else its address.
+type GraphiteSink struct {
+	writer GraphiteWriter
+	prefix string
+	queue  chan Observation
+}
+
+// graphiteSegmentChars are replaced in a path segment, they separate segments and fields
+var graphiteSegmentChars = strings.NewReplacer(".", "_", " ", "_", "/", "_", "\t", "_", "\n", "_")
+
+// NewGraphiteSink starts sending observations in the background, a batch per report.
+func NewGraphiteSink(writer GraphiteWriter, prefix string) *GraphiteSink {
+	sink := &GraphiteSink{
+		writer: writer,
+		prefix: strings.TrimSuffix(prefix, "."),
+		queue:  make(chan Observation, 16),
+	}
+	go sink.run()
+	return sink
+}
+
+func (g *GraphiteSink) Observe(observation Observation) {
+	select {
+	case g.queue <- observation:
+	default:
+		slog.Warn("Graphite is falling behind, dropping report", "remote_address", observation.RemoteAddress, "name", observation.Name)
+	}
+}
+
+func (g *GraphiteSink) run() {
+	for observation := range g.queue {
+		lines := graphiteLines(observation, g.prefix)
+		if lines == "" {
+			continue
+		}
+		if err := g.writer.Write(lines); err != nil {
+			slog.Warn("failed to write to Graphite", "error", err)
+		}
+	}
+}
+
+// graphiteLines formats every sample of an observation as a line of the plaintext protocol.
+func graphiteLines(observation Observation, prefix string) string {
+	station := observation.Name
+	if station == "" {
+		station = observation.RemoteAddress
+	}
+	timestamp := strconv.FormatInt(observation.Time.Unix(), 10)
+	var lines strings.Builder
+	for _, sample := range observation.Samples {
+		segments := []string{graphiteSegmentChars.Replace(station), sample.Metric}
+		for _, value := range sample.LabelValues() {
+			if value != "" {
+				segments = append(segments, graphiteSegmentChars.Replace(value))
+			}
+		}
+		if prefix != "" {
+			lines.WriteString(prefix)
+			lines.WriteByte('.')
+		}
+		lines.WriteString(strings.Join(segments, "."))
+		lines.WriteByte(' ')
+		lines.WriteString(strconv.FormatFloat(sample.Value, 'f', -1, 64))
+		lines.WriteByte(' ')
+		lines.WriteString(timestamp)
+		lines.WriteByte('\n')
+	}
+	return lines.String()
+}
+
+// tcpGraphiteWriter writes to a Graphite server over a TCP connection that is kept open
+// between reports and reopened when it fails.
+type tcpGraphiteWriter struct {
+	address string
+	conn    net.Conn
+}
+
+// NewGraphiteWriter writes to the plaintext port of the Graphite server at address,
+// e.g. localhost:2003.
+func NewGraphiteWriter(address string) GraphiteWriter {
+	return &tcpGraphiteWriter{address: address}
+}
+
+func (w *tcpGraphiteWriter) Write(lines string) error {
+	if w.conn != nil && w.closedByServer() {
+		w.conn.Close()
+		w.conn = nil
+	}
+	// a broken connection may only be noticed when writing, so retry once on a new one
+	reused := w.conn != nil
+	err := w.write(lines)
+	if err != nil && reused {
+		err = w.write(lines)
+	}
+	return err
+}
+
+// closedByServer reports whether the server closed the connection, e.g. after its idle
+// timeout. Writing to it would seem to succeed while the lines are lost.
+func (w *tcpGraphiteWriter) closedByServer() bool {
+	w.conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	_, err := w.conn.Read(make([]byte, 1))
+	var netErr net.Error
+	return !errors.As(err, &netErr) || !netErr.Timeout()
+}
+
+func (w *tcpGraphiteWriter) write(lines string) error {
+	if w.conn == nil {
+		conn, err := net.DialTimeout("tcp", w.address, graphiteTimeout)
+		if err != nil {
+			return err
+		}
+		w.conn = conn
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(graphiteTimeout))
+	if _, err := w.conn.Write([]byte(lines)); err != nil {
+		w.conn.Close()
+		w.conn = nil
+		return fmt.Errorf("failed to write to %s: %w", w.address, err)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package weather
+
+import (
+	"bufio"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGraphiteWriter records the batches it is asked to write.
+type fakeGraphiteWriter struct {
+	mu      sync.Mutex
+	batches []string
+}
+
+func (w *fakeGraphiteWriter) Write(lines string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.batches = append(w.batches, lines)
+	return nil
+}
+
+func (w *fakeGraphiteWriter) written() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.batches...)
+}
+
+// fakeGraphiteServer accepts plaintext protocol connections and records the lines it
+// receives.
+type fakeGraphiteServer struct {
+	listener net.Listener
+	lines    chan string
+	mu       sync.Mutex
+	conns    []net.Conn
+}
+
+func newFakeGraphiteServer(t *testing.T) *fakeGraphiteServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeGraphiteServer{listener: listener, lines: make(chan string, 100)}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.mu.Lock()
+			server.conns = append(server.conns, conn)
+			server.mu.Unlock()
+			go func() {
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					server.lines <- scanner.Text()
+				}
+			}()
+		}
+	}()
+	return server
+}
+
+// closeConns closes the connections of the server, like an idle timeout does.
+func (s *fakeGraphiteServer) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *fakeGraphiteServer) line(t *testing.T) string {
+	t.Helper()
+	select {
+	case line := <-s.lines:
+		return line
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a line")
+		return ""
+	}
+}
+
+func TestGraphiteLines(t *testing.T) {
+	observation := Observation{
+		RemoteAddress: "192.168.1.5",
+		Name:          "back yard",
+		Time:          time.Unix(1717243200, 0),
+		Samples: []Sample{
+			{Metric: "temperature", Labels: map[string]string{"sensor": "outdoor"}, Value: 71.2},
+			{Metric: "ultraviolet", Value: 4},
+			{Metric: "rain_in", Labels: map[string]string{"period": "1.5h"}, Value: 0.02},
+		},
+	}
+	const want = "weather.back_yard.temperature.outdoor 71.2 1717243200\n" +
+		"weather.back_yard.ultraviolet 4 1717243200\n" +
+		"weather.back_yard.rain_in.1_5h 0.02 1717243200\n"
+	if got := graphiteLines(observation, "weather"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// without a name the station is known by its address
+	observation.Name = ""
+	observation.Samples = observation.Samples[1:2]
+	if got, want := graphiteLines(observation, ""), "192_168_1_5.ultraviolet 4 1717243200\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGraphiteSinkBatchesPerReport(t *testing.T) {
+	writer := &fakeGraphiteWriter{}
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	parser.AddObserver(NewGraphiteSink(writer, "weather."))
+	parser.Parse("192.168.1.5", url.Values{"tempf": {"71.2"}, "humidity": {"40"}})
+	parser.Parse("192.168.1.5", url.Values{"tempf": {"72.5"}})
+	waitFor(t, "two batches", func() bool { return len(writer.written()) == 2 })
+	batch := writer.written()[0]
+	for _, line := range []string{"weather.192_168_1_5.temperature.outdoor 71.2 ", "weather.192_168_1_5.humidity.outdoor 40 "} {
+		if !strings.Contains(batch, line) {
+			t.Errorf("missing %q in the first batch:\n%s", line, batch)
+		}
+	}
+}
+
+func TestGraphiteWriterReconnects(t *testing.T) {
+	server := newFakeGraphiteServer(t)
+	writer := NewGraphiteWriter(server.listener.Addr().String())
+	if err := writer.Write("weather.roof.ultraviolet 4 1717243200\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.line(t); got != "weather.roof.ultraviolet 4 1717243200" {
+		t.Errorf("got %q", got)
+	}
+
+	server.closeConns()
+	if err := writer.Write("weather.roof.ultraviolet 5 1717243260\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.line(t); got != "weather.roof.ultraviolet 5 1717243260" {
+		t.Errorf("got %q after the server closed the connection", got)
+	}
+}
+
+func TestGraphiteWriterUnreachable(t *testing.T) {
+	server := newFakeGraphiteServer(t)
+	address := server.listener.Addr().String()
+	server.listener.Close()
+	if err := NewGraphiteWriter(address).Write("weather.roof.ultraviolet 4 1717243200\n"); err == nil {
+		t.Error("got no error writing to a closed port")
+	}
+}
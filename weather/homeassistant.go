@@ -0,0 +1,183 @@
+package weather
+
+import (
+	"encoding/json"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// homeAssistantPrefix is the topic prefix Home Assistant listens to for MQTT discovery.
+const homeAssistantPrefix = "homeassistant"
+
+// homeAssistantSensor describes how Home Assistant shows the values of a metric.
+type homeAssistantSensor struct {
+	// unit and metricUnit are the unit with imperial and metric units, metricUnit is
+	// left empty when the metric has the same unit in both
+	unit        string
+	metricUnit  string
+	deviceClass string
+}
+
+// homeAssistantSensors holds the metrics that are announced to Home Assistant. Info
+// metrics and timestamps are left out.
+var homeAssistantSensors = map[string]homeAssistantSensor{
+	"temperature":            {"°F", "°C", "temperature"},
+	"temperature_celsius":    {"°C", "", "temperature"},
+	"humidity":               {"%", "", "humidity"},
+	"barometer":              {"inHg", "hPa", "atmospheric_pressure"},
+	"barometer_hpa":          {"hPa", "", "atmospheric_pressure"},
+	"wind_dir":               {"°", "", ""},
+	"wind_speed_mph":         {"mph", "", "wind_speed"},
+	"wind_speed_ms":          {"m/s", "", "wind_speed"},
+	"wind_speed_kmh":         {"km/h", "", "wind_speed"},
+	"rain_in":                {"in", "", "precipitation"},
+	"rain_mm":                {"mm", "", "precipitation"},
+	"rain_rate_in_per_hr":    {"in/h", "", "precipitation_intensity"},
+	"rain_rate_mm_per_hr":    {"mm/h", "", "precipitation_intensity"},
+	"solar_radiation":        {"W/m²", "", "irradiance"},
+	"solar_lux":              {"lx", "", "illuminance"},
+	"ultraviolet":            {"UV index", "", ""},
+	"lightning_strikes":      {"", "", ""},
+	"lightning_distance":     {"km", "", "distance"},
+	"pm25":                   {"µg/m³", "", "pm25"},
+	"pm10":                   {"µg/m³", "", "pm10"},
+	"co2":                    {"ppm", "", "carbon_dioxide"},
+	"air_quality_index":      {"", "", "aqi"},
+	"leaf_wetness":           {"%", "", "moisture"},
+	"battery":                {"", "", ""},
+	"battery_voltage":        {"V", "", "voltage"},
+	"absolute_humidity":      {"g/m³", "", "absolute_humidity"},
+	"vapor_pressure_deficit": {"kPa", "", "pressure"},
+	"cloud_base_feet":        {"ft", "", "distance"},
+	"air_density":            {"kg/m³", "", ""},
+	"evapotranspiration_mm":  {"mm", "", "precipitation"},
+	"growing_degree_days":    {"°F", "°C", ""},
+	"moon_phase":             {"", "", ""},
+	"moon_illumination":      {"%", "", ""},
+}
+
+// unsafeObjectIDChars matches the characters that aren't allowed in a discovery topic id
+var unsafeObjectIDChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// homeAssistantConfig is the discovery config of one sensor.
+type homeAssistantConfig struct {
+	Name              string              `json:"name"`
+	UniqueID          string              `json:"unique_id"`
+	ObjectID          string              `json:"object_id"`
+	StateTopic        string              `json:"state_topic"`
+	UnitOfMeasurement string              `json:"unit_of_measurement,omitempty"`
+	DeviceClass       string              `json:"device_class,omitempty"`
+	StateClass        string              `json:"state_class"`
+	Device            homeAssistantDevice `json:"device"`
+	Origin            homeAssistantOrigin `json:"origin"`
+}
+
+type homeAssistantOrigin struct {
+	Name string `json:"name"`
+}
+
+type homeAssistantDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model,omitempty"`
+}
+
+// SetHomeAssistantDiscovery announces every series to Home Assistant the first time it is
+// published, with a retained config message. units are the units of the observations. It
+// must be called before the first Observe.
+func (m *MQTTPublisher) SetHomeAssistantDiscovery(units string) {
+	m.discoveryUnits = units
+	m.discovered = map[string]bool{}
+}
+
+// discover publishes the discovery config of the samples of an observation that
+// weren't announced yet.
+func (m *MQTTPublisher) discover(observation Observation) {
+	for _, sample := range observation.Samples {
+		topic, config, ok := homeAssistantDiscovery(observation, sample, m.topic(observation, sample), m.discoveryUnits)
+		if !ok || m.discovered[topic] {
+			continue
+		}
+		payload, err := json.Marshal(config)
+		if err != nil {
+			continue
+		}
+		if err := m.client.PublishRetained(topic, string(payload)); err != nil {
+			slog.Warn("failed to publish Home Assistant discovery", "topic", topic, "error", err)
+			continue
+		}
+		m.discovered[topic] = true
+	}
+}
+
+// homeAssistantDiscovery returns the discovery topic and config of a sample that is
+// published on stateTopic, ok is false for samples that aren't announced. The station is
+// identified by its mac when it reports one, otherwise by its stationtype and configured
+// name, which stay the same when its address changes. Only a station with neither is
+// identified by its address.
+func homeAssistantDiscovery(observation Observation, sample Sample, stateTopic string, units string) (topic string, config homeAssistantConfig, ok bool) {
+	sensor, ok := homeAssistantSensors[sample.Metric]
+	if !ok {
+		return "", config, false
+	}
+	unit := sensor.unit
+	if units == UnitsMetric && sensor.metricUnit != "" {
+		unit = sensor.metricUnit
+	}
+	var mac, model string
+	for _, info := range observation.Samples {
+		switch info.Metric {
+		case "station_info":
+			mac = info.Labels["mac"]
+		case "stationtype_info":
+			model = info.Labels["type"]
+		}
+	}
+	deviceID := mac
+	if deviceID == "" {
+		var parts []string
+		for _, part := range []string{model, observation.Name} {
+			if part != "" {
+				parts = append(parts, part)
+			}
+		}
+		deviceID = strings.Join(parts, "_")
+	}
+	if deviceID == "" {
+		deviceID = observation.RemoteAddress
+	}
+	deviceName := observation.Name
+	if deviceName == "" {
+		deviceName = "Weather station " + observation.RemoteAddress
+	}
+	nodeID := objectID(deviceID)
+	sampleID := objectID(sample.Key())
+	name := strings.ReplaceAll(sample.Metric, "_", " ")
+	if labels := sample.LabelValues(); len(labels) > 0 {
+		name += " " + strings.Join(labels, " ")
+	}
+	config = homeAssistantConfig{
+		Name:              name,
+		UniqueID:          nodeID + "_" + sampleID,
+		ObjectID:          nodeID + "_" + sampleID,
+		StateTopic:        stateTopic,
+		UnitOfMeasurement: unit,
+		DeviceClass:       sensor.deviceClass,
+		StateClass:        "measurement",
+		Device: homeAssistantDevice{
+			Identifiers:  []string{deviceID},
+			Name:         deviceName,
+			Manufacturer: "Ambient Weather",
+			Model:        model,
+		},
+		Origin: homeAssistantOrigin{Name: "ambientweatherexporter"},
+	}
+	return homeAssistantPrefix + "/sensor/" + nodeID + "/" + sampleID + "/config", config, true
+}
+
+// objectID turns a value into an id that can be used in a discovery topic.
+func objectID(value string) string {
+	return strings.ToLower(strings.Trim(unsafeObjectIDChars.ReplaceAllString(value, "_"), "_"))
+}
@@ -0,0 +1,115 @@
+package weather
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+)
+
+func TestHomeAssistantDiscovery(t *testing.T) {
+	observation := Observation{
+		RemoteAddress: "192.168.1.5",
+		Name:          "garden",
+		Samples: []Sample{
+			{Metric: "station_info", Labels: map[string]string{"mac": "48:3F:DA:54:2C:6E"}, Value: 1},
+			{Metric: "stationtype_info", Labels: map[string]string{"type": "AMBWeatherV4.2.9"}, Value: 1},
+			{Metric: "temperature", Labels: map[string]string{"sensor": "outdoor"}, Value: 21.8},
+		},
+	}
+	topic, config, ok := homeAssistantDiscovery(observation, observation.Samples[2], "weather/192.168.1.5/temperature/outdoor", UnitsMetric)
+	if !ok {
+		t.Fatal("the temperature wasn't announced")
+	}
+	if want := "homeassistant/sensor/48_3f_da_54_2c_6e/temperature_outdoor/config"; topic != want {
+		t.Errorf("got topic %s, want %s", topic, want)
+	}
+	payload, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `{"name":"temperature outdoor","unique_id":"48_3f_da_54_2c_6e_temperature_outdoor",` +
+		`"object_id":"48_3f_da_54_2c_6e_temperature_outdoor","state_topic":"weather/192.168.1.5/temperature/outdoor",` +
+		`"unit_of_measurement":"°C","device_class":"temperature","state_class":"measurement",` +
+		`"device":{"identifiers":["48:3F:DA:54:2C:6E"],"name":"garden","manufacturer":"Ambient Weather","model":"AMBWeatherV4.2.9"},` +
+		`"origin":{"name":"ambientweatherexporter"}}`
+	if string(payload) != want {
+		t.Errorf("got config\n%s\nwant\n%s", payload, want)
+	}
+
+	// info metrics aren't sensors
+	if _, _, ok := homeAssistantDiscovery(observation, observation.Samples[0], "", UnitsMetric); ok {
+		t.Error("station_info was announced")
+	}
+}
+
+func TestHomeAssistantDiscoveryDeviceWithoutMAC(t *testing.T) {
+	sample := Sample{Metric: "ultraviolet", Value: 4}
+	tests := []struct {
+		observation Observation
+		wantID      string
+		wantName    string
+	}{
+		{Observation{RemoteAddress: "192.168.1.5", Name: "roof", Samples: []Sample{
+			{Metric: "stationtype_info", Labels: map[string]string{"type": "WS2900_V2.01.18"}},
+		}}, "WS2900_V2.01.18_roof", "roof"},
+		{Observation{RemoteAddress: "192.168.1.5"}, "192.168.1.5", "Weather station 192.168.1.5"},
+	}
+	for _, test := range tests {
+		_, config, _ := homeAssistantDiscovery(test.observation, sample, "", UnitsImperial)
+		if len(config.Device.Identifiers) != 1 || config.Device.Identifiers[0] != test.wantID || config.Device.Name != test.wantName {
+			t.Errorf("got device %+v, want id %s and name %s", config.Device, test.wantID, test.wantName)
+		}
+		if config.UnitOfMeasurement != "UV index" {
+			t.Errorf("got unit %q, want UV index", config.UnitOfMeasurement)
+		}
+	}
+}
+
+func TestMQTTPublisherAnnouncesOnce(t *testing.T) {
+	client := newFakeMQTTClient()
+	publisher := NewMQTTPublisher(client, "weather")
+	publisher.SetHomeAssistantDiscovery(UnitsImperial)
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	parser.AddObserver(publisher)
+	report := url.Values{"mac": {"48:3F:DA:54:2C:6E"}, "stationtype": {"AMBWeatherV4.2.9"}, "tempf": {"71.2"}}
+	parser.Parse("192.168.1.5", report)
+
+	const topic = "homeassistant/sensor/48_3f_da_54_2c_6e/temperature_outdoor/config"
+	retained := func() (string, bool) {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		payload, ok := client.retained[topic]
+		return payload, ok
+	}
+	waitFor(t, topic, func() bool {
+		_, ok := retained()
+		return ok
+	})
+	payload, _ := retained()
+	var config homeAssistantConfig
+	if err := json.Unmarshal([]byte(payload), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.StateTopic != "weather/192.168.1.5/temperature/outdoor" || config.UnitOfMeasurement != "°F" {
+		t.Errorf("got config %+v", config)
+	}
+
+	// the next report isn't announced again
+	const stateTopic = "weather/192.168.1.5/temperature/outdoor"
+	waitFor(t, "the first report", func() bool {
+		_, ok := client.message(stateTopic)
+		return ok
+	})
+	client.mu.Lock()
+	delete(client.retained, topic)
+	delete(client.messages, stateTopic)
+	client.mu.Unlock()
+	parser.Parse("192.168.1.5", report)
+	waitFor(t, "the second report", func() bool {
+		_, ok := client.message(stateTopic)
+		return ok
+	})
+	if _, ok := retained(); ok {
+		t.Error("the temperature was announced twice")
+	}
+}
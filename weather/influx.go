@@ -0,0 +1,161 @@
+package weather
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InfluxWriter writes points in the InfluxDB line protocol.
+type InfluxWriter interface {
+	Write(lines []string) error
+}
+
+// maxInfluxPending caps the points kept while InfluxDB is unreachable.
+const maxInfluxPending = 10000
+
+// InfluxSink writes a point per report to InfluxDB, batching the points
+// and flushing them on an interval.
+type InfluxSink struct {
+	writer  InfluxWriter
+	tag     string
+	mu      sync.Mutex
+	pending []string
+}
+
+// NewInfluxSink starts flushing the collected points to writer every flushInterval. The
+// points are tagged with the remote address as remoteAddressTag, see Parser.RemoteAddressLabel,
+// or not at all when remoteAddressTag is empty.
+func NewInfluxSink(writer InfluxWriter, remoteAddressTag string, flushInterval time.Duration) *InfluxSink {
+	sink := &InfluxSink{writer: writer, tag: remoteAddressTag}
+	go func() {
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := sink.Flush(); err != nil {
+				slog.Warn("failed to write to InfluxDB", "error", err)
+			}
+		}
+	}()
+	return sink
+}
+
+func (s *InfluxSink) Observe(observation Observation) {
+	line := influxLine(observation, s.tag)
+	if line == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, line)
+	if len(s.pending) > maxInfluxPending {
+		s.pending = s.pending[len(s.pending)-maxInfluxPending:]
+	}
+}
+
+// Flush writes all collected points. The points are kept for the next flush when
+// the write fails.
+func (s *InfluxSink) Flush() error {
+	s.mu.Lock()
+	lines := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	if len(lines) == 0 {
+		return nil
+	}
+	if err := s.writer.Write(lines); err != nil {
+		s.mu.Lock()
+		s.pending = append(lines, s.pending...)
+		if len(s.pending) > maxInfluxPending {
+			s.pending = s.pending[len(s.pending)-maxInfluxPending:]
+		}
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// influxLine formats an observation as one point of the weather measurement, tagged with
+// the remote address and name, with a field per sample, e.g. temperature_outdoor=71.2.
+func influxLine(observation Observation, remoteAddressTag string) string {
+	if len(observation.Samples) == 0 {
+		return ""
+	}
+	var line strings.Builder
+	line.WriteString("weather")
+	if remoteAddressTag != "" {
+		line.WriteByte(',')
+		line.WriteString(remoteAddressTag)
+		line.WriteByte('=')
+		line.WriteString(influxEscape(observation.RemoteAddress))
+	}
+	if observation.Name != "" {
+		line.WriteString(",name=")
+		line.WriteString(influxEscape(observation.Name))
+	}
+	for i, sample := range observation.Samples {
+		if i == 0 {
+			line.WriteByte(' ')
+		} else {
+			line.WriteByte(',')
+		}
+		line.WriteString(influxEscape(sample.Key()))
+		line.WriteByte('=')
+		line.WriteString(strconv.FormatFloat(sample.Value, 'f', -1, 64))
+	}
+	line.WriteByte(' ')
+	line.WriteString(strconv.FormatInt(observation.Time.UnixNano(), 10))
+	return line.String()
+}
+
+var influxEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+func influxEscape(s string) string {
+	return influxEscaper.Replace(s)
+}
+
+// influxHTTPWriter writes to the InfluxDB v2 http api.
+type influxHTTPWriter struct {
+	writeURL string
+	token    string
+	client   *http.Client
+}
+
+// NewInfluxWriter returns an InfluxWriter for the bucket of org on the InfluxDB v2 at serverURL.
+func NewInfluxWriter(serverURL string, token string, org string, bucket string) InfluxWriter {
+	query := url.Values{}
+	query.Set("org", org)
+	query.Set("bucket", bucket)
+	query.Set("precision", "ns")
+	return &influxHTTPWriter{
+		writeURL: strings.TrimSuffix(serverURL, "/") + "/api/v2/write?" + query.Encode(),
+		token:    token,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (w *influxHTTPWriter) Write(lines []string) error {
+	req, err := http.NewRequest(http.MethodPost, w.writeURL, bytes.NewBufferString(strings.Join(lines, "\n")))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+w.token)
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("InfluxDB responded %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package weather
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeInfluxWriter records the written batches, or fails with err.
+type fakeInfluxWriter struct {
+	batches [][]string
+	err     error
+}
+
+func (w *fakeInfluxWriter) Write(lines []string) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.batches = append(w.batches, lines)
+	return nil
+}
+
+var influxTestObservation = Observation{
+	RemoteAddress: "192.168.1.5",
+	Name:          "back yard",
+	Time:          time.Unix(1717243200, 0),
+	Samples: []Sample{
+		{Metric: "temperature", Labels: map[string]string{"sensor": "outdoor"}, Value: 71.2},
+		{Metric: "humidity", Labels: map[string]string{"sensor": "outdoor"}, Value: 40},
+	},
+}
+
+func TestInfluxSinkBatches(t *testing.T) {
+	writer := &fakeInfluxWriter{}
+	sink := NewInfluxSink(writer, RemoteAddressLabel, time.Hour)
+	sink.Observe(influxTestObservation)
+	sink.Observe(Observation{RemoteAddress: "192.168.1.6"})
+	sink.Observe(influxTestObservation)
+
+	writer.err = errors.New("unreachable")
+	if err := sink.Flush(); err == nil {
+		t.Fatal("the failed write was not reported")
+	}
+	writer.err = nil
+	if err := sink.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	line := `weather,remote_address=192.168.1.5,name=back\ yard temperature_outdoor=71.2,humidity_outdoor=40 1717243200000000000`
+	// the observation without samples has no point, the failed batch is written again
+	if want := [][]string{{line, line}}; !reflect.DeepEqual(writer.batches, want) {
+		t.Errorf("got batches %q, want %q", writer.batches, want)
+	}
+	if err := sink.Flush(); err != nil || len(writer.batches) != 1 {
+		t.Errorf("an empty flush wrote %q, %v", writer.batches, err)
+	}
+}
+
+func TestInfluxLineWithoutRemoteAddress(t *testing.T) {
+	const want = `weather,name=back\ yard temperature_outdoor=71.2,humidity_outdoor=40 1717243200000000000`
+	if got := influxLine(influxTestObservation, ""); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	observation := influxTestObservation
+	observation.Name = ""
+	const wantUntagged = `weather temperature_outdoor=71.2,humidity_outdoor=40 1717243200000000000`
+	if got := influxLine(observation, ""); got != wantUntagged {
+		t.Errorf("got %s, want %s", got, wantUntagged)
+	}
+}
+
+func TestInfluxHTTPWriter(t *testing.T) {
+	var body, auth, query string
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		data, _ := io.ReadAll(req.Body)
+		body, auth, query = string(data), req.Header.Get("Authorization"), req.URL.RawQuery
+		resp.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	writer := NewInfluxWriter(server.URL+"/", "secret", "home", "weather")
+	if err := writer.Write([]string{"weather uv=6 1", "weather uv=7 2"}); err != nil {
+		t.Fatal(err)
+	}
+	if body != "weather uv=6 1\nweather uv=7 2" {
+		t.Errorf("got body %q", body)
+	}
+	if auth != "Token secret" {
+		t.Errorf("got Authorization %q", auth)
+	}
+	if query != "bucket=weather&org=home&precision=ns" {
+		t.Errorf("got query %q", query)
+	}
+}
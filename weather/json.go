@@ -0,0 +1,84 @@
+package weather
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+)
+
+// JSONPath is where reports can be POSTed as a JSON object.
+const JSONPath = "/data/report/json"
+
+// JSONHandler accepts a report as a JSON object using the Ambient Weather field names,
+// e.g. {"tempf": 71.2, "humidity": 40, "stationtype": "AMBWeatherV4.2.9"}.
+type JSONHandler struct {
+	parser *Parser
+}
+
+func NewJSONHandler(parser *Parser) *JSONHandler {
+	return &JSONHandler{parser: parser}
+}
+
+func (h *JSONHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if !h.parser.allowed(resp, req) {
+		return
+	}
+	remote_address := portPattern.ReplaceAllString(req.RemoteAddr, "$1")
+
+	if req.Method != http.MethodPost {
+		resp.Header().Set("Allow", http.MethodPost)
+		http.Error(resp, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		http.Error(resp, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	values, err := decodeJSONReport(http.MaxBytesReader(resp, req.Body, h.parser.maxBodyBytes))
+	if h.parser.rejectTooLarge(resp, remote_address, err) {
+		return
+	}
+	h.parser.countReport(remote_address, values, err)
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.parser.Log("json sample submitted", "remote_address", remote_address)
+	resp.WriteHeader(http.StatusNoContent)
+	h.parser.handleReport(req, remote_address, values, false)
+}
+
+// decodeJSONReport converts a flat JSON object of numbers and strings to the fields of a report.
+func decodeJSONReport(body io.Reader) (url.Values, error) {
+	decoder := json.NewDecoder(body)
+	decoder.UseNumber()
+	var report map[string]any
+	if err := decoder.Decode(&report); err != nil {
+		return nil, fmt.Errorf("report must be a JSON object: %w", err)
+	}
+	if report == nil {
+		return nil, fmt.Errorf("report must be a JSON object")
+	}
+	values := url.Values{}
+	for key, value := range report {
+		switch v := value.(type) {
+		case json.Number:
+			values.Set(key, v.String())
+		case string:
+			values.Set(key, v)
+		case bool:
+			if v {
+				values.Set(key, "1")
+			} else {
+				values.Set(key, "0")
+			}
+		default:
+			return nil, fmt.Errorf("field %s must be a number, string or boolean", key)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,45 @@
+package weather
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestJSONHandler(t *testing.T) {
+	tests := []struct {
+		method      string
+		contentType string
+		body        string
+		want        int
+	}{
+		{http.MethodPost, "application/json", `{"tempf": 71.2, "humidity": "40", "stationtype": "AMBWeatherV4.2.9"}`, http.StatusNoContent},
+		{http.MethodPost, "application/json; charset=utf-8", `{"tempf": 71.2}`, http.StatusNoContent},
+		{http.MethodPost, "application/json", `[{"tempf": 71.2}]`, http.StatusBadRequest},
+		{http.MethodPost, "application/json", `null`, http.StatusBadRequest},
+		{http.MethodPost, "application/json", `{"tempf": {"value": 71.2}}`, http.StatusBadRequest},
+		{http.MethodPost, "application/json", `{"tempf": 71.2`, http.StatusBadRequest},
+		{http.MethodPost, "application/x-www-form-urlencoded", `tempf=71.2`, http.StatusUnsupportedMediaType},
+		{http.MethodGet, "application/json", ``, http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+		req := httptest.NewRequest(test.method, JSONPath, strings.NewReader(test.body))
+		req.Header.Set("Content-Type", test.contentType)
+		req.RemoteAddr = "192.168.1.5:54321"
+		resp := httptest.NewRecorder()
+		NewJSONHandler(parser).ServeHTTP(resp, req)
+		if resp.Code != test.want {
+			t.Errorf("%s %s %s: got %d, want %d", test.method, test.contentType, test.body, resp.Code, test.want)
+		}
+		parsed := hasSeries(parser.temperature, "192.168.1.5", "", "outdoor")
+		if wantParsed := test.want == http.StatusNoContent; parsed != wantParsed {
+			t.Errorf("%s: parsed %v, want %v", test.body, parsed, wantParsed)
+		} else if parsed {
+			if got := gaugeValue(parser.temperature, "192.168.1.5", "", "outdoor"); got != 71.2 {
+				t.Errorf("%s: temperature = %v, want 71.2", test.body, got)
+			}
+		}
+	}
+}
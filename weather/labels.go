@@ -0,0 +1,221 @@
+package weather
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// RemoteAddressLabel is the label holding the address a station reports from.
+	RemoteAddressLabel = "remote_address"
+	// LegacyRemoteAddressLabel is the misspelled name of RemoteAddressLabel in earlier
+	// releases, kept for dashboards that still query it.
+	LegacyRemoteAddressLabel = "remote_adress"
+)
+
+// LabelOptions configures the labels of the metrics of a Parser.
+type LabelOptions struct {
+	// Const labels are added to every metric, e.g. site=roof.
+	Const prometheus.Labels
+	// NoRemoteAddress leaves the remote_address label out of every metric. With a single
+	// station it only adds noise, and creates new series whenever the station's IP changes.
+	NoRemoteAddress bool
+	// LegacyNames names the remote address label LegacyRemoteAddressLabel.
+	LegacyNames bool
+}
+
+// remoteAddressLabel returns the name of the remote address label.
+func (o LabelOptions) remoteAddressLabel() string {
+	if o.LegacyNames {
+		return LegacyRemoteAddressLabel
+	}
+	return RemoteAddressLabel
+}
+
+// stationLabels returns the label names of a metric, leaving out remote_address when it is
+// disabled, and whether it did. Otherwise remote_address is named as configured.
+func (o LabelOptions) stationLabels(labels []string) ([]string, bool) {
+	if len(labels) == 0 || labels[0] != RemoteAddressLabel {
+		return labels, false
+	}
+	if o.NoRemoteAddress {
+		return labels[1:], true
+	}
+	return append([]string{o.remoteAddressLabel()}, labels[1:]...), false
+}
+
+// stationGaugeVec is a GaugeVec that is always called with the remote address as the first
+// label value, which is dropped when the metric has no remote_address label. It keeps the
+// value of every series by station as well, so the series of a station can be listed
+// without collecting the whole vector.
+type stationGaugeVec struct {
+	*prometheus.GaugeVec
+	dropRemote bool
+	// labels are the names of the labels after remote_address and name
+	labels []string
+
+	mu       sync.Mutex
+	stations map[string]map[string]*stationSeries // by remote_address and joined label values
+}
+
+// stationSeries is a series of a stationGaugeVec, labelValues start with the name.
+type stationSeries struct {
+	labelValues []string
+	value       float64
+}
+
+func (g *stationGaugeVec) WithLabelValues(lvs ...string) prometheus.Gauge {
+	remote_address := lvs[0]
+	key := strings.Join(lvs[1:], "\xff")
+	g.mu.Lock()
+	series, ok := g.stations[remote_address][key]
+	if !ok {
+		if g.stations[remote_address] == nil {
+			g.stations[remote_address] = map[string]*stationSeries{}
+		}
+		series = &stationSeries{labelValues: slices.Clone(lvs[1:])}
+		g.stations[remote_address][key] = series
+	}
+	g.mu.Unlock()
+	if g.dropRemote {
+		lvs = lvs[1:]
+	}
+	return &stationGauge{Gauge: g.GaugeVec.WithLabelValues(lvs...), vec: g, series: series}
+}
+
+func (g *stationGaugeVec) DeleteLabelValues(lvs ...string) bool {
+	g.mu.Lock()
+	delete(g.stations[lvs[0]], strings.Join(lvs[1:], "\xff"))
+	g.mu.Unlock()
+	if g.dropRemote {
+		lvs = lvs[1:]
+	}
+	return g.GaugeVec.DeleteLabelValues(lvs...)
+}
+
+// deleteStation removes every series of a station. Without the remote_address label the
+// series are only known by the station name.
+func (g *stationGaugeVec) deleteStation(remote_address string, remoteLabel string, name string) {
+	g.mu.Lock()
+	delete(g.stations, remote_address)
+	g.mu.Unlock()
+	if g.dropRemote {
+		g.GaugeVec.DeletePartialMatch(prometheus.Labels{"name": name})
+		return
+	}
+	g.GaugeVec.DeletePartialMatch(prometheus.Labels{remoteLabel: remote_address})
+}
+
+// samples returns the series of a station with the name as samples of metric.
+func (g *stationGaugeVec) samples(metric string, remote_address string, name string) []Sample {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var samples []Sample
+	for _, series := range g.stations[remote_address] {
+		if series.labelValues[0] != name {
+			continue
+		}
+		labels := make(map[string]string, len(g.labels))
+		for i, label := range g.labels {
+			labels[label] = series.labelValues[i+1]
+		}
+		samples = append(samples, Sample{Metric: metric, Labels: labels, Value: series.value})
+	}
+	return samples
+}
+
+// stationGauge is a series of a stationGaugeVec, it keeps the value of the series in the
+// vector up to date.
+type stationGauge struct {
+	prometheus.Gauge
+	vec    *stationGaugeVec
+	series *stationSeries
+}
+
+func (g *stationGauge) Set(value float64) {
+	g.vec.mu.Lock()
+	defer g.vec.mu.Unlock()
+	g.Gauge.Set(value)
+	g.series.value = value
+}
+
+func (g *stationGauge) Add(value float64) {
+	g.vec.mu.Lock()
+	defer g.vec.mu.Unlock()
+	g.Gauge.Add(value)
+	g.series.value += value
+}
+
+func (g *stationGauge) Sub(value float64) { g.Add(-value) }
+func (g *stationGauge) Inc()              { g.Add(1) }
+func (g *stationGauge) Dec()              { g.Add(-1) }
+
+func (g *stationGauge) SetToCurrentTime() {
+	g.Set(float64(time.Now().UnixNano()) / 1e9)
+}
+
+// stationCounterVec is the CounterVec counterpart of stationGaugeVec.
+type stationCounterVec struct {
+	*prometheus.CounterVec
+	dropRemote bool
+	// station is set when the counter has the remote_address label, and named when it has
+	// the name label, its series then belong to a station
+	station bool
+	named   bool
+}
+
+func (c *stationCounterVec) WithLabelValues(lvs ...string) prometheus.Counter {
+	if c.dropRemote {
+		lvs = lvs[1:]
+	}
+	return c.CounterVec.WithLabelValues(lvs...)
+}
+
+// deleteStation removes every series of a station. Without the remote_address label the
+// series are only known by the station name, a counter without either is shared by all
+// stations and kept.
+func (c *stationCounterVec) deleteStation(remote_address string, remoteLabel string, name string) {
+	switch {
+	case !c.station:
+	case !c.dropRemote:
+		c.CounterVec.DeletePartialMatch(prometheus.Labels{remoteLabel: remote_address})
+	case c.named:
+		c.CounterVec.DeletePartialMatch(prometheus.Labels{"name": name})
+	}
+}
+
+// newGauge returns a gauge whose first two labels are remote_address and name.
+func newGauge(factory *promauto.Factory, metric_prefix string, labelOptions LabelOptions, name string, help string, labels ...string) *stationGaugeVec {
+	extraLabels := labels[2:]
+	labels, dropRemote := labelOptions.stationLabels(labels)
+	opts := prometheus.GaugeOpts{
+		Name:        name,
+		Help:        help,
+		Namespace:   metric_prefix,
+		ConstLabels: labelOptions.Const,
+	}
+	return &stationGaugeVec{
+		GaugeVec:   factory.NewGaugeVec(opts, labels),
+		dropRemote: dropRemote,
+		labels:     labels[len(labels)-len(extraLabels):],
+		stations:   map[string]map[string]*stationSeries{},
+	}
+}
+
+func newCounter(factory *promauto.Factory, metric_prefix string, labelOptions LabelOptions, name string, help string, labels ...string) *stationCounterVec {
+	station := len(labels) > 0 && labels[0] == RemoteAddressLabel
+	named := station && len(labels) > 1 && labels[1] == "name"
+	labels, dropRemote := labelOptions.stationLabels(labels)
+	opts := prometheus.CounterOpts{
+		Name:        name,
+		Help:        help,
+		Namespace:   metric_prefix,
+		ConstLabels: labelOptions.Const,
+	}
+	return &stationCounterVec{CounterVec: factory.NewCounterVec(opts, labels), dropRemote: dropRemote, station: station, named: named}
+}
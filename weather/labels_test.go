@@ -0,0 +1,66 @@
+package weather
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestConstLabels(t *testing.T) {
+	parser, registry := newTestParser(t, UnitsImperial, LabelOptions{
+		Const: prometheus.Labels{"site": "roof"},
+	})
+	parser.Parse("192.168.1.5", url.Values{"tempf": {"71.2"}})
+	metrics := gatherText(t, registry)
+	const want = `temperature{name="",remote_address="192.168.1.5",sensor="outdoor",site="roof"} 71.2`
+	if !strings.Contains(metrics, want) {
+		t.Errorf("missing %s in:\n%s", want, metrics)
+	}
+}
+
+func TestNoRemoteAddressLabel(t *testing.T) {
+	parser, registry := newTestParser(t, UnitsImperial, LabelOptions{NoRemoteAddress: true})
+	parser.Parse("192.168.1.5", url.Values{"tempf": {"71.2"}})
+	metrics := gatherText(t, registry)
+	const want = `temperature{name="",sensor="outdoor"} 71.2`
+	if !strings.Contains(metrics, want) {
+		t.Errorf("missing %s in:\n%s", want, metrics)
+	}
+	if strings.Contains(metrics, "192.168.1.5") {
+		t.Errorf("the remote address is in the metrics:\n%s", metrics)
+	}
+	// a new address of the station updates the same series
+	series := testutil.CollectAndCount(parser.temperature.GaugeVec)
+	parser.Parse("192.168.1.6", url.Values{"tempf": {"72.5"}})
+	if got := testutil.CollectAndCount(parser.temperature.GaugeVec); got != series {
+		t.Errorf("got %d temperature series, want %d", got, series)
+	}
+	if got := gaugeValue(parser.temperature, "192.168.1.6", "", "outdoor"); got != 72.5 {
+		t.Errorf("got %v, want 72.5", got)
+	}
+}
+
+func TestRemoteAddressLabelName(t *testing.T) {
+	tests := []struct {
+		labelOptions LabelOptions
+		want         string
+		wantMissing  string
+	}{
+		{LabelOptions{}, `remote_address="192.168.1.5"`, `remote_adress=`},
+		{LabelOptions{LegacyNames: true}, `remote_adress="192.168.1.5"`, `remote_address=`},
+	}
+	for _, test := range tests {
+		parser, registry := newTestParser(t, UnitsImperial, test.labelOptions)
+		parser.Parse("192.168.1.5", url.Values{"tempf": {"71.2"}})
+		metrics := gatherText(t, registry)
+		if !strings.Contains(metrics, `temperature{name="",`+test.want+`,sensor="outdoor"} 71.2`) {
+			t.Errorf("%+v: missing %s in:\n%s", test.labelOptions, test.want, metrics)
+		}
+		if strings.Contains(metrics, test.wantMissing) {
+			t.Errorf("%+v: got %s in:\n%s", test.labelOptions, test.wantMissing, metrics)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package weather
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// LatestPath serves the most recent observation of every station as JSON.
+const LatestPath = "/latest"
+
+// latestObservation is the JSON form of an Observation.
+type latestObservation struct {
+	Name     string             `json:"name"`
+	Received time.Time          `json:"received"`
+	Values   map[string]float64 `json:"values"`
+}
+
+// newLatestObservation returns the JSON form of an observation.
+func newLatestObservation(observation Observation) latestObservation {
+	values := make(map[string]float64, len(observation.Samples))
+	for _, sample := range observation.Samples {
+		values[sample.Key()] = sample.Value
+	}
+	return latestObservation{
+		Name:     observation.Name,
+		Received: observation.Time,
+		Values:   values,
+	}
+}
+
+// storeLatest records the most recent report of a station, without its samples.
+func (p *Parser) storeLatest(observation Observation) {
+	p.latestMu.Lock()
+	defer p.latestMu.Unlock()
+	p.latest[observation.RemoteAddress] = observation
+}
+
+// LatestHandler serves the most recent observation by remote_address, e.g.
+// {"192.168.1.5": {"name": "roof", "received": "...", "values": {"temperature_outdoor": 71.2}}}
+func (p *Parser) LatestHandler() http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			resp.Header().Set("Allow", "GET, HEAD")
+			http.Error(resp, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		p.latestMu.Lock()
+		observations := make([]Observation, 0, len(p.latest))
+		for _, observation := range p.latest {
+			observations = append(observations, observation)
+		}
+		p.latestMu.Unlock()
+		latest := map[string]latestObservation{}
+		for _, observation := range observations {
+			observation.Samples = p.snapshot(observation.RemoteAddress, observation.Name)
+			latest[observation.RemoteAddress] = newLatestObservation(observation)
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(resp).Encode(latest)
+	})
+}
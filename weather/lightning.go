@@ -0,0 +1,128 @@
+package weather
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// DefaultLightningDistance is the distance in km within which a strike counts as nearby,
+// about the 10 miles that thunder can be heard and lightning can still strike.
+const DefaultLightningDistance = 16
+
+// DefaultLightningDebounce is how long after an alert a station doesn't send another.
+const DefaultLightningDebounce = 15 * time.Minute
+
+// lightningWebhook posts alerts about nearby lightning strikes.
+type lightningWebhook struct {
+	url        string
+	distanceKm float64
+	debounce   time.Duration
+	client     *http.Client
+}
+
+// lightningState is what a station reported about lightning before, to notice changes.
+type lightningState struct {
+	distanceKm  float64
+	hasDistance bool
+	strikes     float64
+	hasStrikes  bool
+	lastFired   time.Time
+}
+
+// LightningAlert is the JSON body posted to the lightning webhook. Reason is "distance"
+// when the distance of the last strike dropped below the threshold, or "strikes" when
+// the number of strikes today went up.
+type LightningAlert struct {
+	RemoteAddress string    `json:"remote_address"`
+	Name          string    `json:"name,omitempty"`
+	Time          time.Time `json:"time"`
+	Reason        string    `json:"reason"`
+	DistanceKm    *float64  `json:"distance_km,omitempty"`
+	StrikesToday  *float64  `json:"strikes_today,omitempty"`
+	ThresholdKm   float64   `json:"threshold_km"`
+}
+
+// SetLightningWebhook posts an alert to url when a strike is reported within distanceKm,
+// or when the number of strikes today goes up, at most once per debounce per station.
+func (p *Parser) SetLightningWebhook(url string, distanceKm float64, debounce time.Duration) {
+	p.lightningWebhook = &lightningWebhook{
+		url:        url,
+		distanceKm: distanceKm,
+		debounce:   debounce,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// checkLightning compares the lightning fields of a report with the previous report of
+// the station and returns the alert to send, ok is false when there is none. The first
+// report of a station only sets the baseline.
+func (p *Parser) checkLightning(remote_address string, name string, received time.Time, distanceKm float64, hasDistance bool, strikes float64, hasStrikes bool) (alert LightningAlert, ok bool) {
+	p.lightningMu.Lock()
+	defer p.lightningMu.Unlock()
+	state, seen := p.lightning[remote_address]
+	if !seen {
+		state = &lightningState{}
+		p.lightning[remote_address] = state
+	}
+	threshold := p.lightningWebhook.distanceKm
+	var reason string
+	switch {
+	case seen && hasDistance && distanceKm < threshold && (!state.hasDistance || state.distanceKm >= threshold):
+		reason = "distance"
+	case seen && hasStrikes && state.hasStrikes && strikes > state.strikes:
+		reason = "strikes"
+	}
+	if hasDistance {
+		state.distanceKm, state.hasDistance = distanceKm, true
+	}
+	if hasStrikes {
+		state.strikes, state.hasStrikes = strikes, true
+	}
+	if reason == "" || received.Sub(state.lastFired) < p.lightningWebhook.debounce {
+		return alert, false
+	}
+	state.lastFired = received
+	alert = LightningAlert{
+		RemoteAddress: remote_address,
+		Name:          name,
+		Time:          received.UTC(),
+		Reason:        reason,
+		ThresholdKm:   threshold,
+	}
+	if hasDistance {
+		alert.DistanceKm = &distanceKm
+	}
+	if hasStrikes {
+		alert.StrikesToday = &strikes
+	}
+	return alert, true
+}
+
+// send posts an alert in the background, retrying a few times when it fails.
+func (w *lightningWebhook) send(alert LightningAlert) {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return
+	}
+	go func() {
+		_, err := retryWithBackoff(context.Background(), 3, time.Second, func() error {
+			resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				return fmt.Errorf("webhook responded %s", resp.Status)
+			}
+			return nil
+		})
+		if err != nil {
+			slog.Warn("failed to send lightning alert", "remote_address", alert.RemoteAddress, "name", alert.Name, "error", err)
+		}
+	}()
+}
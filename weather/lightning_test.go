@@ -0,0 +1,90 @@
+package weather
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCheckLightning(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	parser.SetLightningWebhook("http://example.com/", DefaultLightningDistance, 15*time.Minute)
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	// each report follows the previous one by a minute
+	tests := []struct {
+		name        string
+		distanceKm  float64
+		strikes     float64
+		wantReason  string
+		wantTrigger bool
+	}{
+		{"the first report sets the baseline", 10, 1, "", false},
+		{"a far strike", 30, 1, "", false},
+		{"the strike comes within the threshold", 12, 1, "distance", true},
+		{"more strikes within the debounce", 8, 3, "", false},
+		{"still nearby", 8, 3, "", false},
+	}
+	received := start
+	for _, test := range tests {
+		alert, ok := parser.checkLightning("192.168.1.5", "garden", received, test.distanceKm, true, test.strikes, true)
+		if ok != test.wantTrigger || alert.Reason != test.wantReason {
+			t.Errorf("%s: got %v %q, want %v %q", test.name, ok, alert.Reason, test.wantTrigger, test.wantReason)
+		}
+		if ok && (*alert.DistanceKm != test.distanceKm || alert.ThresholdKm != DefaultLightningDistance) {
+			t.Errorf("%s: got alert %+v", test.name, alert)
+		}
+		received = received.Add(time.Minute)
+	}
+
+	// after the debounce a strike alerts again
+	received = start.Add(20 * time.Minute)
+	if alert, ok := parser.checkLightning("192.168.1.5", "garden", received, 8, true, 4, true); !ok || alert.Reason != "strikes" {
+		t.Errorf("after the debounce: got %v %q, want an alert for strikes", ok, alert.Reason)
+	}
+	// the debounce is per station
+	parser.checkLightning("192.168.1.6", "", received, 30, true, 0, true)
+	if _, ok := parser.checkLightning("192.168.1.6", "", received, 5, true, 0, true); !ok {
+		t.Error("another station was debounced")
+	}
+}
+
+func TestLightningWebhook(t *testing.T) {
+	alerts := make(chan LightningAlert, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		var alert LightningAlert
+		if err := json.NewDecoder(req.Body).Decode(&alert); err != nil {
+			t.Error(err)
+		}
+		alerts <- alert
+	}))
+	defer webhook.Close()
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	parser.SetLightningWebhook(webhook.URL, DefaultLightningDistance, DefaultLightningDebounce)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	parser.now = func() time.Time { return now }
+
+	parser.Parse("192.168.1.5", url.Values{"lightning_day": {"0"}})
+	now = now.Add(time.Minute)
+	parser.Parse("192.168.1.5", url.Values{"lightning_day": {"1"}, "lightning_distance": {"6.2"}})
+	select {
+	case alert := <-alerts:
+		if alert.RemoteAddress != "192.168.1.5" || alert.Reason != "distance" || alert.DistanceKm == nil || *alert.DistanceKm != 6.2 ||
+			alert.StrikesToday == nil || *alert.StrikesToday != 1 || !alert.Time.Equal(now) {
+			t.Errorf("got alert %+v", alert)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no alert was posted")
+	}
+
+	// repeated strikes don't spam
+	now = now.Add(time.Minute)
+	parser.Parse("192.168.1.5", url.Values{"lightning_day": {"5"}, "lightning_distance": {"3.1"}})
+	select {
+	case alert := <-alerts:
+		t.Errorf("got a second alert %+v within the debounce", alert)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
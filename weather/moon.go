@@ -0,0 +1,25 @@
+package weather
+
+import (
+	"math"
+	"time"
+)
+
+// synodicMonth is the mean time in days from one new moon to the next.
+const synodicMonth = 29.530588853
+
+// knownNewMoon is the julian day of the new moon of 2000-01-06 18:14 UTC.
+const knownNewMoon = 2451550.26
+
+// calculateMoonPhase returns the phase of the moon at t as the fraction of the lunar
+// cycle since the last new moon, 0 = new moon; 0.5 = full moon, and the illuminated
+// part of the moon in percent. It uses the mean synodic month, which is off by up to
+// about half a day from the true phase.
+func calculateMoonPhase(t time.Time) (phase float64, illumination float64) {
+	julianDay := float64(t.UnixNano())/86400e9 + julianDayUnixEpoch
+	phase = math.Mod((julianDay-knownNewMoon)/synodicMonth, 1)
+	if phase < 0 {
+		phase++
+	}
+	return phase, (1 - math.Cos(2*math.Pi*phase)) / 2 * 100
+}
@@ -0,0 +1,35 @@
+package weather
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalculateMoonPhase(t *testing.T) {
+	tests := []struct {
+		what             string
+		at               time.Time
+		phase            float64
+		illuminationLow  float64
+		illuminationHigh float64
+	}{
+		{"new moon", time.Date(2024, 1, 11, 11, 57, 0, 0, time.UTC), 0, 0, 1},
+		{"first quarter", time.Date(2024, 1, 18, 3, 53, 0, 0, time.UTC), 0.25, 40, 60},
+		{"full moon", time.Date(2024, 1, 25, 17, 54, 0, 0, time.UTC), 0.5, 99, 100},
+		{"last quarter", time.Date(2024, 2, 2, 23, 18, 0, 0, time.UTC), 0.75, 40, 60},
+	}
+	for _, test := range tests {
+		phase, illumination := calculateMoonPhase(test.at)
+		// the mean synodic month is off by up to about half a day
+		diff := phase - test.phase
+		if diff > 0.5 {
+			diff--
+		}
+		if !approxEqual(diff, 0, 0.02) {
+			t.Errorf("%s: phase %v, want %v", test.what, phase, test.phase)
+		}
+		if illumination < test.illuminationLow || illumination > test.illuminationHigh {
+			t.Errorf("%s: illumination %v%%, want %v-%v%%", test.what, illumination, test.illuminationLow, test.illuminationHigh)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package weather
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// MQTTClient is the part of an MQTT client the MQTTPublisher needs.
+type MQTTClient interface {
+	Publish(topic string, payload string) error
+	// PublishRetained publishes a message the broker keeps for clients that subscribe later.
+	PublishRetained(topic string, payload string) error
+}
+
+// MQTTPublisher publishes every sample of an observation to
+// <prefix>/<remote_address>/<metric>[/<label value>...] with the value as payload.
+type MQTTPublisher struct {
+	client        MQTTClient
+	topicPrefix   string
+	topicTemplate string
+	queue         chan Observation
+	// discovered holds the Home Assistant discovery topics that were published, it is
+	// nil when discovery is disabled
+	discovered     map[string]bool
+	discoveryUnits string
+}
+
+var (
+	// topicPlaceholderPattern matches a placeholder of a topic template, e.g. {sensor}
+	topicPlaceholderPattern = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+	// topicReservedChars are the characters that may not be in an MQTT topic segment
+	topicReservedChars = strings.NewReplacer("/", "_", "+", "_", "#", "_", "\x00", "")
+)
+
+// NewMQTTPublisher starts publishing observations in the background, so a slow
+// broker doesn't hold up report handling.
+func NewMQTTPublisher(client MQTTClient, topicPrefix string) *MQTTPublisher {
+	publisher := &MQTTPublisher{
+		client:      client,
+		topicPrefix: strings.TrimSuffix(topicPrefix, "/"),
+		queue:       make(chan Observation, 16),
+	}
+	go publisher.run()
+	return publisher
+}
+
+func (m *MQTTPublisher) Observe(observation Observation) {
+	select {
+	case m.queue <- observation:
+	default:
+		slog.Warn("MQTT publishing is falling behind, dropping report", "remote_address", observation.RemoteAddress, "name", observation.Name)
+	}
+}
+
+func (m *MQTTPublisher) run() {
+	for observation := range m.queue {
+		if m.discovered != nil {
+			m.discover(observation)
+		}
+		for _, sample := range observation.Samples {
+			topic := m.topic(observation, sample)
+			if err := m.client.Publish(topic, strconv.FormatFloat(sample.Value, 'f', -1, 64)); err != nil {
+				slog.Warn("failed to publish to MQTT", "topic", topic, "error", err)
+			}
+		}
+	}
+}
+
+// SetTopicTemplate publishes to the topic template instead of the default topic, e.g.
+// weather/{name}/{metric}/{sensor}. The placeholders {remote_address}, {name} and {metric}
+// and the sample's labels, e.g. {sensor}, {type} or {period}, are replaced by their value.
+// The values of the labels without a placeholder are appended, so every series keeps its
+// own topic, and empty segments are left out. It must be called before the first Observe.
+func (m *MQTTPublisher) SetTopicTemplate(template string) {
+	m.topicTemplate = template
+}
+
+func (m *MQTTPublisher) topic(observation Observation, sample Sample) string {
+	if m.topicTemplate == "" {
+		segments := []string{m.topicPrefix, topicSegment(observation.RemoteAddress), topicSegment(sample.Metric)}
+		for _, value := range sample.LabelValues() {
+			segments = append(segments, topicSegment(value))
+		}
+		return strings.Join(segments, "/")
+	}
+	return expandTopic(m.topicTemplate, observation, sample)
+}
+
+// expandTopic replaces the placeholders of a topic template, see SetTopicTemplate.
+func expandTopic(template string, observation Observation, sample Sample) string {
+	used := map[string]bool{}
+	topic := topicPlaceholderPattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		used[name] = true
+		switch name {
+		case RemoteAddressLabel:
+			return topicSegment(observation.RemoteAddress)
+		case "name":
+			return topicSegment(observation.Name)
+		case "metric":
+			return topicSegment(sample.Metric)
+		}
+		return topicSegment(sample.Labels[name])
+	})
+	var labels []string
+	for label := range sample.Labels {
+		if !used[label] {
+			labels = append(labels, label)
+		}
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		topic += "/" + topicSegment(sample.Labels[label])
+	}
+	segments := strings.Split(topic, "/")
+	kept := segments[:0]
+	for _, segment := range segments {
+		if segment != "" {
+			kept = append(kept, segment)
+		}
+	}
+	return strings.Join(kept, "/")
+}
+
+// topicSegment makes a value safe to use as a single MQTT topic segment, replacing the
+// separator and the wildcards.
+func topicSegment(value string) string {
+	return topicReservedChars.Replace(value)
+}
+
+// pahoClient is an MQTTClient connected to a real broker.
+type pahoClient struct {
+	client mqtt.Client
+}
+
+// mqttPublishTimeout is how long a publish may wait for the broker.
+const mqttPublishTimeout = 5 * time.Second
+
+// NewMQTTClient connects to broker, e.g. tcp://localhost:1883, in the background and
+// keeps reconnecting when the connection is lost.
+func NewMQTTClient(broker string, clientID string, user string, pass string) MQTTClient {
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetUsername(user).
+		SetPassword(pass).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(10 * time.Second).
+		SetMaxReconnectInterval(time.Minute).
+		SetOnConnectHandler(func(mqtt.Client) {
+			slog.Info("connected to MQTT broker", "broker", broker)
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			slog.Warn("lost connection to MQTT broker, reconnecting", "broker", broker, "error", err)
+		})
+	client := mqtt.NewClient(opts)
+	// with connect retry the token only completes once connected, so don't wait for it
+	client.Connect()
+	return &pahoClient{client: client}
+}
+
+func (c *pahoClient) Publish(topic string, payload string) error {
+	return c.publish(topic, payload, false)
+}
+
+func (c *pahoClient) PublishRetained(topic string, payload string) error {
+	return c.publish(topic, payload, true)
+}
+
+func (c *pahoClient) publish(topic string, payload string, retained bool) error {
+	token := c.client.Publish(topic, 0, retained, payload)
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		return fmt.Errorf("timed out after %s", mqttPublishTimeout)
+	}
+	return token.Error()
+}
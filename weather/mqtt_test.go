@@ -0,0 +1,174 @@
+package weather
+
+import (
+	"errors"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMQTTClient records the published messages by topic.
+type fakeMQTTClient struct {
+	mu       sync.Mutex
+	messages map[string]string
+	retained map[string]string
+	// block holds up every publish until it is closed
+	block chan struct{}
+	err   error
+}
+
+func newFakeMQTTClient() *fakeMQTTClient {
+	return &fakeMQTTClient{messages: map[string]string{}, retained: map[string]string{}}
+}
+
+func (c *fakeMQTTClient) Publish(topic string, payload string) error {
+	if c.block != nil {
+		<-c.block
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages[topic] = payload
+	return c.err
+}
+
+func (c *fakeMQTTClient) PublishRetained(topic string, payload string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.retained[topic] = payload
+	return c.err
+}
+
+// message returns the last payload published to topic.
+func (c *fakeMQTTClient) message(topic string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	payload, ok := c.messages[topic]
+	return payload, ok
+}
+
+func TestMQTTPublisher(t *testing.T) {
+	client := newFakeMQTTClient()
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	parser.AddObserver(NewMQTTPublisher(client, "weather/"))
+	parser.Parse("192.168.1.5", url.Values{"tempf": {"71.2"}, "humidity": {"40"}, "solarradiation": {"612.4"}})
+
+	for topic, want := range map[string]string{
+		"weather/192.168.1.5/temperature/outdoor": "71.2",
+		"weather/192.168.1.5/humidity/outdoor":    "40",
+		"weather/192.168.1.5/solar_radiation":     "612.4",
+	} {
+		waitFor(t, topic, func() bool {
+			_, ok := client.message(topic)
+			return ok
+		})
+		if got, _ := client.message(topic); got != want {
+			t.Errorf("%s = %s, want %s", topic, got, want)
+		}
+	}
+}
+
+func TestMQTTPublisherKeepsGoingAfterAnError(t *testing.T) {
+	client := newFakeMQTTClient()
+	client.err = errors.New("not connected")
+	publisher := NewMQTTPublisher(client, "weather")
+	observation := Observation{RemoteAddress: "192.168.1.5", Samples: []Sample{{Metric: "uv", Value: 6}}}
+	publisher.Observe(observation)
+	waitFor(t, "the failed publish", func() bool {
+		_, ok := client.message("weather/192.168.1.5/uv")
+		return ok
+	})
+	client.mu.Lock()
+	client.err = nil
+	delete(client.messages, "weather/192.168.1.5/uv")
+	client.mu.Unlock()
+	publisher.Observe(observation)
+	waitFor(t, "the publish after the broker is back", func() bool {
+		_, ok := client.message("weather/192.168.1.5/uv")
+		return ok
+	})
+}
+
+func TestMQTTPublisherDoesNotBlock(t *testing.T) {
+	client := newFakeMQTTClient()
+	client.block = make(chan struct{})
+	defer close(client.block)
+	publisher := NewMQTTPublisher(client, "weather")
+	observation := Observation{RemoteAddress: "192.168.1.5", Samples: []Sample{{Metric: "uv", Value: 6}}}
+
+	done := make(chan struct{})
+	go func() {
+		// more than the queue holds while the broker doesn't answer
+		for i := 0; i < 100; i++ {
+			publisher.Observe(observation)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Observe blocked on a slow broker")
+	}
+}
+
+func TestExpandTopic(t *testing.T) {
+	observation := Observation{RemoteAddress: "192.168.1.5", Name: "garden"}
+	tests := []struct {
+		template string
+		name     string
+		sample   Sample
+		want     string
+	}{
+		{"weather/{name}/{metric}/{sensor}", "garden", Sample{Metric: "temperature", Labels: map[string]string{"sensor": "outdoor"}}, "weather/garden/temperature/outdoor"},
+		{"weather/{remote_address}/{metric}", "garden", Sample{Metric: "temperature", Labels: map[string]string{"sensor": "outdoor"}}, "weather/192.168.1.5/temperature/outdoor"},
+		// a sample without the label leaves out its segment
+		{"weather/{name}/{metric}/{sensor}", "garden", Sample{Metric: "uv"}, "weather/garden/uv"},
+		{"weather/{name}/{metric}", "", Sample{Metric: "uv"}, "weather/uv"},
+		// the separator and the wildcards can't end up in a segment
+		{"weather/{name}/{metric}", "roof/#1+", Sample{Metric: "uv"}, "weather/roof__1_/uv"},
+		{"weather/{name}/{metric}/{sensor}", "garden", Sample{Metric: "temperature", Labels: map[string]string{"sensor": "ch/1"}}, "weather/garden/temperature/ch_1"},
+	}
+	for _, test := range tests {
+		observation.Name = test.name
+		if got := expandTopic(test.template, observation, test.sample); got != test.want {
+			t.Errorf("%s with %q and %+v: got %s, want %s", test.template, test.name, test.sample, got, test.want)
+		}
+	}
+}
+
+func TestMQTTPublisherTopic(t *testing.T) {
+	publisher := NewMQTTPublisher(newFakeMQTTClient(), "weather/home")
+	observation := Observation{RemoteAddress: "192.168.1.5"}
+	tests := []struct {
+		sample Sample
+		want   string
+	}{
+		{Sample{Metric: "temperature", Labels: map[string]string{"sensor": "outdoor"}}, "weather/home/192.168.1.5/temperature/outdoor"},
+		// the separator and the wildcards can't end up in a segment
+		{Sample{Metric: "temperature", Labels: map[string]string{"sensor": "ch/1+#"}}, "weather/home/192.168.1.5/temperature/ch_1__"},
+	}
+	for _, test := range tests {
+		if got := publisher.topic(observation, test.sample); got != test.want {
+			t.Errorf("%+v: got %s, want %s", test.sample, got, test.want)
+		}
+	}
+}
+
+func TestMQTTPublisherTopicTemplate(t *testing.T) {
+	client := newFakeMQTTClient()
+	publisher := NewMQTTPublisher(client, "weather")
+	publisher.SetTopicTemplate("weather/{name}/{metric}/{sensor}")
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	parser.SetStationNames(map[string]string{"48:3F:DA:54:2C:6E": "garden"})
+	parser.AddObserver(publisher)
+	parser.Parse("192.168.1.5", url.Values{"PASSKEY": {"48:3F:DA:54:2C:6E"}, "tempf": {"71.2"}})
+
+	const topic = "weather/garden/temperature/outdoor"
+	waitFor(t, topic, func() bool {
+		_, ok := client.message(topic)
+		return ok
+	})
+	if got, _ := client.message(topic); got != "71.2" {
+		t.Errorf("%s = %s, want 71.2", topic, got)
+	}
+}
@@ -0,0 +1,106 @@
+package weather
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Observation holds every value a station has reported, as it is exposed in the gauges.
+type Observation struct {
+	RemoteAddress string
+	Name          string
+	Time          time.Time
+	Samples       []Sample
+}
+
+// Sample is the value of one series of a gauge.
+type Sample struct {
+	// Metric is the gauge name without the metrics prefix, e.g. temperature.
+	Metric string
+	// Labels holds the labels besides remote_address, name and the static labels, e.g. sensor=outdoor.
+	Labels map[string]string
+	Value  float64
+}
+
+// LabelValues returns the values of the sample's labels ordered by label name.
+func (s Sample) LabelValues() []string {
+	names := make([]string, 0, len(s.Labels))
+	for name := range s.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	values := make([]string, len(names))
+	for i, name := range names {
+		values[i] = s.Labels[name]
+	}
+	return values
+}
+
+// Key identifies the sample within an observation, e.g. temperature_outdoor.
+func (s Sample) Key() string {
+	return strings.Join(append([]string{s.Metric}, s.LabelValues()...), "_")
+}
+
+// Observer is told about every report after the gauges have been updated.
+// Observe is called from the request handler so it must not block.
+type Observer interface {
+	Observe(observation Observation)
+}
+
+// StationExpirer is implemented by observers that keep the values of a station, Expire
+// is called when the series of the station expire, see Parser.ExpireStale.
+type StationExpirer interface {
+	Expire(remote_address string)
+}
+
+// AddObserver registers an observer for all following reports.
+func (p *Parser) AddObserver(observer Observer) {
+	p.observers = append(p.observers, observer)
+}
+
+func (p *Parser) notify(remote_address string, name string, received time.Time) {
+	observation := Observation{
+		RemoteAddress: remote_address,
+		Name:          name,
+		Time:          received,
+	}
+	// /latest reads the samples when it is requested
+	p.storeLatest(observation)
+	if len(p.observers) == 0 && !p.hasSubscribers() {
+		return
+	}
+	observation.Samples = p.snapshot(remote_address, name)
+	p.publish(observation)
+	for _, observer := range p.observers {
+		observer.Observe(observation)
+	}
+}
+
+// snapshot returns the current value of every series of a station, sorted by key.
+func (p *Parser) snapshot(remote_address string, name string) []Sample {
+	var samples []Sample
+	for metric, gauge := range p.gauges {
+		samples = append(samples, gauge.samples(metric, remote_address, name)...)
+	}
+	// Key allocates, so compute it once per sample rather than on every comparison
+	keys := make([]string, len(samples))
+	for i, sample := range samples {
+		keys[i] = sample.Key()
+	}
+	sort.Sort(samplesByKey{samples, keys})
+	return samples
+}
+
+// samplesByKey sorts samples by their precomputed keys.
+type samplesByKey struct {
+	samples []Sample
+	keys    []string
+}
+
+func (s samplesByKey) Len() int           { return len(s.samples) }
+func (s samplesByKey) Less(i, j int) bool { return s.keys[i] < s.keys[j] }
+func (s samplesByKey) Swap(i, j int) {
+	s.samples[i], s.samples[j] = s.samples[j], s.samples[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
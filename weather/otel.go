@@ -0,0 +1,156 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// OTelSink mirrors the gauges of every station as OpenTelemetry gauges of a Meter. A
+// gauge is registered the first time a report has a value for it, and reports the most
+// recent value of every station when the meter's reader collects.
+type OTelSink struct {
+	meter      metric.Meter
+	prefix     string
+	attributes []attribute.KeyValue
+	remoteKey  string
+
+	// register serializes registering gauges, it is never held by a callback
+	register sync.Mutex
+	gauges   map[string]metric.Float64ObservableGauge
+
+	mu     sync.Mutex
+	latest map[string]Observation // by remote_address
+}
+
+// NewOTelSink registers the gauges with meter, named like the Prometheus metrics with
+// the metrics prefix. The remote address is the remoteAddressKey attribute, see
+// Parser.RemoteAddressLabel, or left out when remoteAddressKey is empty. constLabels are
+// added to every value.
+func NewOTelSink(meter metric.Meter, prefix string, remoteAddressKey string, constLabels prometheus.Labels) *OTelSink {
+	var attributes []attribute.KeyValue
+	for name, value := range constLabels {
+		attributes = append(attributes, attribute.String(name, value))
+	}
+	return &OTelSink{
+		meter:      meter,
+		prefix:     prefix,
+		attributes: attributes,
+		remoteKey:  remoteAddressKey,
+		gauges:     map[string]metric.Float64ObservableGauge{},
+		latest:     map[string]Observation{},
+	}
+}
+
+func (s *OTelSink) Observe(observation Observation) {
+	s.mu.Lock()
+	s.latest[observation.RemoteAddress] = observation
+	s.mu.Unlock()
+
+	s.register.Lock()
+	defer s.register.Unlock()
+	for _, sample := range observation.Samples {
+		if _, ok := s.gauges[sample.Metric]; ok {
+			continue
+		}
+		if err := s.registerGauge(sample.Metric); err != nil {
+			// mark it anyway so a bad gauge isn't tried for every report
+			s.gauges[sample.Metric] = nil
+			slog.Warn("failed to register OpenTelemetry gauge", "metric", sample.Metric, "error", err)
+		}
+	}
+}
+
+// Expire stops reporting the values of a station.
+func (s *OTelSink) Expire(remote_address string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.latest, remote_address)
+}
+
+// registerGauge registers the gauge of a metric with a callback that observes the latest
+// values of every station.
+func (s *OTelSink) registerGauge(metricName string) error {
+	name := metricName
+	if s.prefix != "" {
+		name = s.prefix + "_" + metricName
+	}
+	gauge, err := s.meter.Float64ObservableGauge(name)
+	if err != nil {
+		return err
+	}
+	_, err = s.meter.RegisterCallback(func(_ context.Context, observer metric.Observer) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, observation := range s.latest {
+			for _, sample := range observation.Samples {
+				if sample.Metric != metricName {
+					continue
+				}
+				observer.ObserveFloat64(gauge, sample.Value, metric.WithAttributes(s.sampleAttributes(observation, sample)...))
+			}
+		}
+		return nil
+	}, gauge)
+	if err != nil {
+		return err
+	}
+	s.gauges[metricName] = gauge
+	return nil
+}
+
+// sampleAttributes returns the attributes of a sample, the same as the labels of its
+// Prometheus series.
+func (s *OTelSink) sampleAttributes(observation Observation, sample Sample) []attribute.KeyValue {
+	attributes := make([]attribute.KeyValue, 0, len(s.attributes)+len(sample.Labels)+2)
+	attributes = append(attributes, s.attributes...)
+	if s.remoteKey != "" {
+		attributes = append(attributes, attribute.String(s.remoteKey, observation.RemoteAddress))
+	}
+	if observation.Name != "" {
+		attributes = append(attributes, attribute.String("name", observation.Name))
+	}
+	for label, value := range sample.Labels {
+		attributes = append(attributes, attribute.String(label, value))
+	}
+	return attributes
+}
+
+// NewOTLPMeterProvider returns a MeterProvider that exports every interval to the OTLP
+// http endpoint, e.g. http://localhost:4318. The endpoint path defaults to /v1/metrics.
+// Shutdown exports the last values.
+func NewOTLPMeterProvider(endpoint string, interval time.Duration) (*sdkmetric.MeterProvider, error) {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if (endpointURL.Scheme != "http" && endpointURL.Scheme != "https") || endpointURL.Host == "" {
+		return nil, fmt.Errorf("%q isn't an http or https url", endpoint)
+	}
+	if endpointURL.Path == "" || endpointURL.Path == "/" {
+		endpointURL.Path = "/v1/metrics"
+	}
+	// the SDK reports failed exports to its global error handler, which logs with the log package
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Warn("failed to export to OpenTelemetry", "error", err)
+	}))
+	exporter, err := otlpmetrichttp.New(context.Background(), otlpmetrichttp.WithEndpointURL(endpointURL.String()))
+	if err != nil {
+		return nil, err
+	}
+	return sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(interval))),
+		sdkmetric.WithResource(resource.NewSchemaless(attribute.String("service.name", "ambientweatherexporter"))),
+	), nil
+}
@@ -0,0 +1,121 @@
+package weather
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// collectGauges returns the data points of every gauge read by reader by metric name.
+func collectGauges(t *testing.T, reader sdkmetric.Reader) map[string][]metricdata.DataPoint[float64] {
+	t.Helper()
+	var data metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &data); err != nil {
+		t.Fatal(err)
+	}
+	gauges := map[string][]metricdata.DataPoint[float64]{}
+	for _, scope := range data.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			gauge, ok := m.Data.(metricdata.Gauge[float64])
+			if !ok {
+				t.Fatalf("%s is a %T, want a gauge", m.Name, m.Data)
+			}
+			gauges[m.Name] = append(gauges[m.Name], gauge.DataPoints...)
+		}
+	}
+	return gauges
+}
+
+func TestOTelSink(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	defer provider.Shutdown(context.Background())
+	sink := NewOTelSink(provider.Meter("test"), "weather", RemoteAddressLabel, prometheus.Labels{"site": "roof"})
+
+	observation := Observation{
+		RemoteAddress: "192.168.1.5",
+		Name:          "garden",
+		Time:          time.Now(),
+		Samples: []Sample{
+			{Metric: "temperature", Labels: map[string]string{"sensor": "outdoor"}, Value: 71.2},
+			{Metric: "temperature", Labels: map[string]string{"sensor": "indoor"}, Value: 68},
+			{Metric: "humidity", Labels: map[string]string{"sensor": "outdoor"}, Value: 40},
+		},
+	}
+	sink.Observe(observation)
+	// a second report doesn't register the gauges again
+	sink.Observe(observation)
+	if len(sink.gauges) != 2 {
+		t.Errorf("registered %d gauges, want 2", len(sink.gauges))
+	}
+
+	gauges := collectGauges(t, reader)
+	temperatures := gauges["weather_temperature"]
+	if len(temperatures) != 2 || len(gauges["weather_humidity"]) != 1 {
+		t.Fatalf("got %v, want 2 temperature and 1 humidity values", gauges)
+	}
+	for _, point := range temperatures {
+		sensor, _ := point.Attributes.Value("sensor")
+		want := map[string]float64{"outdoor": 71.2, "indoor": 68}[sensor.AsString()]
+		if point.Value != want {
+			t.Errorf("got %v for sensor %s, want %v", point.Value, sensor.AsString(), want)
+		}
+		for key, value := range map[attribute.Key]string{RemoteAddressLabel: "192.168.1.5", "name": "garden", "site": "roof"} {
+			if got, _ := point.Attributes.Value(key); got.AsString() != value {
+				t.Errorf("got %s=%q, want %q", key, got.AsString(), value)
+			}
+		}
+	}
+
+	sink.Expire("192.168.1.5")
+	for name, points := range collectGauges(t, reader) {
+		if len(points) > 0 {
+			t.Errorf("%s still has %d values after the station expired", name, len(points))
+		}
+	}
+}
+
+func TestOTelSinkWithoutRemoteAddress(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	defer provider.Shutdown(context.Background())
+	sink := NewOTelSink(provider.Meter("test"), "", "", nil)
+	sink.Observe(Observation{
+		RemoteAddress: "192.168.1.5",
+		Time:          time.Now(),
+		Samples:       []Sample{{Metric: "ultraviolet", Value: 4}},
+	})
+	points := collectGauges(t, reader)["ultraviolet"]
+	if len(points) != 1 {
+		t.Fatalf("got %d ultraviolet values, want 1", len(points))
+	}
+	if points[0].Attributes.Len() != 0 {
+		t.Errorf("got attributes %v, want none", points[0].Attributes.ToSlice())
+	}
+}
+
+func TestOTelSinkExpiresWithParser(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	defer provider.Shutdown(context.Background())
+	sink := NewOTelSink(provider.Meter("test"), "", RemoteAddressLabel, nil)
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	parser.AddObserver(sink)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	parser.now = func() time.Time { return now }
+	parser.Parse("192.168.1.5", url.Values{"tempf": {"71.2"}})
+	if len(collectGauges(t, reader)["temperature"]) == 0 {
+		t.Fatal("the temperature wasn't exported")
+	}
+	now = now.Add(time.Hour)
+	parser.expireStale(15 * time.Minute)
+	if got := collectGauges(t, reader)["temperature"]; len(got) > 0 {
+		t.Errorf("got %v after the station expired, want no values", got)
+	}
+}
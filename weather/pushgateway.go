@@ -0,0 +1,62 @@
+package weather
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// DefaultPushInterval is how often the metrics are pushed to the Pushgateway.
+const DefaultPushInterval = 15 * time.Second
+
+// pushMaxBackoff caps the time between pushes while the Pushgateway keeps failing.
+const pushMaxBackoff = 5 * time.Minute
+
+// Pusher pushes all metrics of a registry to a Pushgateway on an interval, replacing the
+// metrics of its group every time.
+type Pusher struct {
+	pusher   *push.Pusher
+	url      string
+	interval time.Duration
+	failures prometheus.Counter
+}
+
+// NewPusher starts pushing the metrics of gatherer to the Pushgateway at url, grouped by
+// job and instance. A failed push is counted, and the next one waits twice as long as
+// the one before, from interval up to 5 minutes, until a push succeeds.
+func NewPusher(gatherer prometheus.Gatherer, url string, job string, instance string, interval time.Duration, metric_prefix string, constLabels prometheus.Labels, factory *promauto.Factory) *Pusher {
+	p := &Pusher{
+		pusher: push.New(url, job).
+			Gatherer(gatherer).
+			Grouping("instance", instance).
+			Client(&http.Client{Timeout: forwardTimeout}),
+		url:      url,
+		interval: interval,
+		failures: factory.NewCounter(prometheus.CounterOpts{
+			Name:        "pushgateway_failures_total",
+			Help:        "number of failed pushes to the Pushgateway",
+			Namespace:   metric_prefix,
+			ConstLabels: constLabels,
+		}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *Pusher) run() {
+	wait := p.interval
+	for {
+		time.Sleep(wait)
+		if err := p.pusher.Push(); err != nil {
+			p.failures.Inc()
+			wait = min(wait*2, max(pushMaxBackoff, p.interval))
+			slog.Warn("failed to push to the Pushgateway", "url", p.url, "retry_in", wait, "error", err)
+			continue
+		}
+		wait = p.interval
+	}
+}
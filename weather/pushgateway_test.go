@@ -0,0 +1,57 @@
+package weather
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPusher(t *testing.T) {
+	var requests atomic.Int32
+	pushed := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	pushgateway := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		// the first pushes fail
+		if requests.Add(1) <= 2 {
+			resp.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		select {
+		case pushed <- req:
+			bodies <- body
+		default:
+		}
+	}))
+	defer pushgateway.Close()
+
+	parser, registry := newTestParser(t, UnitsImperial, LabelOptions{})
+	parser.Parse("192.168.1.5", url.Values{"tempf": {"71.2"}})
+	pusherRegistry := prometheus.NewRegistry()
+	factory := promauto.With(pusherRegistry)
+	pusher := NewPusher(registry, pushgateway.URL, "weather", "roof", time.Millisecond, "", nil, &factory)
+
+	select {
+	case req := <-pushed:
+		if req.Method != http.MethodPut || req.URL.Path != "/metrics/job/weather/instance/roof" {
+			t.Errorf("got %s %s, want PUT /metrics/job/weather/instance/roof", req.Method, req.URL.Path)
+		}
+		if body := <-bodies; !bytes.Contains(body, []byte("temperature")) || !bytes.Contains(body, []byte("192.168.1.5")) {
+			t.Errorf("the temperature wasn't pushed: %q", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("nothing was pushed")
+	}
+	if got := testutil.ToFloat64(pusher.failures); got != 2 {
+		t.Errorf("got %v failures, want 2", got)
+	}
+}
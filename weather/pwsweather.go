@@ -0,0 +1,160 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// PWSWeatherURL is the ingest endpoint of PWSWeather.
+const PWSWeatherURL = "https://pwsupdate.pwsweather.com/api/v1/submitwx"
+
+// pwsWeatherFields maps the sample keys of an observation to the PWSWeather fields, with
+// the key and the conversion of the metric units value to the imperial units PWSWeather
+// expects when they differ with metric units.
+var pwsWeatherFields = []struct {
+	key       string
+	metricKey string
+	field     string
+	metric    func(float64) float64
+}{
+	{"temperature_outdoor", "", "tempf", celsiusToFahrenheit},
+	{"temperature_dewpoint", "", "dewptf", celsiusToFahrenheit},
+	{"humidity_outdoor", "", "humidity", nil},
+	{"wind_dir_current", "", "winddir", nil},
+	{"wind_speed_mph_sustained", "wind_speed_kmh_sustained", "windspeedmph", kmhToMph},
+	{"wind_speed_mph_gusts", "wind_speed_kmh_gusts", "windgustmph", kmhToMph},
+	{"barometer_relative", "", "baromin", hPaToInHg},
+	{"rain_in_hourly", "rain_mm_hourly", "rainin", mmToInches},
+	{"rain_in_daily", "rain_mm_daily", "dailyrainin", mmToInches},
+	{"rain_in_monthly", "rain_mm_monthly", "monthrainin", mmToInches},
+	{"rain_in_yearly", "rain_mm_yearly", "yearrainin", mmToInches},
+	{"solar_radiation", "", "solarradiation", nil},
+	{"ultraviolet", "", "UV", nil},
+}
+
+// PWSWeatherUploader uploads every observation to a PWSWeather station, so the station
+// contributes to that network while it reports to the exporter.
+type PWSWeatherUploader struct {
+	endpoint  string
+	stationID string
+	password  string
+	units     string
+	client    *http.Client
+	queue     chan Observation
+	failures  prometheus.Counter
+}
+
+// NewPWSWeatherUploader starts uploading observations in the background with the
+// credentials of a PWSWeather station. units are the units of the observations.
+func NewPWSWeatherUploader(stationID string, password string, units string, metric_prefix string, constLabels prometheus.Labels, factory *promauto.Factory) *PWSWeatherUploader {
+	uploader := &PWSWeatherUploader{
+		endpoint:  PWSWeatherURL,
+		stationID: stationID,
+		password:  password,
+		units:     units,
+		client:    &http.Client{Timeout: forwardTimeout},
+		queue:     make(chan Observation, 16),
+		failures: factory.NewCounter(prometheus.CounterOpts{
+			Name:        "pwsweather_failures_total",
+			Help:        "number of observations that could not be uploaded to PWSWeather",
+			Namespace:   metric_prefix,
+			ConstLabels: constLabels,
+		}),
+	}
+	go uploader.run()
+	return uploader
+}
+
+func (u *PWSWeatherUploader) Observe(observation Observation) {
+	select {
+	case u.queue <- observation:
+	default:
+		u.failures.Inc()
+		slog.Warn("PWSWeather upload is falling behind, dropping report", "remote_address", observation.RemoteAddress, "name", observation.Name)
+	}
+}
+
+func (u *PWSWeatherUploader) run() {
+	for observation := range u.queue {
+		query := pwsWeatherQuery(observation, u.units)
+		query.Set("ID", u.stationID)
+		query.Set("PASSWORD", u.password)
+		target := u.endpoint + "?" + query.Encode()
+		attempts, err := retryWithBackoff(context.Background(), DefaultForwardAttempts, DefaultForwardRetryDelay, func() error {
+			return u.send(target)
+		})
+		if err != nil {
+			u.failures.Inc()
+			slog.Warn("failed to upload to PWSWeather", "remote_address", observation.RemoteAddress, "attempts", attempts, "error", err)
+		}
+	}
+}
+
+func (u *PWSWeatherUploader) send(target string) error {
+	resp, err := u.client.Get(target)
+	if err != nil {
+		// the error includes the url with the PASSWORD
+		if urlErr, ok := err.(*url.Error); ok {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("server responded %s", resp.Status)
+	}
+	return nil
+}
+
+// pwsWeatherQuery translates an observation to the fields of the PWSWeather protocol,
+// leaving out the credentials. Values that the station didn't report are left out.
+func pwsWeatherQuery(observation Observation, units string) url.Values {
+	values := make(map[string]float64, len(observation.Samples))
+	for _, sample := range observation.Samples {
+		values[sample.Key()] = sample.Value
+	}
+	query := url.Values{}
+	query.Set("dateutc", observation.Time.UTC().Format("2006-01-02 15:04:05"))
+	for _, field := range pwsWeatherFields {
+		key := field.key
+		if units == UnitsMetric && field.metricKey != "" {
+			key = field.metricKey
+		}
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+		if units == UnitsMetric && field.metric != nil {
+			value = field.metric(value)
+		}
+		query.Set(field.field, strconv.FormatFloat(value, 'f', -1, 64))
+	}
+	query.Set("softwaretype", "ambientweatherexporter")
+	query.Set("action", "updateraw")
+	return query
+}
+
+func celsiusToFahrenheit(tempC float64) float64 {
+	return tempC*9/5 + 32
+}
+
+func kmhToMph(kmh float64) float64 {
+	return kmh / 1.609344
+}
+
+func hPaToInHg(hPa float64) float64 {
+	return hPa / 33.8639
+}
+
+func mmToInches(mm float64) float64 {
+	return mm / 25.4
+}
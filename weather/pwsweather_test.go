@@ -0,0 +1,26 @@
+package weather
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestPWSWeatherQueryConvertsMetricUnits(t *testing.T) {
+	for _, units := range []string{UnitsImperial, UnitsMetric} {
+		parser, _ := newTestParser(t, units, LabelOptions{})
+		var observation Observation
+		parser.AddObserver(observerFunc(func(o Observation) { observation = o }))
+		parser.Parse("192.168.1.5", url.Values{
+			"tempf": {"50"}, "windspeedmph": {"10"}, "windgustmph": {"15"},
+			"baromrelin": {"29.92"}, "dailyrainin": {"0.5"},
+		})
+		query := pwsWeatherQuery(observation, units)
+		for field, want := range map[string]float64{
+			"tempf": 50, "windspeedmph": 10, "windgustmph": 15, "baromin": 29.92, "dailyrainin": 0.5,
+		} {
+			if got := parseFloat(t, query.Get(field)); !approxEqual(got, want, 0.001) {
+				t.Errorf("%s: got %s=%q, want %v", units, field, query.Get(field), want)
+			}
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package weather
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// remoteWriteBatchSize caps the series sent in one request, like the
+	// max_samples_per_send of Prometheus.
+	remoteWriteBatchSize = 500
+	// remoteWriteAttempts is how often a batch is sent before it is dropped, the next
+	// write sends the current values again anyway.
+	remoteWriteAttempts = 3
+	remoteWriteTimeout  = 30 * time.Second
+)
+
+// remoteWriteLabel is a label of a series, remoteWriteSeries is a series with its
+// current value.
+type remoteWriteLabel struct {
+	name, value string
+}
+
+type remoteWriteSeries struct {
+	labels    []remoteWriteLabel
+	value     float64
+	timestamp int64
+}
+
+// RemoteWriteSink sends the current values of all metrics of a registry to a Prometheus
+// remote write endpoint, after every report or on an interval.
+type RemoteWriteSink struct {
+	gatherer prometheus.Gatherer
+	url      string
+	user     string
+	password string
+	interval time.Duration
+	client   *http.Client
+	// write asks the worker to send the current values, it holds at most one request so
+	// reports that arrive while a write is in progress are sent together
+	write chan struct{}
+}
+
+// NewRemoteWriteSink starts sending the metrics of gatherer to the remote write endpoint
+// at url, with basic auth when user is set. With an interval of 0 the metrics are sent
+// after every report, otherwise every interval.
+func NewRemoteWriteSink(gatherer prometheus.Gatherer, url string, user string, password string, interval time.Duration) *RemoteWriteSink {
+	sink := &RemoteWriteSink{
+		gatherer: gatherer,
+		url:      url,
+		user:     user,
+		password: password,
+		interval: interval,
+		client:   &http.Client{Timeout: remoteWriteTimeout},
+		write:    make(chan struct{}, 1),
+	}
+	go sink.run()
+	if interval > 0 {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for range ticker.C {
+				sink.trigger()
+			}
+		}()
+	}
+	return sink
+}
+
+// Observe sends the metrics when they are sent after every report.
+func (s *RemoteWriteSink) Observe(Observation) {
+	if s.interval == 0 {
+		s.trigger()
+	}
+}
+
+// trigger asks the worker to send the current values.
+func (s *RemoteWriteSink) trigger() {
+	select {
+	case s.write <- struct{}{}:
+	default:
+	}
+}
+
+func (s *RemoteWriteSink) run() {
+	for range s.write {
+		if err := s.send(); err != nil {
+			slog.Warn("failed to remote write", "url", s.url, "error", err)
+		}
+	}
+}
+
+// send gathers the metrics and sends them in batches.
+func (s *RemoteWriteSink) send() error {
+	families, err := s.gatherer.Gather()
+	if err != nil && len(families) == 0 {
+		return err
+	}
+	series := remoteWriteSeriesOf(families, time.Now())
+	for start := 0; start < len(series); start += remoteWriteBatchSize {
+		end := min(start+remoteWriteBatchSize, len(series))
+		body := snappy.Encode(nil, marshalWriteRequest(series[start:end]))
+		var permanent error
+		_, err := retryWithBackoff(context.Background(), remoteWriteAttempts, time.Second, func() error {
+			err := s.post(body)
+			var status remoteWriteStatusError
+			if errors.As(err, &status) && !status.recoverable() {
+				// the endpoint rejected the data, sending it again won't help
+				permanent = err
+				return nil
+			}
+			return err
+		})
+		if err == nil {
+			err = permanent
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remoteWriteStatusError is the response of an endpoint that didn't accept a write.
+type remoteWriteStatusError struct {
+	status int
+	body   string
+}
+
+func (e remoteWriteStatusError) Error() string {
+	return fmt.Sprintf("endpoint responded %d %s: %s", e.status, http.StatusText(e.status), e.body)
+}
+
+// recoverable reports whether the write may succeed when it is retried, as for
+// Prometheus only server errors and rate limits are.
+func (e remoteWriteStatusError) recoverable() bool {
+	return e.status/100 == 5 || e.status == http.StatusTooManyRequests
+}
+
+func (s *RemoteWriteSink) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "ambientweatherexporter")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if s.user != "" {
+		req.SetBasicAuth(s.user, s.password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return remoteWriteStatusError{status: resp.StatusCode, body: strings.TrimSpace(string(message))}
+	}
+	return nil
+}
+
+// remoteWriteSeriesOf flattens metric families into series like a scrape would, with
+// histograms and summaries split into their _bucket, _sum and _count series.
+func remoteWriteSeriesOf(families []*dto.MetricFamily, now time.Time) []remoteWriteSeries {
+	timestamp := now.UnixMilli()
+	var series []remoteWriteSeries
+	add := func(name string, labels []*dto.LabelPair, value float64, extra ...remoteWriteLabel) {
+		seriesLabels := make([]remoteWriteLabel, 0, len(labels)+len(extra)+1)
+		seriesLabels = append(seriesLabels, remoteWriteLabel{"__name__", name})
+		for _, label := range labels {
+			// a label with an empty value is the same as no label, and some endpoints reject it
+			if label.GetValue() != "" {
+				seriesLabels = append(seriesLabels, remoteWriteLabel{label.GetName(), label.GetValue()})
+			}
+		}
+		seriesLabels = append(seriesLabels, extra...)
+		// the remote write spec wants the labels sorted by name
+		sort.Slice(seriesLabels, func(i, j int) bool { return seriesLabels[i].name < seriesLabels[j].name })
+		series = append(series, remoteWriteSeries{labels: seriesLabels, value: value, timestamp: timestamp})
+	}
+	for _, family := range families {
+		name := family.GetName()
+		for _, m := range family.GetMetric() {
+			labels := m.GetLabel()
+			switch family.GetType() {
+			case dto.MetricType_GAUGE:
+				add(name, labels, m.GetGauge().GetValue())
+			case dto.MetricType_COUNTER:
+				add(name, labels, m.GetCounter().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, labels, m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				histogram := m.GetHistogram()
+				infinite := false
+				for _, bucket := range histogram.GetBucket() {
+					infinite = math.IsInf(bucket.GetUpperBound(), 1)
+					add(name+"_bucket", labels, float64(bucket.GetCumulativeCount()), remoteWriteLabel{"le", formatBound(bucket.GetUpperBound())})
+				}
+				if !infinite {
+					add(name+"_bucket", labels, float64(histogram.GetSampleCount()), remoteWriteLabel{"le", "+Inf"})
+				}
+				add(name+"_sum", labels, histogram.GetSampleSum())
+				add(name+"_count", labels, float64(histogram.GetSampleCount()))
+			case dto.MetricType_SUMMARY:
+				summary := m.GetSummary()
+				for _, quantile := range summary.GetQuantile() {
+					add(name, labels, quantile.GetValue(), remoteWriteLabel{"quantile", formatBound(quantile.GetQuantile())})
+				}
+				add(name+"_sum", labels, summary.GetSampleSum())
+				add(name+"_count", labels, float64(summary.GetSampleCount()))
+			}
+		}
+	}
+	return series
+}
+
+// formatBound formats a bucket bound or quantile like the exposition format does.
+func formatBound(bound float64) string {
+	if math.IsInf(bound, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(bound, 'g', -1, 64)
+}
+
+// marshalWriteRequest encodes series as a prometheus.WriteRequest protobuf:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func marshalWriteRequest(series []remoteWriteSeries) []byte {
+	var request []byte
+	for _, s := range series {
+		var timeSeries []byte
+		for _, label := range s.labels {
+			var labelBytes []byte
+			labelBytes = protowire.AppendTag(labelBytes, 1, protowire.BytesType)
+			labelBytes = protowire.AppendString(labelBytes, label.name)
+			labelBytes = protowire.AppendTag(labelBytes, 2, protowire.BytesType)
+			labelBytes = protowire.AppendString(labelBytes, label.value)
+			timeSeries = protowire.AppendTag(timeSeries, 1, protowire.BytesType)
+			timeSeries = protowire.AppendBytes(timeSeries, labelBytes)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.timestamp))
+		timeSeries = protowire.AppendTag(timeSeries, 2, protowire.BytesType)
+		timeSeries = protowire.AppendBytes(timeSeries, sample)
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, timeSeries)
+	}
+	return request
+}
@@ -0,0 +1,157 @@
+package weather
+
+import (
+	"encoding/hex"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// capturedWriteRequest is a WriteRequest with the series up{job="x"} 1.5 at 1000ms, as
+// Prometheus sends it.
+const capturedWriteRequest = "0a280a0e0a085f5f6e616d655f5f120275700a080a036a6f62120178120c09000000000000f83f10e807"
+
+func TestMarshalWriteRequest(t *testing.T) {
+	series := []remoteWriteSeries{{
+		labels:    []remoteWriteLabel{{"__name__", "up"}, {"job", "x"}},
+		value:     1.5,
+		timestamp: 1000,
+	}}
+	if got := hex.EncodeToString(marshalWriteRequest(series)); got != capturedWriteRequest {
+		t.Errorf("got %s, want %s", got, capturedWriteRequest)
+	}
+}
+
+// decodeWriteRequest decodes the series of a WriteRequest, see marshalWriteRequest, with
+// the labels joined as name=value pairs.
+func decodeWriteRequest(t *testing.T, data []byte) map[string]float64 {
+	t.Helper()
+	// next returns the bytes of the next field of data, which must be a message or string
+	next := func(data *[]byte) (protowire.Number, []byte) {
+		number, typ, n := protowire.ConsumeTag(*data)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		*data = (*data)[n:]
+		if typ == protowire.Fixed64Type {
+			value, n := protowire.ConsumeFixed64(*data)
+			*data = (*data)[n:]
+			return number, protowire.AppendFixed64(nil, value)
+		}
+		if typ == protowire.VarintType {
+			_, n := protowire.ConsumeVarint(*data)
+			*data = (*data)[n:]
+			return number, nil
+		}
+		value, n := protowire.ConsumeBytes(*data)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		*data = (*data)[n:]
+		return number, value
+	}
+	series := map[string]float64{}
+	for len(data) > 0 {
+		_, timeSeries := next(&data)
+		var labels []string
+		var value float64
+		for len(timeSeries) > 0 {
+			number, field := next(&timeSeries)
+			switch number {
+			case 1:
+				_, name := next(&field)
+				_, labelValue := next(&field)
+				labels = append(labels, string(name)+"="+string(labelValue))
+			case 2:
+				_, bits := next(&field)
+				v, _ := protowire.ConsumeFixed64(bits)
+				value = math.Float64frombits(v)
+			}
+		}
+		series[strings.Join(labels, ",")] = value
+	}
+	return series
+}
+
+func TestRemoteWriteSink(t *testing.T) {
+	requests := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	endpoint := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		requests <- req
+		bodies <- body
+		resp.WriteHeader(http.StatusNoContent)
+	}))
+	defer endpoint.Close()
+	parser, registry := newTestParser(t, UnitsImperial, LabelOptions{})
+	parser.AddObserver(NewRemoteWriteSink(registry, endpoint.URL, "user", "secret", 0))
+	parser.Parse("192.168.1.5", url.Values{"tempf": {"71.2"}})
+
+	var req *http.Request
+	var body []byte
+	select {
+	case req = <-requests:
+		body = <-bodies
+	case <-time.After(time.Second):
+		t.Fatal("nothing was written")
+	}
+	if user, password, _ := req.BasicAuth(); user != "user" || password != "secret" {
+		t.Errorf("got basic auth %s:%s", user, password)
+	}
+	if req.Header.Get("Content-Encoding") != "snappy" || req.Header.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" {
+		t.Errorf("got headers %v", req.Header)
+	}
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	series := decodeWriteRequest(t, data)
+	// labels with an empty value, like name, are left out
+	const key = "__name__=temperature,remote_address=192.168.1.5,sensor=outdoor"
+	if got, ok := series[key]; !ok || got != 71.2 {
+		t.Errorf("got %s = %v (%v), want 71.2 in %d series", key, got, ok, len(series))
+	}
+}
+
+func TestRemoteWriteSeriesOfHistogram(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "duration", Buckets: []float64{0.5, 1}})
+	registry.MustRegister(histogram)
+	histogram.Observe(0.7)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]float64{}
+	for _, s := range remoteWriteSeriesOf(families, time.Unix(1, 0)) {
+		var labels []string
+		for _, label := range s.labels {
+			labels = append(labels, label.name+"="+label.value)
+		}
+		got[strings.Join(labels, ",")] = s.value
+	}
+	want := map[string]float64{
+		"__name__=duration_bucket,le=0.5":  0,
+		"__name__=duration_bucket,le=1":    1,
+		"__name__=duration_bucket,le=+Inf": 1,
+		"__name__=duration_sum":            0.7,
+		"__name__=duration_count":          1,
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %v, want %v", key, got[key], value)
+		}
+	}
+}
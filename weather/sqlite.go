@@ -0,0 +1,161 @@
+package weather
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// sqliteMigrations are the schema changes in order, the schema version of a database is
+// the number of migrations applied to it. Only ever add migrations at the end.
+var sqliteMigrations = []string{
+	`CREATE TABLE reports (
+		id INTEGER PRIMARY KEY,
+		time INTEGER NOT NULL,
+		remote_address TEXT NOT NULL,
+		name TEXT NOT NULL,
+		UNIQUE (time, remote_address)
+	);
+	CREATE TABLE observations (
+		report_id INTEGER NOT NULL REFERENCES reports (id),
+		metric TEXT NOT NULL,
+		labels TEXT NOT NULL,
+		value REAL NOT NULL,
+		PRIMARY KEY (report_id, metric, labels)
+	);
+	CREATE INDEX observations_metric ON observations (metric, report_id);`,
+	// stations behind the same address are told apart by name, SQLite can't change the
+	// constraint of a table so the table is copied
+	`CREATE TABLE reports_new (
+		id INTEGER PRIMARY KEY,
+		time INTEGER NOT NULL,
+		remote_address TEXT NOT NULL,
+		name TEXT NOT NULL,
+		UNIQUE (time, remote_address, name)
+	);
+	INSERT INTO reports_new (id, time, remote_address, name) SELECT id, time, remote_address, name FROM reports;
+	DROP TABLE reports;
+	ALTER TABLE reports_new RENAME TO reports;`,
+}
+
+// SQLiteSink stores the values of every report in a SQLite database. A report is a row of
+// the reports table, keyed by its time in unix seconds, the station address and name, and
+// each of its values is a row of the observations table, e.g.
+//
+//	SELECT datetime(time, 'unixepoch'), value FROM reports JOIN observations ON report_id = id
+//	WHERE metric = 'temperature' AND labels = 'sensor=outdoor'
+type SQLiteSink struct {
+	db    *sql.DB
+	queue chan Observation
+}
+
+// NewSQLiteSink brings the schema of db up to date and starts storing observations in
+// the background. db may use any SQLite driver.
+func NewSQLiteSink(db *sql.DB) (*SQLiteSink, error) {
+	if err := migrateSQLite(db); err != nil {
+		return nil, err
+	}
+	sink := &SQLiteSink{
+		db:    db,
+		queue: make(chan Observation, 16),
+	}
+	go sink.run()
+	return sink, nil
+}
+
+// migrateSQLite applies the migrations the database is missing, tracking the schema
+// version in its user_version.
+func migrateSQLite(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read the schema version: %w", err)
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("the database has schema version %d, newer than this release knows (%d)", version, len(sqliteMigrations))
+	}
+	for ; version < len(sqliteMigrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqliteMigrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to migrate to schema version %d: %w", version+1, err)
+		}
+		// PRAGMA doesn't take parameters
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteSink) Observe(observation Observation) {
+	select {
+	case s.queue <- observation:
+	default:
+		slog.Warn("SQLite is falling behind, dropping report", "remote_address", observation.RemoteAddress, "name", observation.Name)
+	}
+}
+
+func (s *SQLiteSink) run() {
+	for observation := range s.queue {
+		if err := s.store(observation); err != nil {
+			slog.Warn("failed to write to SQLite", "error", err)
+		}
+	}
+}
+
+// store writes an observation in a single transaction. A second report of a station within
+// the same second replaces the values of the first.
+func (s *SQLiteSink) store(observation Observation) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	timestamp := observation.Time.Unix()
+	_, err = tx.Exec(`INSERT INTO reports (time, remote_address, name) VALUES (?, ?, ?)
+		ON CONFLICT (time, remote_address, name) DO NOTHING`,
+		timestamp, observation.RemoteAddress, observation.Name)
+	if err != nil {
+		return err
+	}
+	var id int64
+	err = tx.QueryRow("SELECT id FROM reports WHERE time = ? AND remote_address = ? AND name = ?",
+		timestamp, observation.RemoteAddress, observation.Name).Scan(&id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM observations WHERE report_id = ?", id); err != nil {
+		return err
+	}
+	insert, err := tx.Prepare("INSERT INTO observations (report_id, metric, labels, value) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	for _, sample := range observation.Samples {
+		if _, err := insert.Exec(id, sample.Metric, sqliteLabels(sample), sample.Value); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// sqliteLabels formats the labels of a sample ordered by name, e.g. sensor=outdoor, or
+// the empty string when it has none.
+func sqliteLabels(sample Sample) string {
+	pairs := make([]string, 0, len(sample.Labels))
+	for label, value := range sample.Labels {
+		pairs = append(pairs, label+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,144 @@
+package weather
+
+import (
+	"database/sql"
+	"net/url"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// openTestDB returns an in-memory SQLite database.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection would get a database of its own
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	db := openTestDB(t)
+	for i := 0; i < 2; i++ {
+		// migrating again is a no-op
+		if err := migrateSQLite(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(sqliteMigrations) {
+		t.Errorf("got schema version %d, want %d", version, len(sqliteMigrations))
+	}
+
+	if _, err := db.Exec("PRAGMA user_version = 1000"); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateSQLite(db); err == nil {
+		t.Error("got no error for a newer schema")
+	}
+}
+
+func TestSQLiteMigrationKeepsReports(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.Exec(sqliteMigrations[0] + "; PRAGMA user_version = 1"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := db.Exec(`INSERT INTO reports (id, time, remote_address, name) VALUES (7, 1717243200, '192.168.1.5', 'garden');
+		INSERT INTO observations (report_id, metric, labels, value) VALUES (7, 'ultraviolet', '', 4)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateSQLite(db); err != nil {
+		t.Fatal(err)
+	}
+	var uv float64
+	err = db.QueryRow(`SELECT value FROM reports JOIN observations ON report_id = id
+		WHERE name = 'garden' AND metric = 'ultraviolet'`).Scan(&uv)
+	if err != nil || uv != 4 {
+		t.Errorf("got uv %v (%v), want 4", uv, err)
+	}
+}
+
+func TestSQLiteSink(t *testing.T) {
+	db := openTestDB(t)
+	sink, err := NewSQLiteSink(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	parser.now = func() time.Time { return now }
+	parser.AddObserver(sink)
+	parser.Parse("192.168.1.5", url.Values{"tempf": {"71.2"}, "uv": {"4"}})
+	// a second report within the same second replaces the first
+	parser.Parse("192.168.1.5", url.Values{"tempf": {"72.5"}, "uv": {"4"}})
+	now = now.Add(time.Minute)
+	parser.Parse("192.168.1.5", url.Values{"tempf": {"73.1"}, "uv": {"5"}})
+
+	query := func() []float64 {
+		rows, err := db.Query(`SELECT value FROM reports JOIN observations ON report_id = id
+			WHERE remote_address = ? AND metric = 'temperature' AND labels = 'sensor=outdoor' ORDER BY time`, "192.168.1.5")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var values []float64
+		for rows.Next() {
+			var value float64
+			if err := rows.Scan(&value); err != nil {
+				t.Fatal(err)
+			}
+			values = append(values, value)
+		}
+		return values
+	}
+	waitFor(t, "the reports to be stored", func() bool {
+		values := query()
+		return len(values) == 2 && values[1] == 73.1
+	})
+	if values := query(); values[0] != 72.5 {
+		t.Errorf("got %v, want the first report replaced by 72.5", values[0])
+	}
+	var uv float64
+	err = db.QueryRow(`SELECT value FROM reports JOIN observations ON report_id = id
+		WHERE time = ? AND metric = 'ultraviolet' AND labels = ''`, now.Unix()).Scan(&uv)
+	if err != nil || uv != 5 {
+		t.Errorf("got uv %v (%v), want 5", uv, err)
+	}
+}
+
+func TestSQLiteSinkStationsBehindOneAddress(t *testing.T) {
+	db := openTestDB(t)
+	if err := migrateSQLite(db); err != nil {
+		t.Fatal(err)
+	}
+	sink := &SQLiteSink{db: db}
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for name, uv := range map[string]float64{"garden": 4, "roof": 6} {
+		observation := Observation{
+			RemoteAddress: "192.168.1.5",
+			Name:          name,
+			Time:          now,
+			Samples:       []Sample{{Metric: "ultraviolet", Value: uv}},
+		}
+		if err := sink.store(observation); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, want := range map[string]float64{"garden": 4, "roof": 6} {
+		var uv float64
+		err := db.QueryRow(`SELECT value FROM reports JOIN observations ON report_id = id
+			WHERE name = ? AND metric = 'ultraviolet'`, name).Scan(&uv)
+		if err != nil || uv != want {
+			t.Errorf("%s: got uv %v (%v), want %v", name, uv, err, want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package weather
+
+import (
+	"log/slog"
+	"time"
+)
+
+// ExpireStale starts a background goroutine that removes all series of a station
+// that has not reported within staleAfter. A staleAfter of 0 disables expiry.
+func (p *Parser) ExpireStale(staleAfter time.Duration) {
+	if staleAfter <= 0 {
+		return
+	}
+	interval := staleAfter / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			p.expireStale(staleAfter)
+		}
+	}()
+}
+
+// expireStale removes the series of every station whose last report is older than staleAfter.
+func (p *Parser) expireStale(staleAfter time.Duration) {
+	p.lastReportMu.Lock()
+	defer p.lastReportMu.Unlock()
+
+	now := p.now()
+	for remote_address, last := range p.lastReport {
+		if now.Sub(last) < staleAfter {
+			continue
+		}
+		slog.Info("station stopped reporting, removing its metrics", "remote_address", remote_address, "last_report", last)
+		p.latestMu.Lock()
+		name := p.latest[remote_address].Name
+		p.latestMu.Unlock()
+		for _, gauge := range p.gauges {
+			gauge.deleteStation(remote_address, p.remoteAddressLabel, name)
+		}
+		for _, counter := range p.stationCounters() {
+			counter.deleteStation(remote_address, p.remoteAddressLabel, name)
+		}
+		for _, observer := range p.observers {
+			if expirer, ok := observer.(StationExpirer); ok {
+				expirer.Expire(remote_address)
+			}
+		}
+		delete(p.lastReport, remote_address)
+		p.latestMu.Lock()
+		delete(p.latest, remote_address)
+		p.latestMu.Unlock()
+		p.rainTotalsMu.Lock()
+		delete(p.rainTotals, remote_address)
+		p.rainTotalsMu.Unlock()
+		p.et0Mu.Lock()
+		delete(p.et0, remote_address)
+		p.et0Mu.Unlock()
+		p.dailyTempsMu.Lock()
+		delete(p.dailyTemps, remote_address)
+		p.dailyTempsMu.Unlock()
+		p.lightningMu.Lock()
+		delete(p.lightning, remote_address)
+		p.lightningMu.Unlock()
+		p.pressureMu.Lock()
+		delete(p.pressure, remote_address)
+		p.pressureMu.Unlock()
+	}
+}
+
+// LastReport returns the time of the most recent report of any station, or the zero time
+// when no station has reported yet or all of them expired.
+func (p *Parser) LastReport() time.Time {
+	p.lastReportMu.Lock()
+	defer p.lastReportMu.Unlock()
+	var latest time.Time
+	for _, last := range p.lastReport {
+		if last.After(latest) {
+			latest = last
+		}
+	}
+	return latest
+}
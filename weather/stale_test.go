@@ -0,0 +1,67 @@
+package weather
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestExpireStale(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	parser.now = func() time.Time { return now }
+	report := url.Values{"tempf": {"71.2"}, "humidity": {"40"}, "dailyrainin": {"0.2"}, "baromrelin": {"29.9"}}
+
+	parser.countReport("192.168.1.5", report, nil)
+	parser.Parse("192.168.1.5", report)
+	// a lower total counts a rain reset
+	report.Set("dailyrainin", "0")
+	parser.Parse("192.168.1.5", report)
+	now = now.Add(10 * time.Minute)
+	parser.countReport("192.168.1.6", report, nil)
+	parser.Parse("192.168.1.6", report)
+
+	now = now.Add(5 * time.Minute)
+	parser.expireStale(15 * time.Minute)
+
+	if hasSeries(parser.temperature, "192.168.1.5", "", "outdoor") {
+		t.Error("the temperature of the stale station wasn't removed")
+	}
+	if !hasSeries(parser.temperature, "192.168.1.6", "", "outdoor") {
+		t.Error("the temperature of the fresh station was removed")
+	}
+	if got := testutil.CollectAndCount(parser.rainResets.CounterVec); got != 0 {
+		t.Errorf("got %d rain_reset_total series, want 0", got)
+	}
+	if got := testutil.CollectAndCount(parser.reportsReceived.CounterVec); got != 1 {
+		t.Errorf("got %d reports_received_total series, want 1", got)
+	}
+	for name, state := range map[string]int{
+		"lastReport": len(parser.lastReport),
+		"latest":     len(parser.latest),
+		"rainTotals": len(parser.rainTotals),
+		"dailyTemps": len(parser.dailyTemps),
+		"pressure":   len(parser.pressure),
+	} {
+		if state != 1 {
+			t.Errorf("%s holds %d stations, want 1", name, state)
+		}
+	}
+	if parser.LastReport() != now.Add(-5*time.Minute) {
+		t.Errorf("got last report %v, want that of the fresh station", parser.LastReport())
+	}
+}
+
+func TestExpireStaleWithoutRemoteAddress(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{NoRemoteAddress: true})
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	parser.now = func() time.Time { return now }
+	parser.Parse("192.168.1.5", url.Values{"tempf": {"71.2"}})
+	now = now.Add(time.Hour)
+	parser.expireStale(15 * time.Minute)
+	if got := testutil.CollectAndCount(parser.temperature.GaugeVec); got != 0 {
+		t.Errorf("got %d temperature series, want 0", got)
+	}
+}
@@ -0,0 +1,118 @@
+package weather
+
+import (
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// statsdMaxPacket keeps a packet of gauges below the usual MTU, so it isn't fragmented.
+const statsdMaxPacket = 1432
+
+// statsdChars are replaced in names and tags, they separate the parts of a StatsD line
+var statsdChars = strings.NewReplacer(":", "_", "|", "_", "@", "_", ",", "_", "#", "_", " ", "_", "\n", "_")
+
+// StatsDSink sends every value of a report as a StatsD gauge over UDP, without waiting
+// for or checking delivery. Gauges are named <prefix>.<station>.<metric>[.<label value>...],
+// or <prefix>.<metric> with the station and labels as tags in the DogStatsD format.
+type StatsDSink struct {
+	conn      net.Conn
+	prefix    string
+	tags      bool
+	remoteTag string
+}
+
+// NewStatsDSink sends to the StatsD server at address, e.g. localhost:8125. With tags
+// the labels are sent as DogStatsD tags, the remote address as remoteAddressTag, see
+// Parser.RemoteAddressLabel, or not at all when remoteAddressTag is empty.
+func NewStatsDSink(address string, prefix string, tags bool, remoteAddressTag string) (*StatsDSink, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsDSink{conn: conn, prefix: strings.TrimSuffix(prefix, "."), tags: tags, remoteTag: remoteAddressTag}, nil
+}
+
+func (s *StatsDSink) Observe(observation Observation) {
+	for _, packet := range statsdPackets(statsdLines(observation, s.prefix, s.tags, s.remoteTag)) {
+		if _, err := s.conn.Write([]byte(packet)); err != nil {
+			slog.Debug("failed to send to StatsD", "error", err)
+		}
+	}
+}
+
+// statsdLines formats every sample of an observation as a gauge.
+func statsdLines(observation Observation, prefix string, tags bool, remoteTag string) []string {
+	station := observation.Name
+	if station == "" {
+		station = observation.RemoteAddress
+	}
+	var lines []string
+	for _, sample := range observation.Samples {
+		var name strings.Builder
+		if prefix != "" {
+			name.WriteString(prefix)
+			name.WriteByte('.')
+		}
+		value := strconv.FormatFloat(sample.Value, 'f', -1, 64)
+		if tags {
+			name.WriteString(sample.Metric)
+			var tagList []string
+			if observation.Name != "" {
+				tagList = append(tagList, "name:"+statsdChars.Replace(observation.Name))
+			}
+			for label, labelValue := range sample.Labels {
+				tagList = append(tagList, label+":"+statsdChars.Replace(labelValue))
+			}
+			// sort for a stable order, the labels come from a map
+			sort.Strings(tagList)
+			if remoteTag != "" {
+				tagList = append([]string{remoteTag + ":" + statsdChars.Replace(observation.RemoteAddress)}, tagList...)
+			}
+			line := name.String() + ":" + value + "|g"
+			if len(tagList) > 0 {
+				line += "|#" + strings.Join(tagList, ",")
+			}
+			lines = append(lines, line)
+			continue
+		}
+		name.WriteString(strings.ReplaceAll(statsdChars.Replace(station), ".", "_"))
+		name.WriteByte('.')
+		name.WriteString(sample.Metric)
+		for _, labelValue := range sample.LabelValues() {
+			if labelValue != "" {
+				name.WriteByte('.')
+				name.WriteString(strings.ReplaceAll(statsdChars.Replace(labelValue), ".", "_"))
+			}
+		}
+		if sample.Value < 0 {
+			// a gauge value with a sign changes the gauge by that much, so a negative
+			// value has to be set from 0
+			lines = append(lines, name.String()+":0|g")
+		}
+		lines = append(lines, name.String()+":"+value+"|g")
+	}
+	return lines
+}
+
+// statsdPackets joins lines into packets of at most statsdMaxPacket bytes.
+func statsdPackets(lines []string) []string {
+	var packets []string
+	var packet strings.Builder
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacket {
+			packets = append(packets, packet.String())
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		packets = append(packets, packet.String())
+	}
+	return packets
+}
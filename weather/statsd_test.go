@@ -0,0 +1,95 @@
+package weather
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenStatsD returns a UDP socket standing in for a StatsD server.
+func listenStatsD(t *testing.T) net.PacketConn {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readPacket returns the next packet received on conn.
+func readPacket(t *testing.T, conn net.PacketConn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 65536)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestStatsDSink(t *testing.T) {
+	observation := Observation{
+		RemoteAddress: "192.168.1.5",
+		Name:          "back yard",
+		Samples: []Sample{
+			{Metric: "temperature", Labels: map[string]string{"sensor": "outdoor"}, Value: 71.2},
+			{Metric: "temperature", Labels: map[string]string{"sensor": "soil1"}, Value: -2.5},
+			{Metric: "ultraviolet", Value: 4},
+		},
+	}
+	tests := []struct {
+		name      string
+		tags      bool
+		remoteTag string
+		want      string
+	}{
+		{"plain", false, RemoteAddressLabel, "weather.back_yard.temperature.outdoor:71.2|g\n" +
+			"weather.back_yard.temperature.soil1:0|g\n" +
+			"weather.back_yard.temperature.soil1:-2.5|g\n" +
+			"weather.back_yard.ultraviolet:4|g"},
+		{"dogstatsd", true, RemoteAddressLabel, "weather.temperature:71.2|g|#remote_address:192.168.1.5,name:back_yard,sensor:outdoor\n" +
+			"weather.temperature:-2.5|g|#remote_address:192.168.1.5,name:back_yard,sensor:soil1\n" +
+			"weather.ultraviolet:4|g|#remote_address:192.168.1.5,name:back_yard"},
+		{"dogstatsd without the remote address", true, "", "weather.temperature:71.2|g|#name:back_yard,sensor:outdoor\n" +
+			"weather.temperature:-2.5|g|#name:back_yard,sensor:soil1\n" +
+			"weather.ultraviolet:4|g|#name:back_yard"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := listenStatsD(t)
+			sink, err := NewStatsDSink(server.LocalAddr().String(), "weather.", test.tags, test.remoteTag)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sink.Observe(observation)
+			if got := readPacket(t, server); got != test.want {
+				t.Errorf("got\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStatsDPacketsStayBelowTheMTU(t *testing.T) {
+	line := "weather.roof.temperature.outdoor:71.2|g"
+	var lines []string
+	for i := 0; i < 100; i++ {
+		lines = append(lines, line)
+	}
+	packets := statsdPackets(lines)
+	if len(packets) < 2 {
+		t.Fatalf("got %d packets, want the lines split", len(packets))
+	}
+	count := 0
+	for _, packet := range packets {
+		if len(packet) > statsdMaxPacket {
+			t.Errorf("got a packet of %d bytes, want at most %d", len(packet), statsdMaxPacket)
+		}
+		count += strings.Count(packet, "\n") + 1
+	}
+	if count != len(lines) {
+		t.Errorf("got %d lines in the packets, want %d", count, len(lines))
+	}
+}
@@ -0,0 +1,42 @@
+package weather
+
+import (
+	"math"
+	"time"
+)
+
+// julianDayUnixEpoch is the julian day of 1970-01-01 00:00 UTC
+const julianDayUnixEpoch = 2440587.5
+
+// calculateSunriseSunset returns the sunrise and sunset on the calendar day of day at the
+// given location, following https://en.wikipedia.org/wiki/Sunrise_equation, which agrees
+// with the NOAA solar calculator within a minute or two. In the polar summer or winter the
+// sun doesn't rise or set that day, then ok is false and up tells whether the sun stays up.
+func calculateSunriseSunset(day time.Time, latitude float64, longitude float64) (sunrise time.Time, sunset time.Time, up bool, ok bool) {
+	noon := time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, time.UTC)
+	n := math.Round(float64(noon.Unix())/86400 + julianDayUnixEpoch - 2451545.0)
+	// mean solar noon at the longitude
+	j := n - longitude/360
+	anomaly := math.Mod(357.5291+0.98560028*j, 360) * math.Pi / 180
+	center := 1.9148*math.Sin(anomaly) + 0.02*math.Sin(2*anomaly) + 0.0003*math.Sin(3*anomaly)
+	eclipticLongitude := math.Mod(anomaly*180/math.Pi+center+180+102.9372, 360) * math.Pi / 180
+	transit := 2451545.0 + j + 0.0053*math.Sin(anomaly) - 0.0069*math.Sin(2*eclipticLongitude)
+	declination := math.Asin(math.Sin(eclipticLongitude) * math.Sin(23.4397*math.Pi/180))
+
+	// the sun's center is 0.833° below the horizon at sunrise, for refraction and its radius
+	phi := latitude * math.Pi / 180
+	cosHourAngle := (math.Sin(-0.833*math.Pi/180) - math.Sin(phi)*math.Sin(declination)) /
+		(math.Cos(phi) * math.Cos(declination))
+	if cosHourAngle < -1 {
+		return time.Time{}, time.Time{}, true, false
+	}
+	if cosHourAngle > 1 {
+		return time.Time{}, time.Time{}, false, false
+	}
+	hourAngle := math.Acos(cosHourAngle) * 180 / math.Pi
+	return julianDayToTime(transit - hourAngle/360), julianDayToTime(transit + hourAngle/360), false, true
+}
+
+func julianDayToTime(julianDay float64) time.Time {
+	return time.Unix(0, int64((julianDay-julianDayUnixEpoch)*86400*1e9)).UTC()
+}
@@ -0,0 +1,53 @@
+package weather
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCalculateSunriseSunset(t *testing.T) {
+	// Amsterdam on the summer solstice, the NOAA solar calculator gives 05:18 and 22:06 CEST
+	sunrise, sunset, _, ok := calculateSunriseSunset(time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC), 52.37, 4.89)
+	if !ok {
+		t.Fatal("the sun doesn't rise in Amsterdam")
+	}
+	for _, test := range []struct {
+		what string
+		got  time.Time
+		want time.Time
+	}{
+		{"sunrise", sunrise, time.Date(2024, 6, 21, 3, 18, 0, 0, time.UTC)},
+		{"sunset", sunset, time.Date(2024, 6, 21, 20, 6, 0, 0, time.UTC)},
+	} {
+		if diff := test.got.Sub(test.want).Abs(); diff > 3*time.Minute {
+			t.Errorf("%s at %v, want %v", test.what, test.got, test.want)
+		}
+	}
+
+	// Tromsø has the midnight sun in summer and the polar night in winter
+	if _, _, up, ok := calculateSunriseSunset(time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC), 69.65, 18.96); ok || !up {
+		t.Errorf("Tromsø in summer: ok %v up %v, want the sun to stay up", ok, up)
+	}
+	if _, _, up, ok := calculateSunriseSunset(time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC), 69.65, 18.96); ok || up {
+		t.Errorf("Tromsø in winter: ok %v up %v, want the sun to stay down", ok, up)
+	}
+}
+
+func TestParseDaylight(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	parser.SetLatitude(52.37)
+	parser.SetLongitude(4.89)
+	remote := "192.168.1.5"
+	for at, want := range map[time.Time]float64{
+		time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC): 1,
+		time.Date(2024, 6, 21, 1, 0, 0, 0, time.UTC):  0,
+		time.Date(2024, 6, 21, 21, 0, 0, 0, time.UTC): 0,
+	} {
+		parser.now = func() time.Time { return at }
+		parser.Parse(remote, url.Values{"tempf": {"71.2"}})
+		if got := gaugeValue(parser.daylight, remote, ""); got != want {
+			t.Errorf("is_daylight at %v = %v, want %v", at, got, want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package weather
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// thresholdOps are the comparisons a threshold can make.
+var thresholdOps = map[string]func(value float64, threshold float64) bool{
+	"<":  func(value, threshold float64) bool { return value < threshold },
+	"<=": func(value, threshold float64) bool { return value <= threshold },
+	">":  func(value, threshold float64) bool { return value > threshold },
+	">=": func(value, threshold float64) bool { return value >= threshold },
+	"==": func(value, threshold float64) bool { return value == threshold },
+	"!=": func(value, threshold float64) bool { return value != threshold },
+}
+
+// Threshold is a condition on a field of the reports, e.g. tempf < 32. Its
+// threshold_breach series is 1 while the condition holds and 0 when it doesn't.
+type Threshold struct {
+	// Name is the value of the 'threshold' label, by default e.g. "tempf<32".
+	Name string `yaml:"name"`
+	// Field is a field of the report as Ambient Weather names it, e.g. tempf or
+	// windspeedmph, in the units the station reports it in.
+	Field string  `yaml:"field"`
+	Op    string  `yaml:"op"`
+	Value float64 `yaml:"value"`
+}
+
+// label returns the value of the 'threshold' label.
+func (t Threshold) label() string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return t.Field + t.Op + strconv.FormatFloat(t.Value, 'f', -1, 64)
+}
+
+// SetThresholds sets the conditions that are evaluated for every report. It fails on a
+// threshold without a field or with an unknown op, or on two thresholds with the same name.
+func (p *Parser) SetThresholds(thresholds []Threshold) error {
+	names := map[string]bool{}
+	for _, threshold := range thresholds {
+		if threshold.Field == "" {
+			return fmt.Errorf("threshold %q has no field", threshold.label())
+		}
+		if _, ok := thresholdOps[threshold.Op]; !ok {
+			return fmt.Errorf("threshold %q has unknown op %q, use one of < <= > >= == !=", threshold.label(), threshold.Op)
+		}
+		if names[threshold.label()] {
+			return fmt.Errorf("threshold %q is defined twice", threshold.label())
+		}
+		names[threshold.label()] = true
+	}
+	p.thresholds = thresholds
+	return nil
+}
+
+// updateThresholds sets the threshold_breach series of a station. parseValue returns an
+// error for a field that isn't in the report or isn't a number, the series of its
+// thresholds are removed.
+func (p *Parser) updateThresholds(remote_address string, name string, parseValue func(field string) (float64, error)) {
+	for _, threshold := range p.thresholds {
+		labels := []string{remote_address, name, threshold.label()}
+		value, err := parseValue(threshold.Field)
+		if err != nil {
+			p.thresholdBreach.DeleteLabelValues(labels...)
+			continue
+		}
+		breached := 0.0
+		if thresholdOps[threshold.Op](value, threshold.Value) {
+			breached = 1
+		}
+		p.thresholdBreach.WithLabelValues(labels...).Set(breached)
+	}
+}
@@ -0,0 +1,67 @@
+package weather
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestThresholds(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	err := parser.SetThresholds([]Threshold{
+		{Name: "freezing", Field: "tempf", Op: "<", Value: 32},
+		{Field: "tempf", Op: "<=", Value: 30},
+		{Field: "windspeedmph", Op: ">", Value: 25},
+		{Field: "humidity", Op: ">=", Value: 90},
+		{Field: "uv", Op: "==", Value: 0},
+		{Field: "battout", Op: "!=", Value: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const remote = "192.168.1.5"
+	parser.Parse(remote, url.Values{"tempf": {"30"}, "windspeedmph": {"12.5"}, "humidity": {"90"}, "uv": {"3"}, "battout": {"abc"}})
+
+	for _, test := range []struct {
+		threshold string
+		want      float64
+	}{
+		{"freezing", 1},
+		{"tempf<=30", 1},
+		{"windspeedmph>25", 0},
+		{"humidity>=90", 1},
+		{"uv==0", 0},
+	} {
+		if got := gaugeValue(parser.thresholdBreach, remote, "", test.threshold); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.threshold, got, test.want)
+		}
+	}
+	// a field that isn't a number has no series
+	if hasSeries(parser.thresholdBreach, remote, "", "battout!=1") {
+		t.Error("got a series for a field that isn't a number")
+	}
+
+	// a field that is missing from the next report removes its series
+	parser.Parse(remote, url.Values{"tempf": {"33"}})
+	if got := gaugeValue(parser.thresholdBreach, remote, "", "freezing"); got != 0 {
+		t.Errorf("freezing: got %v after a warmer report, want 0", got)
+	}
+	for _, threshold := range []string{"windspeedmph>25", "humidity>=90", "uv==0"} {
+		if hasSeries(parser.thresholdBreach, remote, "", threshold) {
+			t.Errorf("%s: got a series for a missing field", threshold)
+		}
+	}
+}
+
+func TestSetThresholdsRejectsInvalid(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	for _, thresholds := range [][]Threshold{
+		{{Op: "<", Value: 32}},
+		{{Field: "tempf", Op: "=<", Value: 32}},
+		{{Field: "tempf", Op: "<", Value: 32}, {Field: "tempf", Op: "<", Value: 32}},
+		{{Name: "cold", Field: "tempf", Op: "<", Value: 32}, {Name: "cold", Field: "tempinf", Op: "<", Value: 50}},
+	} {
+		if err := parser.SetThresholds(thresholds); err == nil {
+			t.Errorf("%+v: got no error", thresholds)
+		}
+	}
+}
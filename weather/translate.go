@@ -0,0 +1,73 @@
+package weather
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// fieldTranslation maps a field of another upload protocol to the Ambient Weather field
+// with the same meaning, convert is applied to the value and may be nil.
+type fieldTranslation struct {
+	name    string
+	convert func(float64) float64
+}
+
+// translateFields renames and converts fields to their Ambient Weather equivalent, reading
+// their values like Parse does, see SetDuplicates and SetDecimalComma. Fields that are not
+// in the table are passed through unchanged.
+func (p *Parser) translateFields(values url.Values, table map[string]fieldTranslation) url.Values {
+	translated := url.Values{}
+	// copy unknown fields first so translated fields win when both are present
+	for key, value := range values {
+		if _, ok := table[key]; !ok {
+			translated[key] = value
+		}
+	}
+	for key, value := range values {
+		field, ok := table[key]
+		if !ok || len(value) == 0 {
+			continue
+		}
+		str := p.pick(value)
+		if field.convert == nil {
+			translated.Set(field.name, str)
+			continue
+		}
+		if p.decimalComma {
+			str = commaToDot(str)
+		}
+		number, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			// let Parse report the bad value
+			translated.Set(field.name, str)
+			continue
+		}
+		translated.Set(field.name, strconv.FormatFloat(field.convert(number), 'f', -1, 64))
+	}
+	return translated
+}
+
+// reverseFields renames fields from their Ambient Weather name to the one in table, the
+// reverse of translateFields. Fields that table converts are left out, their encoding
+// differs, other fields are passed through unchanged.
+func reverseFields(values url.Values, table map[string]fieldTranslation) url.Values {
+	reversed := url.Values{}
+	for key, value := range values {
+		reversed[key] = value
+	}
+	for _, field := range table {
+		if field.convert != nil {
+			reversed.Del(field.name)
+		}
+	}
+	for key, field := range table {
+		if field.convert != nil || key == field.name {
+			continue
+		}
+		if value, ok := values[field.name]; ok {
+			reversed.Del(field.name)
+			reversed[key] = value
+		}
+	}
+	return reversed
+}
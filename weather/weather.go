@@ -1,202 +1,1007 @@
 package weather
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"math"
+	"net"
 	"net/http"
 	"net/url"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// DefaultReportPath is where Ambient Weather stations send their reports by default.
+const DefaultReportPath = "/data/report/"
+
+var (
+	// portPattern matches the port of a remote address
+	portPattern = regexp.MustCompile(`^(.*):\d+$`)
+	// passkeyPattern matches the PASSKEY (or weather underground PASSWORD, or WeatherCloud
+	// key, which is sent as a path segment) in a url
+	passkeyPattern = regexp.MustCompile(`(^|[&/?])(PASSKEY=|PASSWORD=|key=|key/)[^&/]*`)
+)
+
+// errNoSuchParam is returned for fields that are missing from a report, it is
+// preallocated because most reports lack many of the fields.
+var errNoSuchParam = errors.New("no such param")
+
+var (
+	// humidityFields holds the temperature and relative humidity field of each location
+	humidityFields = map[string][2]string{"outdoor": {"tempf", "humidity"}, "indoor": {"tempinf", "humidityin"}}
+	// windSpeedFields maps the wind speed fields to their type label
+	windSpeedFields = map[string]string{"windspeedmph": "sustained", "windgustmph": "gusts", "maxdailygust": "daily_max", "windspdmph_avg2m": "avg2m", "windspdmph_avg10m": "avg10m"}
+	// cumulativeRainFields maps the rain fields that only go up until a reset to their period label
+	cumulativeRainFields = map[string]string{"dailyrainin": "daily", "weeklyrainin": "weekly", "monthlyrainin": "monthly", "yearlyrainin": "yearly", "totalrainin": "total", "eventrainin": "event"}
+)
+
+// DefaultMaxBodyBytes limits the size of a POSTed report body
+const DefaultMaxBodyBytes = 64 << 10
+
+// DefaultLuxPerWm2 approximates the illuminance of sunlight per W/m2 of solar radiation.
+const DefaultLuxPerWm2 = 126.7
+
+// maxUV is the highest plausible UV index, higher readings come from a faulty sensor
+const maxUV = 20
+
+const (
+	UnitsImperial = "imperial"
+	UnitsMetric   = "metric"
+)
+
+// Which value of a field that is in a report more than once is used.
+const (
+	DuplicatesFirst = "first"
+	DuplicatesLast  = "last"
+)
+
 type Parser struct {
 	name                  string
-	be_verbose            bool
+	stationNames          atomic.Pointer[map[string]string] // PASSKEY, mac or stationtype to name
+	sensorNames           atomic.Pointer[map[string]string] // channel to sensor label
+	allowedNetworks       []*net.IPNet
 	metric_prefix         string
-	temperature           *prometheus.GaugeVec
-	battery               *prometheus.GaugeVec // 1 = ok; 0 = low
-	humidity              *prometheus.GaugeVec
-	barometer             *prometheus.GaugeVec
-	windDir               *prometheus.GaugeVec
-	windSpeedMph          *prometheus.GaugeVec
-	solarRadiation        *prometheus.GaugeVec
-	rainIn                *prometheus.GaugeVec
-	ultraviolet           *prometheus.GaugeVec
-	lightning_strikes     *prometheus.GaugeVec
-	lightning_last_strike *prometheus.GaugeVec
-	lightning_distance    *prometheus.GaugeVec
-	stationtype           *prometheus.GaugeVec
-}
-
-func NewParser(name string, metric_prefix string, be_verbose bool, factory *promauto.Factory) *Parser {
-	return &Parser{
+	units                 string
+	reportPath            string
+	temperature           *stationGaugeVec
+	battery               *stationGaugeVec // 1 = ok; 0 = low
+	humidity              *stationGaugeVec
+	barometer             *stationGaugeVec
+	windDir               *stationGaugeVec
+	windSpeedMph          *stationGaugeVec
+	solarRadiation        *stationGaugeVec
+	rainIn                *stationGaugeVec
+	ultraviolet           *stationGaugeVec
+	lightning_strikes     *stationGaugeVec
+	lightning_last_strike *stationGaugeVec
+	lightning_distance    *stationGaugeVec
+	stationtype           *stationGaugeVec
+	pm25                  *stationGaugeVec
+	pm10                  *stationGaugeVec
+	co2                   *stationGaugeVec
+	leak                  *stationGaugeVec // 1 = leak; 0 = dry
+	leafWetness           *stationGaugeVec
+	airQualityIndex       *stationGaugeVec
+	barometerHPa          *stationGaugeVec
+	windSpeedMs           *stationGaugeVec
+	windSpeedKmh          *stationGaugeVec
+	absoluteHumidity      *stationGaugeVec
+	vaporPressureDeficit  *stationGaugeVec
+	cloudBase             *stationGaugeVec
+	airDensity            *stationGaugeVec
+	humidexDanger         *stationGaugeVec
+	solarLux              *stationGaugeVec
+	luxPerWm2             float64
+	decimalComma          bool
+	exemplars             bool
+	duplicates            string
+	remoteAddressLabel    string
+	maxBodyBytes          int64
+	evapotranspiration    *stationGaugeVec
+	latitude              *float64
+	longitude             *float64
+	stationInfo           *stationGaugeVec
+	sunrise               *stationGaugeVec
+	sunset                *stationGaugeVec
+	daylight              *stationGaugeVec
+	moonPhase             *stationGaugeVec
+	moonIllumination      *stationGaugeVec
+	timezone              *time.Location
+	growingDegreeDays     *stationGaugeVec
+	gddBase               float64
+	temperatureCelsius    *stationGaugeVec
+	lastReportTimestamp   *stationGaugeVec
+	observationTimestamp  *stationGaugeVec
+	rainRate              *stationGaugeVec
+	batteryVoltage        *stationGaugeVec
+	thresholdBreach       *stationGaugeVec
+	pressureTrend         *stationGaugeVec
+	forecast              *stationGaugeVec
+	thresholds            []Threshold
+	batteryLowVoltage     float64
+	altitudeMeters        float64
+	reportsReceived       *stationCounterVec
+	parseErrors           *stationCounterVec
+	rainResets            *stationCounterVec
+	growingDegreeDaysSum  *stationCounterVec
+	fields                []fieldGauge
+	gauges                map[string]*stationGaugeVec // by metric name, see gaugeVecs
+	observers             []Observer
+	forwarder             *Forwarder
+	csvLog                *CSVLog
+	lightningWebhook      *lightningWebhook
+
+	// last report time per remote_address, used to expire stale stations
+	lastReportMu sync.Mutex
+	lastReport   map[string]time.Time
+	now          func() time.Time
+
+	// most recent observation per remote_address
+	latestMu sync.Mutex
+	latest   map[string]Observation
+
+	// previous cumulative rain per remote_address and period, to detect counter resets
+	rainTotalsMu sync.Mutex
+	rainTotals   map[string]map[string]float64
+
+	// outdoor temperature range today per remote_address
+	dailyTempsMu sync.Mutex
+	dailyTemps   map[string]*dailyTemperature
+
+	// evapotranspiration accumulated today per remote_address
+	et0Mu sync.Mutex
+	et0   map[string]*dailyET0
+
+	// channels of the clients of the events stream
+	subscribersMu     sync.Mutex
+	subscribers       map[chan Observation]struct{}
+	maxSubscribers    int
+	subscribersClosed bool
+
+	// relative pressure of the last 3 hours per remote_address and name
+	pressureMu sync.Mutex
+	pressure   map[string]map[string]*pressureHistory
+
+	// lightning fields of the previous report and last alert per remote_address
+	lightningMu sync.Mutex
+	lightning   map[string]*lightningState
+}
+
+// NewParser creates a parser registering its metrics with factory, labelOptions
+// configures their labels.
+func NewParser(name string, metric_prefix string, units string, labelOptions LabelOptions, factory *promauto.Factory) *Parser {
+	temperatureHelp := "temperature Temperature in fahrenheit"
+	barometerHelp := "barometer"
+	windSpeedHelp := "wind_speed_mph"
+	rainHelp := "Rain in inches"
+	rainRateHelp := "Rain rate in inches per hour, not an accumulation"
+	degreeDaysUnit := "fahrenheit"
+	rainName, rainRateName := rainMetricNames(units)
+	if units == UnitsMetric {
+		temperatureHelp = "temperature Temperature in celsius"
+		barometerHelp = "barometer in hPa"
+		windSpeedHelp = "wind speed in km/h"
+		rainHelp = "Rain in millimeters"
+		rainRateHelp = "Rain rate in millimeters per hour, not an accumulation"
+		degreeDaysUnit = "celsius"
+	}
+	p := &Parser{
 		name:                  name,
-		be_verbose:            be_verbose,
 		metric_prefix:         metric_prefix,
-		temperature:           newGauge(factory, metric_prefix, "temperature", "temperature Temperature in fahrenheit", "remote_adress", "name", "sensor"),
-		battery:               newGauge(factory, metric_prefix, "battery", "battery", "remote_adress", "name", "sensor"),
-		humidity:              newGauge(factory, metric_prefix, "humidity", "humidity", "remote_adress", "name", "sensor"),
-		barometer:             newGauge(factory, metric_prefix, "barometer", "barometer", "remote_adress", "name", "type"),
-		windDir:               newGauge(factory, metric_prefix, "wind_dir", "wind_dir", "remote_adress", "name", "period"),
-		windSpeedMph:          newGauge(factory, metric_prefix, "wind_speed_mph", "wind_speed_mph", "remote_adress", "name", "type"),
-		solarRadiation:        newGauge(factory, metric_prefix, "solar_radiation", "Solar radiation in W/m2", "remote_adress", "name"),
-		rainIn:                newGauge(factory, metric_prefix, "rain_in", "Rain in inches", "remote_adress", "name", "period"),
-		ultraviolet:           newGauge(factory, metric_prefix, "ultraviolet", "Ultra Violet index 1-10", "remote_adress", "name"),
-		lightning_strikes:     newGauge(factory, metric_prefix, "lightning_strikes", "lightning_strikes", "remote_adress", "name", "period"),
-		lightning_last_strike: newGauge(factory, metric_prefix, "lightning_last_strike", "in seconds since Epoch", "remote_adress", "name"),
-		lightning_distance:    newGauge(factory, metric_prefix, "lightning_distance", "last lightning strike distance in km", "remote_adress", "name"),
-		stationtype:           newGauge(factory, metric_prefix, "stationtype_info", "stationtype_info", "remote_adress", "name", "type"),
+		units:                 units,
+		reportPath:            DefaultReportPath,
+		duplicates:            DuplicatesFirst,
+		remoteAddressLabel:    labelOptions.remoteAddressLabel(),
+		maxBodyBytes:          DefaultMaxBodyBytes,
+		temperature:           newGauge(factory, metric_prefix, labelOptions, "temperature", temperatureHelp, RemoteAddressLabel, "name", "sensor"),
+		battery:               newGauge(factory, metric_prefix, labelOptions, "battery", "battery", RemoteAddressLabel, "name", "sensor"),
+		humidity:              newGauge(factory, metric_prefix, labelOptions, "humidity", "humidity", RemoteAddressLabel, "name", "sensor"),
+		barometer:             newGauge(factory, metric_prefix, labelOptions, "barometer", barometerHelp, RemoteAddressLabel, "name", "type"),
+		windDir:               newGauge(factory, metric_prefix, labelOptions, "wind_dir", "wind_dir", RemoteAddressLabel, "name", "period"),
+		windSpeedMph:          newGauge(factory, metric_prefix, labelOptions, windSpeedMetricName(units), windSpeedHelp, RemoteAddressLabel, "name", "type"),
+		solarRadiation:        newGauge(factory, metric_prefix, labelOptions, "solar_radiation", "Solar radiation in W/m2", RemoteAddressLabel, "name"),
+		rainIn:                newGauge(factory, metric_prefix, labelOptions, rainName, rainHelp, RemoteAddressLabel, "name", "period"),
+		ultraviolet:           newGauge(factory, metric_prefix, labelOptions, "ultraviolet", "Ultra Violet index 1-10", RemoteAddressLabel, "name"),
+		lightning_strikes:     newGauge(factory, metric_prefix, labelOptions, "lightning_strikes", "lightning_strikes", RemoteAddressLabel, "name", "period"),
+		lightning_last_strike: newGauge(factory, metric_prefix, labelOptions, "lightning_last_strike", "in seconds since Epoch", RemoteAddressLabel, "name"),
+		lightning_distance:    newGauge(factory, metric_prefix, labelOptions, "lightning_distance", "last lightning strike distance in km", RemoteAddressLabel, "name"),
+		stationtype:           newGauge(factory, metric_prefix, labelOptions, "stationtype_info", "stationtype_info", RemoteAddressLabel, "name", "type"),
+		pm25:                  newGauge(factory, metric_prefix, labelOptions, "pm25", "PM2.5 particulate matter in µg/m3", RemoteAddressLabel, "name", "location", "period"),
+		pm10:                  newGauge(factory, metric_prefix, labelOptions, "pm10", "PM10 particulate matter in µg/m3", RemoteAddressLabel, "name"),
+		co2:                   newGauge(factory, metric_prefix, labelOptions, "co2", "CO2 concentration in ppm", RemoteAddressLabel, "name", "location", "period"),
+		leak:                  newGauge(factory, metric_prefix, labelOptions, "leak", "Leak detected 1 = leak; 0 = dry", RemoteAddressLabel, "name", "sensor"),
+		leafWetness:           newGauge(factory, metric_prefix, labelOptions, "leaf_wetness", "Leaf wetness in percent", RemoteAddressLabel, "name", "sensor"),
+		airQualityIndex:       newGauge(factory, metric_prefix, labelOptions, "air_quality_index", "US EPA AQI calculated from PM2.5", RemoteAddressLabel, "name"),
+		barometerHPa:          newGauge(factory, metric_prefix, labelOptions, "barometer_hpa", "barometer in hPa", RemoteAddressLabel, "name", "type"),
+		windSpeedMs:           newGauge(factory, metric_prefix, labelOptions, "wind_speed_ms", "wind speed in m/s", RemoteAddressLabel, "name", "type"),
+		absoluteHumidity:      newGauge(factory, metric_prefix, labelOptions, "absolute_humidity", "absolute humidity in g/m3", RemoteAddressLabel, "name", "sensor"),
+		vaporPressureDeficit:  newGauge(factory, metric_prefix, labelOptions, "vapor_pressure_deficit", "vapor pressure deficit in kPa", RemoteAddressLabel, "name", "sensor"),
+		cloudBase:             newGauge(factory, metric_prefix, labelOptions, "cloud_base_feet", "estimated cloud base height in feet above the station", RemoteAddressLabel, "name"),
+		airDensity:            newGauge(factory, metric_prefix, labelOptions, "air_density", "air density in kg/m3", RemoteAddressLabel, "name"),
+		humidexDanger:         newGauge(factory, metric_prefix, labelOptions, "humidex_danger", "humidex in the dangerous range 1 = danger; 0 = safe", RemoteAddressLabel, "name"),
+		solarLux:              newGauge(factory, metric_prefix, labelOptions, "solar_lux", "illuminance in lux, approximated from the solar radiation", RemoteAddressLabel, "name"),
+		luxPerWm2:             DefaultLuxPerWm2,
+		evapotranspiration:    newGauge(factory, metric_prefix, labelOptions, "evapotranspiration_mm", "reference evapotranspiration ET0 since local midnight in mm", RemoteAddressLabel, "name"),
+		timezone:              time.Local,
+		growingDegreeDays:     newGauge(factory, metric_prefix, labelOptions, "growing_degree_days", "growing degree days in "+degreeDaysUnit+" since local midnight", RemoteAddressLabel, "name"),
+		gddBase:               DefaultGDDBase,
+		stationInfo:           newGauge(factory, metric_prefix, labelOptions, "station_info", "mac address of the station and its location from the -latitude, -longitude and -altitude-meters flags", RemoteAddressLabel, "name", "mac", "latitude", "longitude", "altitude"),
+		sunrise:               newGauge(factory, metric_prefix, labelOptions, "sunrise_timestamp_seconds", "time of today's sunrise in seconds since Epoch", RemoteAddressLabel, "name"),
+		sunset:                newGauge(factory, metric_prefix, labelOptions, "sunset_timestamp_seconds", "time of today's sunset in seconds since Epoch", RemoteAddressLabel, "name"),
+		daylight:              newGauge(factory, metric_prefix, labelOptions, "is_daylight", "sun above the horizon 1 = day; 0 = night", RemoteAddressLabel, "name"),
+		moonPhase:             newGauge(factory, metric_prefix, labelOptions, "moon_phase", "fraction of the lunar cycle 0 = new moon; 0.5 = full moon", RemoteAddressLabel, "name"),
+		moonIllumination:      newGauge(factory, metric_prefix, labelOptions, "moon_illumination", "illuminated part of the moon in percent", RemoteAddressLabel, "name"),
+		temperatureCelsius:    newGauge(factory, metric_prefix, labelOptions, "temperature_celsius", "derived temperatures in celsius", RemoteAddressLabel, "name", "sensor"),
+		pressureTrend:         newGauge(factory, metric_prefix, labelOptions, "pressure_trend_inhg_per_3h", "change of the relative pressure in inHg per 3 hours, fitted over the last 3 hours", RemoteAddressLabel, "name"),
+		forecast:              newGauge(factory, metric_prefix, labelOptions, "forecast_info", "Zambretti forecast from the relative pressure and its trend", RemoteAddressLabel, "name", "trend", "forecast"),
+		thresholdBreach:       newGauge(factory, metric_prefix, labelOptions, "threshold_breach", "1 = the condition of the threshold from the config file holds; 0 = it doesn't", RemoteAddressLabel, "name", "threshold"),
+		lastReportTimestamp:   newGauge(factory, metric_prefix, labelOptions, "last_report_timestamp_seconds", "time of the last report in seconds since Epoch", RemoteAddressLabel, "name"),
+		observationTimestamp:  newGauge(factory, metric_prefix, labelOptions, "observation_timestamp_seconds", "time of the observation by the station clock in seconds since Epoch", RemoteAddressLabel, "name"),
+		rainRate:              newGauge(factory, metric_prefix, labelOptions, rainRateName, rainRateHelp, RemoteAddressLabel, "name"),
+		batteryVoltage:        newGauge(factory, metric_prefix, labelOptions, "battery_voltage", "battery voltage of sensors that report one", RemoteAddressLabel, "name", "sensor"),
+		batteryLowVoltage:     DefaultBatteryLowVoltage,
+		reportsReceived:       newCounter(factory, metric_prefix, labelOptions, "reports_received_total", "number of weather reports received", RemoteAddressLabel, "status"),
+		parseErrors:           newCounter(factory, metric_prefix, labelOptions, "parse_errors_total", "number of errors parsing weather reports", "reason"),
+		growingDegreeDaysSum:  newCounter(factory, metric_prefix, labelOptions, "growing_degree_days_total", "growing degree days in "+degreeDaysUnit+" of the finished days", RemoteAddressLabel, "name"),
+		rainResets:            newCounter(factory, metric_prefix, labelOptions, "rain_reset_total", "number of times a cumulative rain value decreased", RemoteAddressLabel, "name", "period"),
+		lastReport:            make(map[string]time.Time),
+		latest:                make(map[string]Observation),
+		rainTotals:            make(map[string]map[string]float64),
+		et0:                   make(map[string]*dailyET0),
+		dailyTemps:            make(map[string]*dailyTemperature),
+		lightning:             make(map[string]*lightningState),
+		pressure:              make(map[string]map[string]*pressureHistory),
+		subscribers:           make(map[chan Observation]struct{}),
+		maxSubscribers:        DefaultMaxSubscribers,
+		now:                   time.Now,
 	}
+	if units == UnitsMetric {
+		// the wind speed is exported in km/h already
+		p.windSpeedKmh = p.windSpeedMph
+	} else {
+		p.windSpeedKmh = newGauge(factory, metric_prefix, labelOptions, "wind_speed_kmh", "wind speed in km/h", RemoteAddressLabel, "name", "type")
+	}
+	p.fields = p.fieldGauges()
+	p.gauges = p.gaugeVecs()
+	return p
+}
+
+// stationCounters returns the counters that have series per station.
+func (p *Parser) stationCounters() []*stationCounterVec {
+	return []*stationCounterVec{p.reportsReceived, p.rainResets, p.growingDegreeDaysSum}
 }
 
-func newGauge(factory *promauto.Factory, metric_prefix string, name string, help string, labels ...string) *prometheus.GaugeVec {
-	opts := prometheus.GaugeOpts{
-		Name:      name,
-		Help:      help,
-		Namespace: metric_prefix,
+// gaugeVecs returns every per-station gauge by its metric name, so a station's series
+// can be removed or read in one go.
+func (p *Parser) gaugeVecs() map[string]*stationGaugeVec {
+	rainName, rainRateName := rainMetricNames(p.units)
+	// with metric units windSpeedName is wind_speed_kmh, both hold the same gauge
+	windSpeedName := windSpeedMetricName(p.units)
+	return map[string]*stationGaugeVec{
+		"temperature":                   p.temperature,
+		"battery":                       p.battery,
+		"humidity":                      p.humidity,
+		"barometer":                     p.barometer,
+		"wind_dir":                      p.windDir,
+		windSpeedName:                   p.windSpeedMph,
+		"solar_radiation":               p.solarRadiation,
+		rainName:                        p.rainIn,
+		"ultraviolet":                   p.ultraviolet,
+		"lightning_strikes":             p.lightning_strikes,
+		"lightning_last_strike":         p.lightning_last_strike,
+		"lightning_distance":            p.lightning_distance,
+		"stationtype_info":              p.stationtype,
+		"pm25":                          p.pm25,
+		"pm10":                          p.pm10,
+		"co2":                           p.co2,
+		"leak":                          p.leak,
+		"leaf_wetness":                  p.leafWetness,
+		"air_quality_index":             p.airQualityIndex,
+		"barometer_hpa":                 p.barometerHPa,
+		"wind_speed_ms":                 p.windSpeedMs,
+		"wind_speed_kmh":                p.windSpeedKmh,
+		"absolute_humidity":             p.absoluteHumidity,
+		"vapor_pressure_deficit":        p.vaporPressureDeficit,
+		"cloud_base_feet":               p.cloudBase,
+		"air_density":                   p.airDensity,
+		"humidex_danger":                p.humidexDanger,
+		"solar_lux":                     p.solarLux,
+		"evapotranspiration_mm":         p.evapotranspiration,
+		"growing_degree_days":           p.growingDegreeDays,
+		"station_info":                  p.stationInfo,
+		"sunrise_timestamp_seconds":     p.sunrise,
+		"sunset_timestamp_seconds":      p.sunset,
+		"is_daylight":                   p.daylight,
+		"moon_phase":                    p.moonPhase,
+		"moon_illumination":             p.moonIllumination,
+		"temperature_celsius":           p.temperatureCelsius,
+		"last_report_timestamp_seconds": p.lastReportTimestamp,
+		"observation_timestamp_seconds": p.observationTimestamp,
+		rainRateName:                    p.rainRate,
+		"battery_voltage":               p.batteryVoltage,
+		"threshold_breach":              p.thresholdBreach,
+		"pressure_trend_inhg_per_3h":    p.pressureTrend,
+		"forecast_info":                 p.forecast,
 	}
-	return factory.NewGaugeVec(opts, labels)
 }
 
 func (p *Parser) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if !p.allowed(resp, req) || !reportMethod(resp, req) {
+		return
+	}
+	remote_address, values, err := p.readReport(resp, req)
+	// ambient weather stations send the fields in the path, e.g. /data/report/&PASSKEY=...&tempf=71.2
+	pathValues, pathErr := url.ParseQuery(strings.TrimPrefix(req.URL.Path, p.reportPath))
+	for key, value := range pathValues {
+		values[key] = append(values[key], value...)
+	}
+	if err == nil {
+		err = pathErr
+	}
+	if p.rejectTooLarge(resp, remote_address, err) {
+		return
+	}
+	// respond immediately
+	resp.WriteHeader(http.StatusNoContent)
+	p.countReport(remote_address, values, err)
+	p.handleReport(req, remote_address, values, true)
+}
+
+// handleReport parses a report and forwards it, values are its fields in the Ambient
+// Weather protocol. ambient is set when req itself is in the Ambient Weather protocol, so
+// it can be relayed as it is.
+func (p *Parser) handleReport(req *http.Request, remote_address string, values url.Values, ambient bool) {
+	p.Parse(remote_address, values)
+	if p.forwarder != nil {
+		p.forwarder.Forward(req, values, ambient)
+	}
+}
+
+// reportMethod answers 405 Method Not Allowed to anything but a GET or POST, which are
+// the methods stations send reports with, and reports whether the method is allowed.
+func reportMethod(resp http.ResponseWriter, req *http.Request) bool {
+	if req.Method == http.MethodGet || req.Method == http.MethodPost {
+		return true
+	}
+	resp.Header().Set("Allow", "GET, POST")
+	http.Error(resp, "only GET and POST are supported", http.StatusMethodNotAllowed)
+	return false
+}
+
+// readReport returns the sender and the fields of a report, whether they were sent
+// in the query string or a POSTed form body.
+func (p *Parser) readReport(resp http.ResponseWriter, req *http.Request) (string, url.Values, error) {
 	// parse request url.
-	var re = regexp.MustCompile(`^(.*):\d+$`)
-	remote_adress := re.ReplaceAllString(req.RemoteAddr, "$1")
+	remote_address := portPattern.ReplaceAllString(req.RemoteAddr, "$1")
 
-	// remove PASSKEY value from url
-	re = regexp.MustCompile(`&PASSKEY=[^&]*`)
-	req.URL.Path = re.ReplaceAllString(req.URL.Path, "&PASSKEY=******")
+	// remove PASSKEY (or weather underground PASSWORD, or WeatherCloud key) value from the
+	// logged url, it can be in the path or in the query string
+	logged := req.URL.Path
+	if req.URL.RawQuery != "" {
+		logged += "?" + req.URL.RawQuery
+	}
+	logged = passkeyPattern.ReplaceAllString(logged, "${1}${2}******")
+	p.Log("sample submitted", "remote_address", remote_address, "url", logged)
 
-	p.Log("sample submitted by remote_adress %s: %s", remote_adress, req.URL.Path)
+	// POSTed reports carry their fields in a form body instead of the url, ParseForm
+	// merges them with the query string
+	req.Body = http.MaxBytesReader(resp, req.Body, p.maxBodyBytes)
+	err := req.ParseForm()
+	values := url.Values{}
+	for key, value := range req.Form {
+		values[key] = slices.Clone(value)
+	}
+	return remote_address, values, err
+}
 
-	// make url more easilily parseable
-	queryStr := strings.Replace(req.URL.Path, "/data/report/", "", 1)
-	// respond immediately
-	resp.WriteHeader(http.StatusNoContent)
-	values, err := url.ParseQuery(queryStr)
+// countReport updates the report counters, values and err are returned by readReport.
+func (p *Parser) countReport(remote_address string, values url.Values, err error) {
 	if err != nil {
-		log.Printf("Failed to parse weather observation from request url: %+v", err)
+		slog.Warn("failed to parse weather observation from request", "remote_address", remote_address, "error", err)
+		p.reportsReceived.WithLabelValues(remote_address, "invalid").Inc()
+		p.parseErrors.WithLabelValues("query").Inc()
+		return
+	}
+	var observed time.Time
+	if p.exemplars && values.Has("dateutc") {
+		observed, _ = parseDateUTC(stripNewlines(p.pick(values["dateutc"])), p.now())
 	}
-	p.Parse(remote_adress, values)
+	p.addWithExemplar(p.reportsReceived.WithLabelValues(remote_address, "ok"), 1, observed)
 }
 
-func (p *Parser) Log(format string, a ...any) {
-	if p.be_verbose {
-		log.Printf(format, a...)
+// addWithExemplar adds value to counter, with an exemplar of the observation time when
+// exemplars are enabled and observed is known.
+func (p *Parser) addWithExemplar(counter prometheus.Counter, value float64, observed time.Time) {
+	adder, ok := counter.(prometheus.ExemplarAdder)
+	if !p.exemplars || observed.IsZero() || !ok {
+		counter.Add(value)
+		return
 	}
+	adder.AddWithExemplar(value, prometheus.Labels{"observed_at": observed.UTC().Format(time.RFC3339)})
+}
+
+// rejectTooLarge answers 413 Request Entity Too Large when err is caused by a report body
+// over the size limit, and reports whether it did.
+func (p *Parser) rejectTooLarge(resp http.ResponseWriter, remote_address string, err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	if !errors.As(err, &maxBytesErr) {
+		return false
+	}
+	slog.Warn("rejected report body over the size limit", "remote_address", remote_address, "limit", maxBytesErr.Limit)
+	p.reportsReceived.WithLabelValues(remote_address, "too_large").Inc()
+	http.Error(resp, "report body too large", http.StatusRequestEntityTooLarge)
+	return true
+}
+
+// SetMaxBodyBytes sets the size limit of a POSTed report body.
+func (p *Parser) SetMaxBodyBytes(limit int64) {
+	p.maxBodyBytes = limit
+}
+
+// SetReportPath sets the path ServeHTTP is mounted on, it is stripped from the
+// url before the report fields are parsed from it.
+func (p *Parser) SetReportPath(path string) {
+	p.reportPath = path
+}
+
+// SetAltitude sets the station altitude in meters, needed to reduce the absolute
+// pressure to sea level. An altitude of 0 leaves the sea-level pressure out.
+func (p *Parser) SetAltitude(altitudeM float64) {
+	p.altitudeMeters = altitudeM
+}
+
+// SetLuxPerWm2 sets the factor the solar radiation is multiplied by to approximate the
+// illuminance in lux.
+func (p *Parser) SetLuxPerWm2(factor float64) {
+	p.luxPerWm2 = factor
+}
+
+// SetLongitude sets the station longitude in degrees.
+func (p *Parser) SetLongitude(longitude float64) {
+	p.longitude = &longitude
+}
+
+// SetTimezone sets the timezone whose midnight starts a new day for the daily values.
+func (p *Parser) SetTimezone(timezone *time.Location) {
+	p.timezone = timezone
 }
 
-func (p *Parser) Parse(remote_adress string, values url.Values) {
+// SetExemplars attaches an exemplar with the observation time from dateutc to the
+// counters a report updates. Exemplars are only served in the OpenMetrics format, which
+// has no exemplars on gauges.
+func (p *Parser) SetExemplars(exemplars bool) {
+	p.exemplars = exemplars
+}
+
+// SetDecimalComma makes the parser accept a comma as the decimal separator, e.g. 1013,2.
+func (p *Parser) SetDecimalComma(decimalComma bool) {
+	p.decimalComma = decimalComma
+}
+
+// SetDuplicates sets which value is used of a field that is in a report more than once,
+// DuplicatesFirst or DuplicatesLast.
+func (p *Parser) SetDuplicates(duplicates string) {
+	p.duplicates = duplicates
+}
+
+// pick returns the value of a field that is used, values holds every occurrence of the field.
+func (p *Parser) pick(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	if p.duplicates == DuplicatesLast {
+		return values[len(values)-1]
+	}
+	return values[0]
+}
+
+// SetStationNames sets the names for the 'name' label by the PASSKEY, mac or stationtype
+// of the reporting station. Stations that aren't in names use the default name.
+// It is safe to call while reports are being parsed.
+func (p *Parser) SetStationNames(names map[string]string) {
+	p.stationNames.Store(&names)
+}
+
+// StationNames returns the names set by SetStationNames.
+func (p *Parser) StationNames() map[string]string {
+	if names := p.stationNames.Load(); names != nil {
+		return *names
+	}
+	return nil
+}
+
+// RemoteAddressLabel returns the name of the remote address label, see LabelOptions.LegacyNames.
+func (p *Parser) RemoteAddressLabel() string {
+	return p.remoteAddressLabel
+}
+
+// SetSensorNames sets the 'sensor' label of the multi-channel temperature and humidity
+// sensors by their channel, e.g. "3" to "greenhouse". Channels that aren't in names keep
+// their number. It is safe to call while reports are being parsed.
+func (p *Parser) SetSensorNames(names map[string]string) {
+	p.sensorNames.Store(&names)
+}
+
+// SensorNames returns the names set by SetSensorNames.
+func (p *Parser) SensorNames() map[string]string {
+	if names := p.sensorNames.Load(); names != nil {
+		return *names
+	}
+	return nil
+}
+
+// stationName returns the name for the 'name' label of the station that sent values.
+func (p *Parser) stationName(values url.Values) string {
+	stationNames := p.StationNames()
+	for _, key := range []string{"PASSKEY", "mac", "stationtype"} {
+		if !values.Has(key) {
+			continue
+		}
+		if name, ok := stationNames[p.pick(values[key])]; ok {
+			return name
+		}
+	}
+	return p.name
+}
+
+// Log logs at debug level, which is only shown with -verbose or -log-level debug.
+func (p *Parser) Log(msg string, args ...any) {
+	slog.Debug(msg, args...)
+}
+
+func (p *Parser) Parse(remote_address string, values url.Values) {
 	defer func() {
 		if r := recover(); r != nil {
-			log.Printf("Failed to parse incoming request: %+v", r)
+			slog.Error("failed to parse incoming request", "remote_address", remote_address, "error", r)
 		}
 	}()
 
-	parseString := func(name string) (string, error) {
-		array, ok := values[name]
+	name := p.stationName(values)
+	received := p.now()
+	p.lastReportMu.Lock()
+	p.lastReport[remote_address] = received
+	p.lastReportMu.Unlock()
+	defer p.notify(remote_address, name, received)
+	if p.csvLog != nil {
+		p.csvLog.Write(received, remote_address, name, values)
+	}
+	// set even when some fields fail to parse, the report itself was received
+	defer p.lastReportTimestamp.WithLabelValues(remote_address, name).Set(float64(received.UnixNano()) / 1e9)
+
+	parseString := func(field string) (string, error) {
+		array, ok := values[field]
 		if !ok {
-			return "", fmt.Errorf("no such param: %s", name)
+			return "", errNoSuchParam
 		}
-		str := strings.ReplaceAll(array[0], "\n", "")
-		str = strings.ReplaceAll(str, "\r", "")
-
-		return str, nil
+		return stripNewlines(p.pick(array)), nil
 	}
 
-	parseValue := func(name string) (float64, error) {
-		array, ok := values[name]
+	// several gauges are derived from the same fields, each field is parsed once so a bad
+	// value is logged and counted once per report
+	parsed := make(map[string]parsedValue, len(values))
+	parseValue := func(field string) (float64, error) {
+		if result, ok := parsed[field]; ok {
+			return result.value, result.err
+		}
+		array, ok := values[field]
 		if !ok {
-			return 0, fmt.Errorf("no such param: %s", name)
+			return 0, errNoSuchParam
 		}
-		first := strings.ReplaceAll(array[0], "\n", "")
-		first = strings.ReplaceAll(first, "\r", "")
-		value, err := strconv.ParseFloat(first, 64)
+		str := stripNewlines(p.pick(array))
+		if p.decimalComma {
+			str = commaToDot(str)
+		}
+		value, err := strconv.ParseFloat(str, 64)
 		if err != nil {
-			e := fmt.Errorf("failed to parse value: '%s': %+v", first, err)
-			log.Println(e)
-			return 0, e
+			slog.Warn("failed to parse value", "remote_address", remote_address, "name", name, "field", field, "value", str, "error", err)
+			p.parseErrors.WithLabelValues("value").Inc()
+			err = fmt.Errorf("failed to parse value: '%s': %+v", str, err)
+		}
+		parsed[field] = parsedValue{value: value, err: err}
+		return value, err
+	}
+
+	// observed stays zero without a valid dateutc, the counters then get no exemplar
+	var observed time.Time
+	if dateUTC, err := parseString("dateutc"); err == nil {
+		observed, err = parseDateUTC(dateUTC, received)
+		if err == nil {
+			p.observationTimestamp.WithLabelValues(remote_address, name).Set(float64(observed.UnixNano()) / 1e9)
+		} else {
+			slog.Warn("failed to parse dateutc", "remote_address", remote_address, "name", name, "value", dateUTC, "error", err)
+			p.parseErrors.WithLabelValues("value").Inc()
 		}
-		return value, nil
 	}
 
+	// fields that are missing from the report either keep their last value or, when
+	// deleteAbsent is set, are removed instead of showing up as zero
+	// the label values are copied by the gauges, so one slice serves every field
+	labels := make([]string, 0, 4)
+	updateField := func(f fieldGauge) {
+		labels = append(append(labels[:0], remote_address, name), f.labels...)
+		if f.deleteAbsent && !values.Has(f.field) {
+			f.gauge.DeleteLabelValues(labels...)
+			return
+		}
+		value, err := parseValue(f.field)
+		if f.convert != nil {
+			value, err = f.convert(value, err)
+		}
+		// looking the series up creates it, a field that was never reported has none
+		if err == nil {
+			f.gauge.WithLabelValues(labels...).Set(value)
+		}
+	}
+	for _, f := range p.fields {
+		updateField(f)
+	}
+
+	deleteBattery := func(sensor string) {
+		p.battery.DeleteLabelValues(remote_address, name, sensor)
+		p.batteryVoltage.DeleteLabelValues(remote_address, name, sensor)
+	}
+	// battery fields are either a status or a voltage, the voltage is kept separately
+	updateBattery := func(field string, sensor string) {
+		if !values.Has(field) {
+			deleteBattery(sensor)
+			return
+		}
+		battery, err := parseValue(field)
+		if err != nil {
+			return
+		}
+		ok, voltage := p.normalizeBattery(battery)
+		p.battery.WithLabelValues(remote_address, name, sensor).Set(ok)
+		if voltage {
+			p.batteryVoltage.WithLabelValues(remote_address, name, sensor).Set(battery)
+		} else {
+			p.batteryVoltage.DeleteLabelValues(remote_address, name, sensor)
+		}
+	}
+
+	sensorNames := p.SensorNames()
 	for i := 1; i <= 10; i++ {
 		iStr := strconv.Itoa(i)
-		if values.Has(fmt.Sprintf("temp%df", i)) {
-			updateGauge(p.temperature.WithLabelValues(remote_adress,p.name, iStr))(parseValue(fmt.Sprintf("temp%df", i)))
-			updateGauge(p.battery.WithLabelValues(remote_adress,p.name, iStr))(parseValue("batt" + iStr))
+		sensor := iStr
+		if sensorName, ok := sensorNames[iStr]; ok {
+			sensor = sensorName
+		}
+		if values.Has("temp" + iStr + "f") {
+			updateGauge(p.temperature.WithLabelValues(remote_address, name, sensor))(p.convertTemperature(parseValue("temp" + iStr + "f")))
+			updateBattery("batt"+iStr, sensor)
 		} else {
-			p.battery.DeleteLabelValues(p.name, iStr)
-			p.temperature.DeleteLabelValues(p.name, iStr)
+			deleteBattery(sensor)
+			p.temperature.DeleteLabelValues(remote_address, name, sensor)
 		}
 		if values.Has("soilhum" + iStr) {
-			updateGauge(p.humidity.WithLabelValues(remote_adress,p.name, "soil"+iStr))(parseValue("soilhum" + iStr))
-			updateGauge(p.battery.WithLabelValues(remote_adress,p.name, "soil"+iStr))(parseValue("battsm" + iStr))
+			updateGauge(p.humidity.WithLabelValues(remote_address, name, "soil"+iStr))(parseValue("soilhum" + iStr))
 		} else {
-			p.humidity.DeleteLabelValues(p.name, "soil"+iStr)
-			p.battery.DeleteLabelValues(p.name, "soil"+iStr)
+			p.humidity.DeleteLabelValues(remote_address, name, "soil"+iStr)
+		}
+		if values.Has("soiltemp" + iStr + "f") {
+			updateGauge(p.temperature.WithLabelValues(remote_address, name, "soil"+iStr))(p.convertTemperature(parseValue("soiltemp" + iStr + "f")))
+		} else {
+			p.temperature.DeleteLabelValues(remote_address, name, "soil"+iStr)
+		}
+		// soil humidity and soil temperature probes share the battsm battery field
+		if values.Has("soilhum"+iStr) || values.Has("soiltemp"+iStr+"f") {
+			updateBattery("battsm"+iStr, "soil"+iStr)
+		} else {
+			deleteBattery("soil" + iStr)
+		}
+		if values.Has("leafwetness" + iStr) {
+			updateGauge(p.leafWetness.WithLabelValues(remote_address, name, iStr))(parseValue("leafwetness" + iStr))
+			updateBattery("battleaf"+iStr, "leaf"+iStr)
+		} else {
+			p.leafWetness.DeleteLabelValues(remote_address, name, iStr)
+			deleteBattery("leaf" + iStr)
 		}
 		if values.Has("humidity" + iStr) {
-			updateGauge(p.humidity.WithLabelValues(remote_adress,p.name, iStr))(parseValue("humidity" + iStr))
+			updateGauge(p.humidity.WithLabelValues(remote_address, name, sensor))(parseValue("humidity" + iStr))
 		} else {
-			p.humidity.DeleteLabelValues(p.name, iStr)
+			p.humidity.DeleteLabelValues(remote_address, name, sensor)
 		}
 	}
 
-	updateGauge(p.temperature.WithLabelValues(remote_adress,p.name, "indoor"))(parseValue("tempinf"))
-	tempF, err := parseValue("tempf")
-	if err == nil {
-		p.temperature.WithLabelValues(remote_adress,p.name, "outdoor").Set(tempF)
+	for i := 1; i <= 4; i++ {
+		iStr := strconv.Itoa(i)
+		if values.Has("leak" + iStr) {
+			updateGauge(p.leak.WithLabelValues(remote_address, name, iStr))(parseValue("leak" + iStr))
+			updateBattery("batleak"+iStr, "leak"+iStr)
+		} else {
+			p.leak.DeleteLabelValues(remote_address, name, iStr)
+			deleteBattery("leak" + iStr)
+		}
+	}
+
+	tempF, tempF_err := parseValue("tempf")
+	if tempF_err == nil {
+		updateGauge(p.temperature.WithLabelValues(remote_address, name, "outdoor"))(p.convertTemperature(tempF, nil))
 		feelsLike := tempF
 		windSpeedMph, err := parseValue("windspeedmph")
 		if err == nil {
-			p.windSpeedMph.WithLabelValues(remote_adress,p.name, "sustained").Set(windSpeedMph)
-			if tempF <= 40 {
-				feelsLike = calculateWindChill(tempF, windSpeedMph)
-			}
+			updateGauge(p.windSpeedMph.WithLabelValues(remote_address, name, "sustained"))(p.convertSpeed(windSpeedMph, nil))
+			feelsLike = calculateWindChill(tempF, windSpeedMph)
 		}
+		// without an anemometer there is no wind to chill, windSpeedMph is 0
+		windChill := calculateWindChill(tempF, windSpeedMph)
+		updateGauge(p.temperature.WithLabelValues(remote_address, name, "windchill"))(p.convertTemperature(windChill, nil))
+		p.temperatureCelsius.WithLabelValues(remote_address, name, "windchill").Set(fahrenheitToCelsius(windChill))
 		humidity, err := parseValue("humidity")
 		if err == nil {
-			p.humidity.WithLabelValues(remote_adress,p.name, "outdoor").Set(humidity)
-			p.temperature.WithLabelValues(remote_adress,p.name, "dewpoint").Set(calculateDewPoint(tempF, humidity))
+			p.humidity.WithLabelValues(remote_address, name, "outdoor").Set(humidity)
+			dewPoint := calculateDewPoint(tempF, humidity)
+			updateGauge(p.temperature.WithLabelValues(remote_address, name, "dewpoint"))(p.convertTemperature(dewPoint, nil))
+			p.temperatureCelsius.WithLabelValues(remote_address, name, "dewpoint").Set(fahrenheitToCelsius(dewPoint))
+			p.cloudBase.WithLabelValues(remote_address, name).Set(calculateCloudBase(tempF, dewPoint))
+			// the humidex is defined in celsius only
+			humidex := calculateHumidex(tempF, dewPoint)
+			p.temperatureCelsius.WithLabelValues(remote_address, name, "humidex").Set(humidex)
+			if humidex >= humidexDanger {
+				p.humidexDanger.WithLabelValues(remote_address, name).Set(1)
+			} else {
+				p.humidexDanger.WithLabelValues(remote_address, name).Set(0)
+			}
+			// below freezing water vapor condenses as frost, at the frost point
+			if dewPoint < 32 {
+				frostPoint := calculateFrostPoint(tempF, humidity)
+				updateGauge(p.temperature.WithLabelValues(remote_address, name, "frostpoint"))(p.convertTemperature(frostPoint, nil))
+				p.temperatureCelsius.WithLabelValues(remote_address, name, "frostpoint").Set(fahrenheitToCelsius(frostPoint))
+			} else {
+				p.temperature.DeleteLabelValues(remote_address, name, "frostpoint")
+				p.temperatureCelsius.DeleteLabelValues(remote_address, name, "frostpoint")
+			}
+			wetBulb := calculateWetBulb(tempF, humidity)
+			updateGauge(p.temperature.WithLabelValues(remote_address, name, "wetbulb"))(p.convertTemperature(wetBulb, nil))
+			p.temperatureCelsius.WithLabelValues(remote_address, name, "wetbulb").Set(fahrenheitToCelsius(wetBulb))
+			// windSpeedMph is 0 when the station has no anemometer
+			solarRadiation, solarErr := parseValue("solarradiation")
+			apparentTemp := calculateApparentTemperature(tempF, humidity, windSpeedMph, solarRadiation, solarErr == nil)
+			updateGauge(p.temperature.WithLabelValues(remote_address, name, "apparentTemp"))(p.convertTemperature(apparentTemp, nil))
+			p.temperatureCelsius.WithLabelValues(remote_address, name, "apparentTemp").Set(fahrenheitToCelsius(apparentTemp))
+			heatIndex := calculateHeatIndex(tempF, humidity)
+			updateGauge(p.temperature.WithLabelValues(remote_address, name, "heatindex"))(p.convertTemperature(heatIndex, nil))
+			p.temperatureCelsius.WithLabelValues(remote_address, name, "heatindex").Set(fahrenheitToCelsius(heatIndex))
 			if tempF >= 80 {
-				feelsLike = calculateHeatIndex(tempF, humidity)
+				feelsLike = heatIndex
 			}
+		} else {
+			p.temperature.DeleteLabelValues(remote_address, name, "heatindex")
+			p.temperatureCelsius.DeleteLabelValues(remote_address, name, "heatindex")
+			p.cloudBase.DeleteLabelValues(remote_address, name)
+		}
+		updateGauge(p.temperature.WithLabelValues(remote_address, name, "feelsLike"))(p.convertTemperature(feelsLike, nil))
+		p.temperatureCelsius.WithLabelValues(remote_address, name, "feelsLike").Set(fahrenheitToCelsius(feelsLike))
+	} else {
+		// the cloud base needs both the temperature and the dewpoint
+		p.cloudBase.DeleteLabelValues(remote_address, name)
+	}
+
+	// moisture content and drying power of the air, from each pair of temperature and relative humidity
+	for sensor, fields := range humidityFields {
+		temp, tempErr := parseValue(fields[0])
+		rh, rhErr := parseValue(fields[1])
+		if tempErr != nil || rhErr != nil {
+			p.absoluteHumidity.DeleteLabelValues(remote_address, name, sensor)
+			p.vaporPressureDeficit.DeleteLabelValues(remote_address, name, sensor)
+			continue
+		}
+		p.absoluteHumidity.WithLabelValues(remote_address, name, sensor).Set(calculateAbsoluteHumidity(temp, rh))
+		p.vaporPressureDeficit.WithLabelValues(remote_address, name, sensor).Set(calculateVPD(temp, rh))
+	}
+
+	// the density of the air at the station, so from the absolute pressure
+	baromAbsIn, baromErr := parseValue("baromabsin")
+	humidity, humidityErr := parseValue("humidity")
+	if tempF_err == nil && baromErr == nil && humidityErr == nil {
+		p.airDensity.WithLabelValues(remote_address, name).Set(calculateAirDensity(tempF, baromAbsIn, humidity))
+	} else {
+		p.airDensity.DeleteLabelValues(remote_address, name)
+	}
+
+	if tempF_err == nil {
+		today, finished, rolledOver := p.updateGDD(remote_address, received, tempF)
+		if rolledOver {
+			p.addWithExemplar(p.growingDegreeDaysSum.WithLabelValues(remote_address, name), p.convertDegreeDays(finished), observed)
+		}
+		p.growingDegreeDays.WithLabelValues(remote_address, name).Set(p.convertDegreeDays(today))
+	} else {
+		p.growingDegreeDays.DeleteLabelValues(remote_address, name)
+	}
+
+	// reference evapotranspiration, accumulated over the day
+	if tempF_err == nil {
+		solarRadiation, solarErr := parseValue("solarradiation")
+		windSpeedMph, windErr := parseValue("windspeedmph")
+		pressureKPa := 101.3
+		if baromErr == nil {
+			pressureKPa = inHgToHPa(baromAbsIn) / 10
 		}
-		p.temperature.WithLabelValues(remote_adress,p.name, "feelsLike").Set(feelsLike)
-	}
-
-	updateGauge(p.battery.WithLabelValues(remote_adress,p.name, "outdoor"))(parseValue("battout"))
-	updateGauge(p.battery.WithLabelValues(remote_adress,p.name, "indoor"))(parseValue("battin"))
-	updateGauge(p.battery.WithLabelValues(remote_adress,p.name, "lightning"))(parseValue("batt_lightning"))
-	updateGauge(p.humidity.WithLabelValues(remote_adress,p.name, "indoor"))(parseValue("humidityin"))
-	updateGauge(p.barometer.WithLabelValues(remote_adress,p.name, "relative"))(parseValue("baromrelin"))
-	updateGauge(p.barometer.WithLabelValues(remote_adress,p.name, "absolute"))(parseValue("baromabsin"))
-	updateGauge(p.windDir.WithLabelValues(remote_adress,p.name, "current"))(parseValue("winddir"))
-	updateGauge(p.windDir.WithLabelValues(remote_adress,p.name, "avg10m"))(parseValue("winddir_avg10m"))
-	updateGauge(p.windSpeedMph.WithLabelValues(remote_adress,p.name, "gusts"))(parseValue("windgustmph"))
-	updateGauge(p.solarRadiation.WithLabelValues(remote_adress,p.name))(parseValue("solarradiation"))
-	updateGauge(p.rainIn.WithLabelValues(remote_adress,p.name, "hourly"))(parseValue("hourlyrainin"))
-	updateGauge(p.rainIn.WithLabelValues(remote_adress,p.name, "daily"))(parseValue("dailyrainin"))
-	updateGauge(p.rainIn.WithLabelValues(remote_adress,p.name, "weekly"))(parseValue("weeklyrainin"))
-	updateGauge(p.rainIn.WithLabelValues(remote_adress,p.name, "monthly"))(parseValue("monthlyrainin"))
-	updateGauge(p.rainIn.WithLabelValues(remote_adress,p.name, "yearly"))(parseValue("yearlyrainin"))
-	updateGauge(p.rainIn.WithLabelValues(remote_adress,p.name, "total"))(parseValue("totalrainin"))
-	updateGauge(p.rainIn.WithLabelValues(remote_adress,p.name, "event"))(parseValue("eventrainin"))
-	updateGauge(p.ultraviolet.WithLabelValues(remote_adress,p.name))(parseValue("uv"))
-	updateGauge(p.lightning_strikes.WithLabelValues(remote_adress,p.name, "day"))(parseValue("lightning_day"))
-	updateGauge(p.lightning_distance.WithLabelValues(remote_adress,p.name))(parseValue("lightning_distance"))
-	updateGauge(p.lightning_last_strike.WithLabelValues(remote_adress,p.name))(parseValue("lightning_time"))
+		et0, ok := p.accumulateET0(remote_address, received, et0Inputs{
+			tempF:          tempF,
+			humidity:       humidity,
+			windSpeedMph:   windSpeedMph,
+			solarRadiation: solarRadiation,
+			pressureKPa:    pressureKPa,
+			hasHumidity:    humidityErr == nil,
+			hasWind:        windErr == nil,
+			hasSolar:       solarErr == nil,
+		})
+		if ok {
+			p.evapotranspiration.WithLabelValues(remote_address, name).Set(et0)
+		} else {
+			p.evapotranspiration.DeleteLabelValues(remote_address, name)
+		}
+	} else {
+		p.evapotranspiration.DeleteLabelValues(remote_address, name)
+	}
+
+	updateBattery("battout", "outdoor")
+	updateBattery("battin", "indoor")
+	updateBattery("batt_lightning", "lightning")
+	if baromRelIn, err := parseValue("baromrelin"); err == nil {
+		p.barometerHPa.WithLabelValues(remote_address, name, "relative").Set(inHgToHPa(baromRelIn))
+		p.updatePressureTrend(remote_address, name, received, baromRelIn)
+	}
+	if baromErr == nil {
+		p.barometerHPa.WithLabelValues(remote_address, name, "absolute").Set(inHgToHPa(baromAbsIn))
+	}
+	// sea-level pressure needs the station altitude, leave it out when it isn't configured
+	if baromErr == nil && p.altitudeMeters != 0 && tempF_err == nil {
+		seaLevel := calculateSeaLevelPressure(baromAbsIn, p.altitudeMeters, tempF)
+		updateGauge(p.barometer.WithLabelValues(remote_address, name, "sealevel"))(p.convertPressure(seaLevel, nil))
+		p.barometerHPa.WithLabelValues(remote_address, name, "sealevel").Set(inHgToHPa(seaLevel))
+	} else {
+		p.barometer.DeleteLabelValues(remote_address, name, "sealevel")
+		p.barometerHPa.DeleteLabelValues(remote_address, name, "sealevel")
+	}
+	for field, speedType := range windSpeedFields {
+		if mph, err := parseValue(field); err == nil {
+			p.windSpeedMs.WithLabelValues(remote_address, name, speedType).Set(mphToMs(mph))
+			p.windSpeedKmh.WithLabelValues(remote_address, name, speedType).Set(mphToKmh(mph))
+		} else {
+			p.windSpeedMs.DeleteLabelValues(remote_address, name, speedType)
+			p.windSpeedKmh.DeleteLabelValues(remote_address, name, speedType)
+		}
+	}
+	if solarRadiation, err := parseValue("solarradiation"); err == nil {
+		p.solarLux.WithLabelValues(remote_address, name).Set(solarRadiation * p.luxPerWm2)
+	} else {
+		p.solarLux.DeleteLabelValues(remote_address, name)
+	}
+	for field, period := range cumulativeRainFields {
+		if rain, err := parseValue(field); err == nil && p.rainDecreased(remote_address, period, rain) {
+			p.addWithExemplar(p.rainResets.WithLabelValues(remote_address, name, period), 1, observed)
+		}
+	}
+	// not all firmware sends rainratein, the hourly rain is the rate over the last hour
+	if values.Has("rainratein") {
+		updateGauge(p.rainRate.WithLabelValues(remote_address, name))(p.convertRain(parseValue("rainratein")))
+	} else {
+		updateField(fieldGauge{field: "hourlyrainin", gauge: p.rainRate, convert: p.convertRain, deleteAbsent: true})
+	}
+	// faulty sensors sometimes report absurd values, keep the last sane reading instead
+	if uv, err := parseValue("uv"); err == nil {
+		if uv < 0 || uv > maxUV {
+			p.Log("rejected out of range uv index", "remote_address", remote_address, "name", name, "value", uv)
+			p.parseErrors.WithLabelValues("range").Inc()
+		} else {
+			p.ultraviolet.WithLabelValues(remote_address, name).Set(uv)
+		}
+	}
+	if p.lightningWebhook != nil {
+		distance, distanceErr := parseValue("lightning_distance")
+		strikes, strikesErr := parseValue("lightning_day")
+		if alert, ok := p.checkLightning(remote_address, name, received, distance, distanceErr == nil, strikes, strikesErr == nil); ok {
+			p.lightningWebhook.send(alert)
+		}
+	}
+	if pm25, err := parseValue("pm25"); err == nil {
+		p.airQualityIndex.WithLabelValues(remote_address, name).Set(calculateAQIPM25(pm25))
+	} else {
+		p.airQualityIndex.DeleteLabelValues(remote_address, name)
+	}
+
+	// the mac identifies the station whatever address it reports from. It is left empty
+	// without a mac field, the PASSKEY is a credential even when it holds the mac
+	mac, _ := parseString("mac")
+	if mac != "" || p.latitude != nil || p.longitude != nil || p.altitudeMeters != 0 {
+		p.stationInfo.WithLabelValues(remote_address, name, mac, formatCoordinate(p.latitude), formatCoordinate(p.longitude), strconv.FormatFloat(p.altitudeMeters, 'f', -1, 64)).Set(1)
+	}
+
+	if p.latitude != nil && p.longitude != nil {
+		sunrise, sunset, up, ok := calculateSunriseSunset(received.In(p.timezone), *p.latitude, *p.longitude)
+		if ok {
+			p.sunrise.WithLabelValues(remote_address, name).Set(float64(sunrise.Unix()))
+			p.sunset.WithLabelValues(remote_address, name).Set(float64(sunset.Unix()))
+			up = !received.Before(sunrise) && received.Before(sunset)
+		} else {
+			// the sun doesn't rise or set today
+			p.sunrise.DeleteLabelValues(remote_address, name)
+			p.sunset.DeleteLabelValues(remote_address, name)
+		}
+		if up {
+			p.daylight.WithLabelValues(remote_address, name).Set(1)
+		} else {
+			p.daylight.WithLabelValues(remote_address, name).Set(0)
+		}
+	}
+
+	moonPhase, moonIllumination := calculateMoonPhase(received)
+	p.moonPhase.WithLabelValues(remote_address, name).Set(moonPhase)
+	p.moonIllumination.WithLabelValues(remote_address, name).Set(moonIllumination)
 
 	stationType, station_err := parseString("stationtype")
-	if err == station_err {
-		updateGauge(p.stationtype.WithLabelValues(remote_adress,p.name, stationType))(float64(1), nil)
+	if station_err == nil {
+		updateGauge(p.stationtype.WithLabelValues(remote_address, name, stationType))(float64(1), nil)
+	}
+
+	p.updateThresholds(remote_address, name, parseValue)
+}
+
+// parsedValue is the result of parsing a field of a report.
+type parsedValue struct {
+	value float64
+	err   error
+}
+
+// rainDecreased remembers the cumulative rain of a station and reports whether it
+// is lower than the previous report, i.e. the console reset it.
+func (p *Parser) rainDecreased(remote_address string, period string, rain float64) bool {
+	p.rainTotalsMu.Lock()
+	defer p.rainTotalsMu.Unlock()
+	totals, ok := p.rainTotals[remote_address]
+	if !ok {
+		totals = make(map[string]float64)
+		p.rainTotals[remote_address] = totals
 	}
+	previous, seen := totals[period]
+	totals[period] = rain
+	return seen && rain < previous
+}
+
+// formatCoordinate formats a latitude or longitude for a label, empty when it isn't set.
+func formatCoordinate(degrees *float64) string {
+	if degrees == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*degrees, 'f', -1, 64)
+}
+
+// stripNewlines removes line breaks from a field value, without allocating when there are none.
+func stripNewlines(value string) string {
+	if !strings.ContainsAny(value, "\r\n") {
+		return value
+	}
+	value = strings.ReplaceAll(value, "\n", "")
+	return strings.ReplaceAll(value, "\r", "")
+}
+
+// commaToDot replaces the decimal comma of a number like 1013,2 by a dot. Values with
+// more than one comma or with a dot are left alone, the comma may separate thousands there.
+func commaToDot(value string) string {
+	if strings.Count(value, ",") != 1 || strings.Contains(value, ".") {
+		return value
+	}
+	return strings.Replace(value, ",", ".", 1)
+}
+
+// parseDateUTC parses the dateutc field, e.g. 2024-01-02+03:04:05 or 2024-01-02 03:04:05.
+// Some stations send "now", which is taken as the time the report was received.
+func parseDateUTC(value string, received time.Time) (time.Time, error) {
+	if strings.EqualFold(value, "now") {
+		return received, nil
+	}
+	return time.Parse("2006-01-02 15:04:05", strings.Replace(value, "+", " ", 1))
 }
 
 func updateGauge(gauge prometheus.Gauge) func(float64, error) {
@@ -207,8 +1012,86 @@ func updateGauge(gauge prometheus.Gauge) func(float64, error) {
 	}
 }
 
+// convertTemperature converts a fahrenheit reading to the configured units.
+func (p *Parser) convertTemperature(tempF float64, err error) (float64, error) {
+	if p.units == UnitsMetric {
+		return fahrenheitToCelsius(tempF), err
+	}
+	return tempF, err
+}
+
+// convertPressure converts an inHg reading to the configured units.
+func (p *Parser) convertPressure(inHg float64, err error) (float64, error) {
+	if p.units == UnitsMetric {
+		return inHgToHPa(inHg), err
+	}
+	return inHg, err
+}
+
+// rainMetricNames returns the names of the rain and rain rate gauges in units.
+func rainMetricNames(units string) (rain string, rainRate string) {
+	if units == UnitsMetric {
+		return "rain_mm", "rain_rate_mm_per_hr"
+	}
+	return "rain_in", "rain_rate_in_per_hr"
+}
+
+// windSpeedMetricName returns the name of the wind speed gauge in units. With metric units
+// it is wind_speed_kmh, which imperial units derive from the mph values instead.
+func windSpeedMetricName(units string) string {
+	if units == UnitsMetric {
+		return "wind_speed_kmh"
+	}
+	return "wind_speed_mph"
+}
+
+// convertSpeed converts a mph reading to the configured units.
+func (p *Parser) convertSpeed(mph float64, err error) (float64, error) {
+	if p.units == UnitsMetric {
+		return mphToKmh(mph), err
+	}
+	return mph, err
+}
+
+// convertRain converts an inches reading to the configured units.
+func (p *Parser) convertRain(in float64, err error) (float64, error) {
+	if p.units == UnitsMetric {
+		return inchesToMm(in), err
+	}
+	return in, err
+}
+
+func fahrenheitToCelsius(tempF float64) float64 {
+	return (tempF - 32) * 5 / 9
+}
+
+func inHgToHPa(inHg float64) float64 {
+	return inHg * 33.8639
+}
+
+func mphToKmh(mph float64) float64 {
+	return mph * 1.609344
+}
+
+func mphToMs(mph float64) float64 {
+	return mph * 0.44704
+}
+
+func inchesToMm(in float64) float64 {
+	return in * 25.4
+}
+
+// calculateSeaLevelPressure reduces the absolute pressure measured at altitudeM to sea level
+// with the barometric formula of the standard atmosphere, using the current temperature.
+func calculateSeaLevelPressure(absInHg float64, altitudeM float64, tempF float64) float64 {
+	lapse := 0.0065 * altitudeM
+	return absInHg * math.Pow(1-lapse/(fahrenheitToCelsius(tempF)+lapse+273.15), -5.257)
+}
+
+// calculateWindChill follows the NWS wind chill formula, which only applies at or
+// below 50°F with at least 3 mph of wind. Otherwise it returns the temperature.
 func calculateWindChill(tempF float64, windSpeedMph float64) float64 {
-	if tempF > 40 || windSpeedMph < 5 {
+	if tempF > 50 || windSpeedMph < 3 {
 		return tempF
 	}
 	windExp := math.Pow(windSpeedMph, 0.16)
@@ -241,6 +1124,33 @@ func calculateHeatIndex(tempF float64, rh float64) float64 {
 	return hi
 }
 
+// US EPA PM2.5 breakpoints: concentration low/high in µg/m3 and the matching AQI low/high
+var aqiPM25Breakpoints = [][4]float64{
+	{0.0, 12.0, 0, 50},
+	{12.1, 35.4, 51, 100},
+	{35.5, 55.4, 101, 150},
+	{55.5, 150.4, 151, 200},
+	{150.5, 250.4, 201, 300},
+	{250.5, 350.4, 301, 400},
+	{350.5, 500.4, 401, 500},
+}
+
+// following the piecewise linear equation from
+// https://www.airnow.gov/sites/default/files/2020-05/aqi-technical-assistance-document-sept2018.pdf
+func calculateAQIPM25(concentration float64) float64 {
+	// the EPA truncates PM2.5 to one decimal before looking up the breakpoint
+	c := math.Floor(concentration*10) / 10
+	if c <= 0 {
+		return 0
+	}
+	for _, bp := range aqiPM25Breakpoints {
+		if c <= bp[1] {
+			return math.Round((bp[3]-bp[2])/(bp[1]-bp[0])*(c-bp[0]) + bp[2])
+		}
+	}
+	return 500
+}
+
 func calculateDewPoint(tempF float64, rh float64) float64 {
 	a := 17.625
 	b := 243.04
@@ -248,3 +1158,98 @@ func calculateDewPoint(tempF float64, rh float64) float64 {
 	alpha := math.Log(rh/100) + ((a * t) / (b + t))
 	return (b * alpha / (a - alpha) * 9 / 5) + 32
 }
+
+// calculateWetBulb approximates the wet-bulb temperature with the empirical formula from
+// Stull, "Wet-Bulb Temperature from Relative Humidity and Air Temperature" (2011), which
+// holds for 5-99% relative humidity and -20°C to 50°C.
+func calculateWetBulb(tempF float64, rh float64) float64 {
+	t := fahrenheitToCelsius(tempF)
+	tw := t*math.Atan(0.151977*math.Sqrt(rh+8.313659)) +
+		math.Atan(t+rh) -
+		math.Atan(rh-1.676331) +
+		0.00391838*math.Pow(rh, 1.5)*math.Atan(0.023101*rh) -
+		4.686035
+	return tw*9/5 + 32
+}
+
+// calculateAbsoluteHumidity returns the water vapor in the air in g/m3, from the
+// saturation vapor pressure of the Magnus formula and the ideal gas law.
+func calculateAbsoluteHumidity(tempF float64, rh float64) float64 {
+	t := fahrenheitToCelsius(tempF)
+	return saturationVaporPressure(t) * 10 * rh * 2.1674 / (273.15 + t)
+}
+
+// calculateVPD returns the vapor pressure deficit in kPa, how much more water vapor
+// the air could hold before it is saturated.
+func calculateVPD(tempF float64, rh float64) float64 {
+	return saturationVaporPressure(fahrenheitToCelsius(tempF)) * (1 - rh/100)
+}
+
+// saturationVaporPressure returns the saturation vapor pressure over water in kPa at
+// tempC, following the Magnus formula.
+func saturationVaporPressure(tempC float64) float64 {
+	return 0.6112 * math.Exp(17.67*tempC/(tempC+243.5))
+}
+
+// calculateCloudBase estimates the height of cumulus cloud bases in feet, the air cools
+// about 4.4°F per 1000 feet faster than its dewpoint when it rises.
+func calculateCloudBase(tempF float64, dewpointF float64) float64 {
+	spread := tempF - dewpointF
+	if spread < 0 {
+		return 0
+	}
+	return spread / 4.4 * 1000
+}
+
+// calculateAirDensity returns the density of humid air in kg/m3 as the sum of the
+// densities of the dry air and the water vapor, each following the ideal gas law.
+func calculateAirDensity(tempF float64, baromInHg float64, rh float64) float64 {
+	t := fahrenheitToCelsius(tempF)
+	kelvin := t + 273.15
+	// partial pressures in Pa
+	vapor := saturationVaporPressure(t) * 1000 * rh / 100
+	dry := inHgToHPa(baromInHg)*100 - vapor
+	return dry/(287.058*kelvin) + vapor/(461.495*kelvin)
+}
+
+// calculateApparentTemperature returns the apparent temperature used by the Australian
+// Bureau of Meteorology, following Steadman, "Norms of apparent temperature in Australia"
+// (1994), see http://www.bom.gov.au/info/thermal_stress/. With sun it includes the solar
+// radiation, like the THSW index of Davis consoles.
+func calculateApparentTemperature(tempF float64, rh float64, windSpeedMph float64, solarRadiation float64, sun bool) float64 {
+	t := fahrenheitToCelsius(tempF)
+	// water vapor pressure in hPa
+	e := saturationVaporPressure(t) * 10 * rh / 100
+	ws := mphToMs(windSpeedMph)
+	var at float64
+	if sun {
+		// Steadman uses the net radiation absorbed per m2 of body surface, which is
+		// estimated as a tenth of the global radiation the station measures
+		q := solarRadiation * 0.1
+		at = t + 0.348*e - 0.70*ws + 0.70*q/(ws+10) - 4.25
+	} else {
+		at = t + 0.33*e - 0.70*ws - 4.00
+	}
+	return at*9/5 + 32
+}
+
+// calculateFrostPoint returns the temperature at which the air is saturated over ice,
+// using the Magnus formula with the coefficients over ice (a = 22.46, b = 272.62).
+func calculateFrostPoint(tempF float64, rh float64) float64 {
+	// water vapor pressure in hPa
+	e := saturationVaporPressure(fahrenheitToCelsius(tempF)) * 10 * rh / 100
+	alpha := math.Log(e / 6.112)
+	return (272.62 * alpha / (22.46 - alpha) * 9 / 5) + 32
+}
+
+// humidexDanger is the humidex from which Environment Canada considers the heat dangerous.
+const humidexDanger = 45
+
+// calculateHumidex returns the Canadian humidex in °C, following the Environment Canada
+// formula from the temperature and the dewpoint.
+func calculateHumidex(tempF float64, dewpointF float64) float64 {
+	dewpointK := fahrenheitToCelsius(dewpointF) + 273.15
+	// water vapor pressure in hPa
+	e := 6.11 * math.Exp(5417.7530*(1/273.16-1/dewpointK))
+	return fahrenheitToCelsius(tempF) + 0.5555*(e-10)
+}
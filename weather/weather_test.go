@@ -0,0 +1,956 @@
+package weather
+
+import (
+	"bytes"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/expfmt"
+)
+
+// newTestParser returns a Parser whose metrics are registered with a registry of its own.
+func newTestParser(t *testing.T, units string, labelOptions LabelOptions) (*Parser, *prometheus.Registry) {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	factory := promauto.With(registry)
+	return NewParser("", "", units, labelOptions, &factory), registry
+}
+
+// gaugeValue returns the value of the series of gauge with the label values.
+func gaugeValue(gauge *stationGaugeVec, labelValues ...string) float64 {
+	return testutil.ToFloat64(gauge.WithLabelValues(labelValues...))
+}
+
+// hasSeries reports whether gauge has the series with the label values.
+func hasSeries(gauge *stationGaugeVec, labelValues ...string) bool {
+	if gauge.dropRemote {
+		labelValues = labelValues[1:]
+	}
+	before := testutil.CollectAndCount(gauge.GaugeVec)
+	gauge.GaugeVec.WithLabelValues(labelValues...)
+	if testutil.CollectAndCount(gauge.GaugeVec) == before {
+		return true
+	}
+	// looking it up created the series
+	gauge.GaugeVec.DeleteLabelValues(labelValues...)
+	return false
+}
+
+// gatherText returns the metrics of registry in the text exposition format.
+func gatherText(t *testing.T, registry *prometheus.Registry) string {
+	t.Helper()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text strings.Builder
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(&text, family); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return text.String()
+}
+
+// observerFunc adapts a function to an Observer.
+type observerFunc func(Observation)
+
+func (f observerFunc) Observe(observation Observation) {
+	f(observation)
+}
+
+func approxEqual(got float64, want float64, tolerance float64) bool {
+	return math.Abs(got-want) <= tolerance
+}
+
+// waitFor polls condition until it holds or a second passed.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCalculateAQIPM25(t *testing.T) {
+	tests := []struct {
+		concentration float64
+		want          float64
+	}{
+		{-1, 0},
+		{0, 0},
+		{0.05, 0},
+		{12.0, 50},
+		{12.1, 51},
+		{35.4, 100},
+		{35.5, 101},
+		{55.4, 150},
+		{55.5, 151},
+		{150.4, 200},
+		{150.5, 201},
+		{250.4, 300},
+		{250.5, 301},
+		{350.4, 400},
+		{350.5, 401},
+		{500.4, 500},
+		{500.5, 500},
+		{1000, 500},
+		// truncated to 35.4 before the lookup
+		{35.49, 100},
+	}
+	for _, test := range tests {
+		if got := calculateAQIPM25(test.concentration); got != test.want {
+			t.Errorf("calculateAQIPM25(%v) = %v, want %v", test.concentration, got, test.want)
+		}
+	}
+}
+
+func TestParseBarometerHPa(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	remote := "192.168.1.5"
+	// the standard atmosphere
+	parser.Parse(remote, url.Values{"baromrelin": {"29.9213"}, "baromabsin": {"29.9213"}})
+	for _, typ := range []string{"relative", "absolute"} {
+		if got := gaugeValue(parser.barometerHPa, remote, "", typ); !approxEqual(got, 1013.25, 0.01) {
+			t.Errorf("%s barometer_hpa = %v, want 1013.25", typ, got)
+		}
+		if got := gaugeValue(parser.barometer, remote, "", typ); got != 29.9213 {
+			t.Errorf("%s barometer = %v, want 29.9213", typ, got)
+		}
+	}
+}
+
+func TestParseDeletesAbsentSensor(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	remote := "192.168.1.5"
+	parser.Parse(remote, url.Values{"tempf": {"71.2"}, "temp2f": {"65.3"}, "humidity2": {"50"}, "batt2": {"1"}})
+	for _, gauge := range []*stationGaugeVec{parser.temperature, parser.humidity, parser.battery} {
+		if !hasSeries(gauge, remote, "", "2") {
+			t.Fatalf("the report with temp2f has no sensor 2 series")
+		}
+	}
+	parser.Parse(remote, url.Values{"tempf": {"71.2"}})
+	for _, gauge := range []*stationGaugeVec{parser.temperature, parser.humidity, parser.battery} {
+		if hasSeries(gauge, remote, "", "2") {
+			t.Errorf("sensor 2 is still exported after a report without temp2f")
+		}
+	}
+	if !hasSeries(parser.temperature, remote, "", "outdoor") {
+		t.Errorf("the outdoor temperature was deleted")
+	}
+}
+
+func TestParseStationTypeWithoutTemperature(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	parser.Parse("192.168.1.5", url.Values{"stationtype": {"AMBWeatherPro_V5.0.6"}, "humidity": {"40"}})
+	if got := gaugeValue(parser.stationtype, "192.168.1.5", "", "AMBWeatherPro_V5.0.6"); got != 1 {
+		t.Errorf("stationtype_info = %v without tempf, want 1", got)
+	}
+}
+
+// captureLogs sends the logs of every level to the returned buffer until the test ends.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var logs bytes.Buffer
+	logger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(logger) })
+	return &logs
+}
+
+func TestServeHTTPMasksPasskey(t *testing.T) {
+	const passkey = "48:3F:DA:54:2C:6E"
+	for _, target := range []string{
+		"/data/report/PASSKEY=" + passkey + "&tempf=71.2",
+		"/data/report/?PASSKEY=" + passkey + "&tempf=71.2",
+		"/data/report/?tempf=71.2&PASSKEY=" + url.QueryEscape(passkey),
+	} {
+		logs := captureLogs(t)
+		parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		parser.ServeHTTP(httptest.NewRecorder(), req)
+		if !strings.Contains(logs.String(), "sample submitted") {
+			t.Fatalf("the report was not logged: %s", logs.String())
+		}
+		for _, leaked := range []string{passkey, url.QueryEscape(passkey)} {
+			if strings.Contains(logs.String(), leaked) {
+				t.Errorf("%s logged the passkey: %s", target, logs.String())
+			}
+		}
+	}
+}
+
+func TestServeHTTPMethods(t *testing.T) {
+	const remote = "192.168.1.5"
+	tests := []struct {
+		method string
+		target string
+		body   string
+	}{
+		{http.MethodGet, "/data/report/?tempf=71.2&humidity=40", ""},
+		{http.MethodGet, "/data/report/&tempf=71.2&humidity=40", ""},
+		{http.MethodPost, "/data/report/", "tempf=71.2&humidity=40"},
+		// the query string and the form body are merged
+		{http.MethodPost, "/data/report/?tempf=71.2", "humidity=40"},
+	}
+	for _, test := range tests {
+		parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+		req := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+		if test.body != "" {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		req.RemoteAddr = remote + ":54321"
+		resp := httptest.NewRecorder()
+		parser.ServeHTTP(resp, req)
+		if resp.Code != http.StatusNoContent {
+			t.Errorf("%s %s: got %d, want 204", test.method, test.target, resp.Code)
+		}
+		if got := gaugeValue(parser.temperature, remote, "", "outdoor"); got != 71.2 {
+			t.Errorf("%s %s: temperature = %v, want 71.2", test.method, test.target, got)
+		}
+		if got := gaugeValue(parser.humidity, remote, "", "outdoor"); got != 40 {
+			t.Errorf("%s %s: humidity = %v, want 40", test.method, test.target, got)
+		}
+	}
+}
+
+func TestServeHTTPRejectsLargeBody(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	parser.SetMaxBodyBytes(64)
+	body := "tempf=71.2&filler=" + strings.Repeat("x", 64)
+	req := httptest.NewRequest(http.MethodPost, "/data/report/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp := httptest.NewRecorder()
+	parser.ServeHTTP(resp, req)
+	if resp.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got %d, want 413", resp.Code)
+	}
+	if hasSeries(parser.temperature, "192.0.2.1", "", "outdoor") {
+		t.Errorf("the report over the limit was parsed")
+	}
+}
+
+// parseFloat parses a float that the test itself wrote.
+func parseFloat(t *testing.T, value string) float64 {
+	t.Helper()
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestParseStationNames(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	factory := promauto.With(registry)
+	parser := NewParser("default", "", UnitsImperial, LabelOptions{}, &factory)
+	parser.SetStationNames(map[string]string{
+		"48:3F:DA:54:2C:6E":    "garden",
+		"A1B2C3D4E5F60718293A": "roof",
+		"WS2900_V2.01.18":      "shed",
+	})
+	remote := "192.168.1.5"
+	tests := []struct {
+		report url.Values
+		want   string
+	}{
+		{url.Values{"PASSKEY": {"48:3F:DA:54:2C:6E"}, "tempf": {"71.2"}}, "garden"},
+		{url.Values{"PASSKEY": {"A1B2C3D4E5F60718293A"}, "tempf": {"65.1"}}, "roof"},
+		{url.Values{"mac": {"00:11:22:33:44:55"}, "stationtype": {"WS2900_V2.01.18"}, "tempf": {"60.3"}}, "shed"},
+		{url.Values{"PASSKEY": {"unknown"}, "tempf": {"58.9"}}, "default"},
+	}
+	for _, test := range tests {
+		parser.Parse(remote, test.report)
+		if got := gaugeValue(parser.temperature, remote, test.want, "outdoor"); got != parseFloat(t, test.report.Get("tempf")) {
+			t.Errorf("%v: temperature of %s = %v", test.report, test.want, got)
+		}
+	}
+}
+
+func TestServeHTTPCustomReportPath(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	parser.SetReportPath("/ambient/upload/")
+	mux := http.NewServeMux()
+	mux.Handle("/ambient/upload/", parser)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// the fields in the path directly follow the custom prefix, tempf is only parsed when
+	// the prefix is stripped
+	resp, err := http.Get(server.URL + "/ambient/upload/tempf=71.2&humidity=40")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("got %s, want 204", resp.Status)
+	}
+	if got := gaugeValue(parser.temperature, "127.0.0.1", "", "outdoor"); got != 71.2 {
+		t.Errorf("temperature = %v, want 71.2", got)
+	}
+	if got := gaugeValue(parser.humidity, "127.0.0.1", "", "outdoor"); got != 40 {
+		t.Errorf("humidity = %v, want 40", got)
+	}
+	if got := testutil.ToFloat64(parser.parseErrors.WithLabelValues("query")); got != 0 {
+		t.Errorf("got %v query parse errors, want 0", got)
+	}
+}
+
+func TestParseDateUTC(t *testing.T) {
+	received := time.Date(2024, 6, 1, 12, 0, 30, 0, time.UTC)
+	want := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Time
+		ok    bool
+	}{
+		{"2024-01-02+03:04:05", want, true},
+		{"2024-01-02 03:04:05", want, true},
+		{"now", received, true},
+		{"NOW", received, true},
+		{"2024-01-02T03:04:05Z", time.Time{}, false},
+		{"yesterday", time.Time{}, false},
+	}
+	for _, test := range tests {
+		got, err := parseDateUTC(test.value, received)
+		if (err == nil) != test.ok || !got.Equal(test.want) {
+			t.Errorf("parseDateUTC(%q) = %v, %v, want %v", test.value, got, err, test.want)
+		}
+	}
+}
+
+func TestParseObservationTimestamp(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	received := time.Date(2024, 6, 1, 12, 0, 30, 0, time.UTC)
+	parser.now = func() time.Time { return received }
+	remote := "192.168.1.5"
+	// url.Values hold the decoded report, where a + in the query became a space
+	for value, want := range map[string]time.Time{
+		"2024-01-02+03:04:05": time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		"2024-01-02 03:04:06": time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC),
+		"now":                 received,
+	} {
+		parser.Parse(remote, url.Values{"dateutc": {value}})
+		if got := gaugeValue(parser.observationTimestamp, remote, ""); got != float64(want.Unix()) {
+			t.Errorf("dateutc %q: observation_timestamp_seconds = %v, want %v", value, got, want.Unix())
+		}
+	}
+	before := testutil.ToFloat64(parser.parseErrors.WithLabelValues("value"))
+	parser.Parse(remote, url.Values{"dateutc": {"yesterday"}})
+	if got := testutil.ToFloat64(parser.parseErrors.WithLabelValues("value")) - before; got != 1 {
+		t.Errorf("an invalid dateutc counted %v parse errors, want 1", got)
+	}
+}
+
+func TestParseRainRate(t *testing.T) {
+	remote := "192.168.1.5"
+	tests := []struct {
+		report url.Values
+		want   float64
+	}{
+		{url.Values{"hourlyrainin": {"0.12"}}, 0.12},
+		// rainratein is preferred over the hourly rain
+		{url.Values{"hourlyrainin": {"0.12"}, "rainratein": {"0.5"}}, 0.5},
+	}
+	for _, test := range tests {
+		parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+		parser.Parse(remote, test.report)
+		if got := gaugeValue(parser.rainRate, remote, ""); got != test.want {
+			t.Errorf("%v: rain rate = %v, want %v", test.report, got, test.want)
+		}
+	}
+
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	parser.Parse(remote, url.Values{"hourlyrainin": {"0.12"}})
+	parser.Parse(remote, url.Values{"tempf": {"71.2"}})
+	if hasSeries(parser.rainRate, remote, "") {
+		t.Errorf("the rain rate is still exported without hourlyrainin and rainratein")
+	}
+}
+
+func TestParseRainReset(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	remote := "192.168.1.5"
+	for _, rain := range []string{"12.3", "12.5", "12.5", "0.1", "0.4", "0"} {
+		parser.Parse(remote, url.Values{"yearlyrainin": {rain}, "totalrainin": {"30.2"}})
+	}
+	if got := testutil.ToFloat64(parser.rainResets.WithLabelValues(remote, "", "yearly")); got != 2 {
+		t.Errorf("got %v yearly resets, want 2", got)
+	}
+	if got := testutil.ToFloat64(parser.rainResets.WithLabelValues(remote, "", "total")); got != 0 {
+		t.Errorf("got %v total resets, want 0", got)
+	}
+	// another station has totals of its own
+	parser.Parse("192.168.1.6", url.Values{"yearlyrainin": {"0.2"}})
+	if got := testutil.ToFloat64(parser.rainResets.WithLabelValues("192.168.1.6", "", "yearly")); got != 0 {
+		t.Errorf("the first report of a station counted %v resets", got)
+	}
+}
+
+func TestCalculateSeaLevelPressure(t *testing.T) {
+	// at sea level nothing is reduced
+	if got := calculateSeaLevelPressure(29.92, 0, 59); !approxEqual(got, 29.92, 0.001) {
+		t.Errorf("at sea level got %v inHg, want 29.92", got)
+	}
+	// the standard atmosphere at 1000 m: 898.76 hPa and 8.5°C
+	if got := calculateSeaLevelPressure(hPaToInHg(898.76), 1000, 47.3); !approxEqual(got, 29.92, 0.01) {
+		t.Errorf("at 1000 m got %v inHg, want 29.92", got)
+	}
+}
+
+func TestParseSeaLevelPressure(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	remote := "192.168.1.5"
+	report := url.Values{"tempf": {"47.3"}, "baromabsin": {strconv.FormatFloat(hPaToInHg(898.76), 'f', -1, 64)}}
+	parser.Parse(remote, report)
+	if hasSeries(parser.barometer, remote, "", "sealevel") {
+		t.Errorf("the sea-level pressure is exported without an altitude")
+	}
+	parser.SetAltitude(1000)
+	parser.Parse(remote, report)
+	if got := gaugeValue(parser.barometer, remote, "", "sealevel"); !approxEqual(got, 29.92, 0.01) {
+		t.Errorf("sea-level barometer = %v, want 29.92", got)
+	}
+}
+
+func TestCalculateWetBulb(t *testing.T) {
+	// the worked example of Stull (2011): 20°C and 50% give 13.7°C
+	if got := fahrenheitToCelsius(calculateWetBulb(68, 50)); !approxEqual(got, 13.7, 0.05) {
+		t.Errorf("wet bulb at 20°C 50%% = %v°C, want 13.7", got)
+	}
+	// saturated air doesn't cool by evaporation
+	if got := fahrenheitToCelsius(calculateWetBulb(68, 99)); !approxEqual(got, 20, 0.3) {
+		t.Errorf("wet bulb at 20°C 99%% = %v°C, want about 20", got)
+	}
+
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	parser.Parse("192.168.1.5", url.Values{"tempf": {"68"}, "humidity": {"50"}})
+	if got := gaugeValue(parser.temperature, "192.168.1.5", "", "wetbulb"); !approxEqual(got, calculateWetBulb(68, 50), 1e-9) {
+		t.Errorf("wetbulb temperature = %v", got)
+	}
+}
+
+func TestCalculateAbsoluteHumidity(t *testing.T) {
+	if got := calculateAbsoluteHumidity(68, 50); !approxEqual(got, 8.6, 0.05) {
+		t.Errorf("absolute humidity at 20°C 50%% = %v g/m3, want 8.6", got)
+	}
+	if got := calculateAbsoluteHumidity(68, 0); got != 0 {
+		t.Errorf("absolute humidity of dry air = %v g/m3, want 0", got)
+	}
+
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	parser.Parse("192.168.1.5", url.Values{"tempf": {"68"}, "humidity": {"50"}, "tempinf": {"72"}, "humidityin": {"40"}})
+	if got := gaugeValue(parser.absoluteHumidity, "192.168.1.5", "", "outdoor"); !approxEqual(got, 8.6, 0.05) {
+		t.Errorf("outdoor absolute_humidity = %v, want 8.6", got)
+	}
+	if !hasSeries(parser.absoluteHumidity, "192.168.1.5", "", "indoor") {
+		t.Errorf("the indoor absolute_humidity is missing")
+	}
+}
+
+func TestCalculateVPD(t *testing.T) {
+	// a typical greenhouse: 25°C and 60%
+	if got := calculateVPD(77, 60); !approxEqual(got, 1.27, 0.01) {
+		t.Errorf("VPD at 25°C 60%% = %v kPa, want 1.27", got)
+	}
+	if got := calculateVPD(77, 100); got != 0 {
+		t.Errorf("VPD of saturated air = %v kPa, want 0", got)
+	}
+
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	parser.Parse("192.168.1.5", url.Values{"tempinf": {"77"}, "humidityin": {"60"}})
+	if got := gaugeValue(parser.vaporPressureDeficit, "192.168.1.5", "", "indoor"); !approxEqual(got, 1.27, 0.01) {
+		t.Errorf("indoor vapor_pressure_deficit = %v, want 1.27", got)
+	}
+	if hasSeries(parser.vaporPressureDeficit, "192.168.1.5", "", "outdoor") {
+		t.Errorf("the outdoor vapor_pressure_deficit is exported without tempf and humidity")
+	}
+}
+
+func TestCalculateCloudBase(t *testing.T) {
+	tests := []struct {
+		tempF     float64
+		dewpointF float64
+		want      float64
+	}{
+		{70, 60, 2272.7},
+		{60, 60, 0},
+		// a dewpoint above the temperature is a measurement error
+		{60, 62, 0},
+	}
+	for _, test := range tests {
+		if got := calculateCloudBase(test.tempF, test.dewpointF); !approxEqual(got, test.want, 0.1) {
+			t.Errorf("calculateCloudBase(%v, %v) = %v, want %v", test.tempF, test.dewpointF, got, test.want)
+		}
+	}
+}
+
+func TestCloudBaseRemovedWithoutDewpoint(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	for _, values := range []url.Values{
+		{"humidity": {"40"}},
+		{"tempf": {"71.2"}},
+	} {
+		parser.Parse("192.168.1.5", url.Values{"tempf": {"71.2"}, "humidity": {"40"}})
+		if !hasSeries(parser.cloudBase, "192.168.1.5", "") {
+			t.Fatal("no cloud_base_feet with tempf and humidity")
+		}
+		parser.Parse("192.168.1.5", values)
+		if hasSeries(parser.cloudBase, "192.168.1.5", "") {
+			t.Errorf("cloud_base_feet is still exported after a report of only %v", values)
+		}
+	}
+}
+
+func TestCalculateAirDensity(t *testing.T) {
+	// the ISA at sea level: 15°C, 1013.25 hPa and dry air
+	if got := calculateAirDensity(59, 29.9213, 0); !approxEqual(got, 1.225, 0.001) {
+		t.Errorf("air density of the ISA = %v kg/m3, want 1.225", got)
+	}
+	// water vapor is lighter than dry air
+	if humid := calculateAirDensity(59, 29.9213, 100); humid >= calculateAirDensity(59, 29.9213, 0) {
+		t.Errorf("humid air density %v isn't below that of dry air", humid)
+	}
+
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	remote := "192.168.1.5"
+	parser.Parse(remote, url.Values{"tempf": {"59"}, "baromabsin": {"29.9213"}, "humidity": {"0"}})
+	if got := gaugeValue(parser.airDensity, remote, ""); !approxEqual(got, 1.225, 0.001) {
+		t.Errorf("air_density = %v, want 1.225", got)
+	}
+	parser.Parse(remote, url.Values{"tempf": {"59"}, "humidity": {"0"}})
+	if hasSeries(parser.airDensity, remote, "") {
+		t.Errorf("air_density is exported without baromabsin")
+	}
+}
+
+func TestCalculateApparentTemperature(t *testing.T) {
+	// 30°C, 50% and 5 mph: AT = 30 + 0.33 * 21.2 hPa - 0.70 * 2.24 m/s - 4.00
+	shade := calculateApparentTemperature(86, 50, 5, 800, false)
+	if got := fahrenheitToCelsius(shade); !approxEqual(got, 31.4, 0.1) {
+		t.Errorf("apparent temperature in the shade = %v°C, want 31.4", got)
+	}
+	sun := calculateApparentTemperature(86, 50, 5, 800, true)
+	if sun <= shade {
+		t.Errorf("apparent temperature in the sun %v isn't above the shade %v", sun, shade)
+	}
+
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	remote := "192.168.1.5"
+	parser.Parse(remote, url.Values{"tempf": {"86"}, "humidity": {"50"}, "windspeedmph": {"5"}, "solarradiation": {"800"}})
+	if got := gaugeValue(parser.temperature, remote, "", "apparentTemp"); !approxEqual(got, sun, 1e-9) {
+		t.Errorf("apparentTemp with sun = %v, want %v", got, sun)
+	}
+	parser.Parse(remote, url.Values{"tempf": {"86"}, "humidity": {"50"}, "windspeedmph": {"5"}})
+	if got := gaugeValue(parser.temperature, remote, "", "apparentTemp"); !approxEqual(got, shade, 1e-9) {
+		t.Errorf("apparentTemp without a solar sensor = %v, want %v", got, shade)
+	}
+}
+
+func TestCalculateFrostPoint(t *testing.T) {
+	for _, test := range []struct{ tempF, rh float64 }{{20, 70}, {10, 90}, {30, 50}, {-5, 80}} {
+		frostPoint := calculateFrostPoint(test.tempF, test.rh)
+		dewPoint := calculateDewPoint(test.tempF, test.rh)
+		if frostPoint < dewPoint {
+			t.Errorf("at %v°F %v%%: frost point %v is below the dewpoint %v", test.tempF, test.rh, frostPoint, dewPoint)
+		}
+	}
+
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	remote := "192.168.1.5"
+	parser.Parse(remote, url.Values{"tempf": {"20"}, "humidity": {"70"}})
+	if got := gaugeValue(parser.temperature, remote, "", "frostpoint"); !approxEqual(got, calculateFrostPoint(20, 70), 1e-9) {
+		t.Errorf("frostpoint = %v", got)
+	}
+	// above freezing there is dew, not frost
+	parser.Parse(remote, url.Values{"tempf": {"68"}, "humidity": {"50"}})
+	if hasSeries(parser.temperature, remote, "", "frostpoint") {
+		t.Errorf("the frostpoint is exported with a dewpoint above freezing")
+	}
+}
+
+func TestCalculateHumidex(t *testing.T) {
+	// Environment Canada: 30°C at 70% feels like 41
+	if got := calculateHumidex(86, calculateDewPoint(86, 70)); !approxEqual(got, 41, 0.5) {
+		t.Errorf("humidex at 30°C 70%% = %v, want about 41", got)
+	}
+
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	remote := "192.168.1.5"
+	parser.Parse(remote, url.Values{"tempf": {"86"}, "humidity": {"70"}})
+	if got := gaugeValue(parser.temperatureCelsius, remote, "", "humidex"); !approxEqual(got, 41, 0.5) {
+		t.Errorf("humidex = %v, want about 41", got)
+	}
+	if got := gaugeValue(parser.humidexDanger, remote, ""); got != 0 {
+		t.Errorf("humidex danger = %v at 41, want 0", got)
+	}
+	parser.Parse(remote, url.Values{"tempf": {"95"}, "humidity": {"70"}})
+	if got := gaugeValue(parser.humidexDanger, remote, ""); got != 1 {
+		t.Errorf("humidex danger = %v at 35°C 70%%, want 1", got)
+	}
+}
+
+func TestParseHeatIndexAndWindChill(t *testing.T) {
+	tests := []struct {
+		report                          url.Values
+		heatIndex, windChill, feelsLike float64
+	}{
+		// out of range of both, they are the temperature
+		{url.Values{"tempf": {"60"}, "humidity": {"50"}, "windspeedmph": {"10"}}, 60, 60, 60},
+		{url.Values{"tempf": {"90"}, "humidity": {"60"}, "windspeedmph": {"10"}}, calculateHeatIndex(90, 60), 90, calculateHeatIndex(90, 60)},
+		{url.Values{"tempf": {"30"}, "humidity": {"60"}, "windspeedmph": {"10"}}, 30, calculateWindChill(30, 10), calculateWindChill(30, 10)},
+	}
+	remote := "192.168.1.5"
+	for _, test := range tests {
+		parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+		parser.Parse(remote, test.report)
+		for sensor, want := range map[string]float64{"heatindex": test.heatIndex, "windchill": test.windChill, "feelsLike": test.feelsLike} {
+			if got := gaugeValue(parser.temperature, remote, "", sensor); !approxEqual(got, want, 1e-9) {
+				t.Errorf("%v: %s = %v, want %v", test.report, sensor, got, want)
+			}
+		}
+	}
+}
+
+func TestCalculateWindChill(t *testing.T) {
+	tests := []struct {
+		tempF, windSpeedMph float64
+		want                float64
+	}{
+		// the NWS formula applies at or below 50°F from 3 mph
+		{41, 10, 34.88},
+		{40, 4, 37.32},
+		{50, 3, 49.68},
+		{51, 10, 51},
+		{40, 2, 40},
+	}
+	for _, test := range tests {
+		if got := calculateWindChill(test.tempF, test.windSpeedMph); !approxEqual(got, test.want, 0.01) {
+			t.Errorf("calculateWindChill(%v, %v) = %v, want %v", test.tempF, test.windSpeedMph, got, test.want)
+		}
+	}
+}
+
+func TestParseRejectsOutOfRangeUV(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	remote := "192.168.1.5"
+	parser.Parse(remote, url.Values{"uv": {"6"}})
+	for _, uv := range []string{"15000", "-1"} {
+		parser.Parse(remote, url.Values{"uv": {uv}})
+		if got := gaugeValue(parser.ultraviolet, remote, ""); got != 6 {
+			t.Errorf("uv %s: ultraviolet = %v, want the last sane reading 6", uv, got)
+		}
+	}
+	if got := testutil.ToFloat64(parser.parseErrors.WithLabelValues("range")); got != 2 {
+		t.Errorf("got %v range errors, want 2", got)
+	}
+	parser.Parse(remote, url.Values{"uv": {"20"}})
+	if got := gaugeValue(parser.ultraviolet, remote, ""); got != 20 {
+		t.Errorf("ultraviolet = %v, want 20", got)
+	}
+}
+
+func TestParseSolarLux(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	remote := "192.168.1.5"
+	parser.Parse(remote, url.Values{"solarradiation": {"500"}})
+	if got := gaugeValue(parser.solarLux, remote, ""); !approxEqual(got, 63350, 1e-6) {
+		t.Errorf("solar_lux at 500 W/m2 = %v, want 63350", got)
+	}
+	if got := gaugeValue(parser.solarRadiation, remote, ""); got != 500 {
+		t.Errorf("solar_radiation = %v, want 500", got)
+	}
+	parser.SetLuxPerWm2(100)
+	parser.Parse(remote, url.Values{"solarradiation": {"500"}})
+	if got := gaugeValue(parser.solarLux, remote, ""); !approxEqual(got, 50000, 1e-6) {
+		t.Errorf("solar_lux with 100 lux per W/m2 = %v, want 50000", got)
+	}
+}
+
+func TestParseStationInfo(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	remote := "192.168.1.5"
+	parser.Parse(remote, url.Values{"tempf": {"71.2"}})
+	if got := testutil.CollectAndCount(parser.stationInfo.GaugeVec); got != 0 {
+		t.Errorf("station_info is exported without a mac or location, %d series", got)
+	}
+	parser.SetLatitude(52.37)
+	parser.SetLongitude(4.89)
+	parser.SetAltitude(2.5)
+	parser.Parse(remote, url.Values{"mac": {"48:3F:DA:54:2C:6E"}, "tempf": {"71.2"}})
+	if got := gaugeValue(parser.stationInfo, remote, "", "48:3F:DA:54:2C:6E", "52.37", "4.89", "2.5"); got != 1 {
+		t.Errorf("station_info = %v, want 1", got)
+	}
+
+	// the PASSKEY is a credential, it isn't exported as the mac
+	parser, _ = newTestParser(t, UnitsImperial, LabelOptions{})
+	parser.Parse(remote, url.Values{"PASSKEY": {"48:3F:DA:54:2C:6E"}, "tempf": {"71.2"}})
+	if got := testutil.CollectAndCount(parser.stationInfo.GaugeVec); got != 0 {
+		t.Errorf("station_info is exported for a PASSKEY, %d series", got)
+	}
+	parser.SetLatitude(52.37)
+	parser.Parse(remote, url.Values{"PASSKEY": {"48:3F:DA:54:2C:6E"}, "tempf": {"71.2"}})
+	if !hasSeries(parser.stationInfo, remote, "", "", "52.37", "", "0") {
+		t.Error("station_info doesn't have an empty mac without a mac field")
+	}
+}
+
+func TestPasskeyPattern(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"/data/report/PASSKEY=48:3F:DA:54:2C:6E&tempf=71.2", "/data/report/PASSKEY=******&tempf=71.2"},
+		{"/weatherstation/updateweatherstation.php?ID=KXX&PASSWORD=secret&tempf=71.2", "/weatherstation/updateweatherstation.php?ID=KXX&PASSWORD=******&tempf=71.2"},
+		{"/v01/set/wid/1234/key/secret/temp/215", "/v01/set/wid/1234/key/******/temp/215"},
+		{"/data/report/?tempf=71.2&monkey=1", "/data/report/?tempf=71.2&monkey=1"},
+	}
+	for _, test := range tests {
+		if got := passkeyPattern.ReplaceAllString(test.url, "${1}${2}******"); got != test.want {
+			t.Errorf("masking %s = %s, want %s", test.url, got, test.want)
+		}
+	}
+}
+
+// readFixture returns the fields of the report in a file of testdata.
+func readFixture(t testing.TB, name string) url.Values {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := url.ParseQuery(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+func BenchmarkReadReport(b *testing.B) {
+	registry := prometheus.NewRegistry()
+	factory := promauto.With(registry)
+	parser := NewParser("", "", UnitsImperial, LabelOptions{}, &factory)
+	target := DefaultReportPath + "?" + readFixture(b, "ambient_report.txt").Encode()
+	resp := httptest.NewRecorder()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.RemoteAddr = "192.168.1.5:54321"
+		parser.readReport(resp, req)
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	registry := prometheus.NewRegistry()
+	factory := promauto.With(registry)
+	parser := NewParser("", "", UnitsImperial, LabelOptions{}, &factory)
+	parser.AddObserver(observerFunc(func(Observation) {}))
+	values := readFixture(b, "ambient_report.txt")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parser.Parse("192.168.1.5", values)
+	}
+}
+
+func TestParseDecimalComma(t *testing.T) {
+	remote := "192.168.1.5"
+	tests := []struct {
+		decimalComma bool
+		value        string
+		want         float64
+		errors       float64
+	}{
+		{false, "29.92", 29.92, 0},
+		{false, "29,92", 0, 1},
+		{true, "29.92", 29.92, 0},
+		{true, "29,92", 29.92, 0},
+		// a thousands separator next to a decimal point is still an error
+		{true, "1,013.2", 0, 1},
+		{true, "1,013,2", 0, 1},
+	}
+	for _, test := range tests {
+		parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+		parser.SetDecimalComma(test.decimalComma)
+		parser.Parse(remote, url.Values{"baromrelin": {test.value}})
+		if got := testutil.ToFloat64(parser.parseErrors.WithLabelValues("value")); got != test.errors {
+			t.Errorf("decimal comma %v, %q: got %v parse errors, want %v", test.decimalComma, test.value, got, test.errors)
+		}
+		if test.errors == 0 {
+			if got := gaugeValue(parser.barometer, remote, "", "relative"); got != test.want {
+				t.Errorf("decimal comma %v, %q: barometer = %v, want %v", test.decimalComma, test.value, got, test.want)
+			}
+		}
+	}
+}
+
+func TestParseDuplicateFields(t *testing.T) {
+	remote := "192.168.1.5"
+	report := url.Values{"tempf": {"70.1", "71.2"}, "stationtype": {"AMBWeatherV4.2.9", "relay"}}
+	for duplicates, want := range map[string]float64{"": 70.1, DuplicatesFirst: 70.1, DuplicatesLast: 71.2} {
+		parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+		if duplicates != "" {
+			parser.SetDuplicates(duplicates)
+		}
+		parser.Parse(remote, report)
+		if got := gaugeValue(parser.temperature, remote, "", "outdoor"); got != want {
+			t.Errorf("duplicates %q: temperature = %v, want %v", duplicates, got, want)
+		}
+		stationType := "AMBWeatherV4.2.9"
+		if duplicates == DuplicatesLast {
+			stationType = "relay"
+		}
+		if !hasSeries(parser.stationtype, remote, "", stationType) {
+			t.Errorf("duplicates %q: stationtype_info of %s is missing", duplicates, stationType)
+		}
+	}
+}
+
+func TestHandlersRejectLargeBody(t *testing.T) {
+	body := "tempf=71.2&filler=" + strings.Repeat("x", DefaultMaxBodyBytes)
+	for _, test := range []struct {
+		name        string
+		handler     func(*Parser) http.Handler
+		contentType string
+		body        string
+	}{
+		{"ambient", func(p *Parser) http.Handler { return p }, "application/x-www-form-urlencoded", body},
+		{"ecowitt", func(p *Parser) http.Handler { return NewEcowittHandler(p) }, "application/x-www-form-urlencoded", body},
+		{"wunderground", func(p *Parser) http.Handler { return NewWundergroundHandler(p) }, "application/x-www-form-urlencoded", body},
+		{"weathercloud", func(p *Parser) http.Handler { return NewWeatherCloudHandler(p) }, "application/x-www-form-urlencoded", body},
+		{"json", func(p *Parser) http.Handler { return NewJSONHandler(p) }, "application/json", `{"tempf": 71.2, "filler": "` + strings.Repeat("x", DefaultMaxBodyBytes) + `"}`},
+	} {
+		parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+		req.Header.Set("Content-Type", test.contentType)
+		req.RemoteAddr = "192.168.1.5:54321"
+		resp := httptest.NewRecorder()
+		test.handler(parser).ServeHTTP(resp, req)
+		if resp.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: got %d, want 413", test.name, resp.Code)
+		}
+		if got := testutil.ToFloat64(parser.reportsReceived.WithLabelValues("192.168.1.5", "too_large")); got != 1 {
+			t.Errorf("%s: counted %v reports too large, want 1", test.name, got)
+		}
+		if hasSeries(parser.temperature, "192.168.1.5", "", "outdoor") {
+			t.Errorf("%s: the report over the limit was parsed", test.name)
+		}
+	}
+}
+
+func TestServeHTTPRejectsOtherMethods(t *testing.T) {
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+		req := httptest.NewRequest(method, "/data/report/?tempf=71.2", nil)
+		resp := httptest.NewRecorder()
+		parser.ServeHTTP(resp, req)
+		if resp.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s: got %d, want 405", method, resp.Code)
+		}
+		if got := resp.Header().Get("Allow"); got != "GET, POST" {
+			t.Errorf("%s: got Allow %q, want \"GET, POST\"", method, got)
+		}
+		if hasSeries(parser.temperature, "192.0.2.1", "", "outdoor") {
+			t.Errorf("%s: the report was parsed", method)
+		}
+	}
+}
+
+func TestParseCountsBadFieldOnce(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	for _, field := range []string{"tempf", "humidity", "solarradiation", "windspeedmph", "baromabsin"} {
+		values := url.Values{
+			"tempf": {"71.2"}, "humidity": {"40"}, "solarradiation": {"500"},
+			"windspeedmph": {"3"}, "baromabsin": {"29.5"}, "baromrelin": {"29.9"},
+		}
+		values.Set(field, "bad")
+		before := testutil.ToFloat64(parser.parseErrors.WithLabelValues("value"))
+		parser.Parse("192.168.1.5", values)
+		if got := testutil.ToFloat64(parser.parseErrors.WithLabelValues("value")) - before; got != 1 {
+			t.Errorf("a bad %s counted %v parse errors, want 1", field, got)
+		}
+	}
+}
+
+func TestParseMetricUnits(t *testing.T) {
+	parser, registry := newTestParser(t, UnitsMetric, LabelOptions{})
+	remote := "192.168.1.5"
+	parser.Parse(remote, url.Values{
+		"tempf": {"32"}, "humidity": {"40"}, "baromrelin": {"29.92"},
+		"windspeedmph": {"10"}, "dailyrainin": {"1"}, "rainratein": {"0.5"},
+	})
+	tests := []struct {
+		gauge  *stationGaugeVec
+		labels []string
+		want   float64
+	}{
+		{parser.temperature, []string{remote, "", "outdoor"}, 0},
+		{parser.barometer, []string{remote, "", "relative"}, 1013.21},
+		{parser.windSpeedMph, []string{remote, "", "sustained"}, 16.09},
+		{parser.windSpeedMs, []string{remote, "", "sustained"}, 4.47},
+		{parser.rainIn, []string{remote, "", "daily"}, 25.4},
+		{parser.rainRate, []string{remote, ""}, 12.7},
+	}
+	for _, test := range tests {
+		if got := gaugeValue(test.gauge, test.labels...); !approxEqual(got, test.want, 0.01) {
+			t.Errorf("%v = %v, want %v", test.labels, got, test.want)
+		}
+	}
+	text := gatherText(t, registry)
+	for _, name := range []string{"rain_mm{", "rain_rate_mm_per_hr{", "wind_speed_kmh{"} {
+		if !strings.Contains(text, name) {
+			t.Errorf("metric units don't export %s", name)
+		}
+	}
+	for _, name := range []string{"rain_in{", "rain_rate_in_per_hr{", "wind_speed_mph{"} {
+		if strings.Contains(text, name) {
+			t.Errorf("metric units export %s", name)
+		}
+	}
+}
+
+func TestExemplars(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		parser, registry := newTestParser(t, UnitsImperial, LabelOptions{})
+		parser.SetExemplars(enabled)
+		remote := "192.168.1.5"
+		for _, rain := range []string{"1.5", "0"} {
+			values := url.Values{"dateutc": {"2024-01-02 03:04:05"}, "tempf": {"71.2"}, "dailyrainin": {rain}}
+			parser.countReport(remote, values, nil)
+			parser.Parse(remote, values)
+		}
+
+		handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: true})
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		exemplar := ` # {observed_at="2024-01-02T03:04:05Z"} 1.0 `
+		for _, series := range []string{
+			`reports_received_total{remote_address="192.168.1.5",status="ok"} 2.0`,
+			`rain_reset_total{name="",period="daily",remote_address="192.168.1.5"} 1.0`,
+		} {
+			if got := strings.Contains(resp.Body.String(), series+exemplar); got != enabled {
+				t.Errorf("with exemplars %v, %s has an exemplar: %v\n%s", enabled, series, got, resp.Body.String())
+			}
+		}
+	}
+}
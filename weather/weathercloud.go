@@ -0,0 +1,97 @@
+package weather
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// WeatherCloudPath is where WeatherCloud compatible devices upload to.
+const WeatherCloudPath = "/v01/set"
+
+// WeatherCloudHandler accepts reports in the WeatherCloud upload protocol and feeds
+// them through the Parser. Devices send the fields as pairs of path segments, e.g.
+// /v01/set/wid/<id>/key/<key>/temp/215/hum/55, some send them as a query string instead.
+type WeatherCloudHandler struct {
+	parser *Parser
+}
+
+func NewWeatherCloudHandler(parser *Parser) *WeatherCloudHandler {
+	return &WeatherCloudHandler{parser: parser}
+}
+
+func (h *WeatherCloudHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if !h.parser.allowed(resp, req) || !reportMethod(resp, req) {
+		return
+	}
+	remote_address, values, err := h.parser.readReport(resp, req)
+	if h.parser.rejectTooLarge(resp, remote_address, err) {
+		return
+	}
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, WeatherCloudPath), "/"), "/")
+	for i := 0; i+1 < len(segments); i += 2 {
+		values.Add(segments[i], segments[i+1])
+	}
+	// the key is the device's secret, it must not reach the logs, metrics or sinks
+	values.Del("key")
+	// weathercloud clients check for this body
+	resp.Header().Set("Content-Type", "text/plain")
+	resp.WriteHeader(http.StatusOK)
+	resp.Write([]byte("200"))
+	translated := h.parser.weatherCloudValues(values)
+	h.parser.countReport(remote_address, translated, err)
+	h.parser.handleReport(req, remote_address, translated, false)
+}
+
+// weatherCloudFields holds the WeatherCloud fields and their conversion to the Ambient
+// Weather protocol. Most values are sent in tenths of a metric unit, e.g. temp/215 is 21.5°C.
+var weatherCloudFields = map[string]fieldTranslation{
+	"wid":      {"PASSKEY", nil},
+	"temp":     {"tempf", tenthsCelsiusToFahrenheit},
+	"tempin":   {"tempinf", tenthsCelsiusToFahrenheit},
+	"hum":      {"humidity", nil},
+	"humin":    {"humidityin", nil},
+	"bar":      {"baromrelin", tenthsHPaToInHg},
+	"wspd":     {"windspeedmph", tenthsMsToMph},
+	"wspdavg":  {"windspdmph_avg10m", tenthsMsToMph},
+	"wspdhi":   {"windgustmph", tenthsMsToMph},
+	"wdir":     {"winddir", nil},
+	"wdiravg":  {"winddir_avg10m", nil},
+	"rain":     {"dailyrainin", tenthsMmToInches},
+	"rainrate": {"rainratein", tenthsMmToInches},
+	"solarrad": {"solarradiation", tenths},
+	"uvi":      {"uv", tenths},
+}
+
+// weatherCloudValues translates the fields of a WeatherCloud report, the separate date
+// and time in UTC are joined to dateutc.
+func (p *Parser) weatherCloudValues(values url.Values) url.Values {
+	translated := p.translateFields(values, weatherCloudFields)
+	date, clock := translated.Get("date"), translated.Get("time")
+	if len(date) == 8 && len(clock) == 4 {
+		translated.Set("dateutc", date[:4]+"-"+date[4:6]+"-"+date[6:]+" "+clock[:2]+":"+clock[2:]+":00")
+	}
+	translated.Del("date")
+	translated.Del("time")
+	return translated
+}
+
+func tenths(value float64) float64 {
+	return value / 10
+}
+
+func tenthsCelsiusToFahrenheit(tenthsC float64) float64 {
+	return tenthsC/10*9/5 + 32
+}
+
+func tenthsHPaToInHg(tenthsHPa float64) float64 {
+	return tenthsHPa / 10 / 33.8639
+}
+
+func tenthsMsToMph(tenthsMs float64) float64 {
+	return tenthsMs / 10 / 0.44704
+}
+
+func tenthsMmToInches(tenthsMm float64) float64 {
+	return tenthsMm / 10 / 25.4
+}
@@ -0,0 +1,101 @@
+package weather
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestWeatherCloudUpload feeds the upload of a WeatherCloud device through the handler.
+func TestWeatherCloudUpload(t *testing.T) {
+	fixture, err := os.ReadFile("testdata/weathercloud_upload.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := captureLogs(t)
+
+	parser, registry := newTestParser(t, UnitsImperial, LabelOptions{})
+	var observations []Observation
+	parser.AddObserver(observerFunc(func(observation Observation) {
+		observations = append(observations, observation)
+	}))
+	req := httptest.NewRequest(http.MethodGet, strings.TrimSpace(string(fixture)), nil)
+	req.RemoteAddr = "192.168.1.20:41234"
+	resp := httptest.NewRecorder()
+	NewWeatherCloudHandler(parser).ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK || resp.Body.String() != "200" {
+		t.Fatalf("got response %d %q, want 200 \"200\"", resp.Code, resp.Body.String())
+	}
+
+	const remote = "192.168.1.20"
+	for _, test := range []struct {
+		gauge  *stationGaugeVec
+		labels []string
+		want   float64
+	}{
+		{parser.temperature, []string{remote, "", "outdoor"}, 70.7},
+		{parser.temperature, []string{remote, "", "indoor"}, 72.32},
+		{parser.humidity, []string{remote, "", "outdoor"}, 55},
+		{parser.humidity, []string{remote, "", "indoor"}, 48},
+		{parser.barometer, []string{remote, "", "relative"}, 1013.2 / 33.8639},
+		{parser.windDir, []string{remote, "", "current"}, 180},
+		{parser.windDir, []string{remote, "", "avg10m"}, 175},
+		{parser.windSpeedMph, []string{remote, "", "sustained"}, 1.2 / 0.44704},
+		{parser.windSpeedMph, []string{remote, "", "gusts"}, 3.1 / 0.44704},
+		{parser.rainIn, []string{remote, "", "daily"}, 2.5 / 25.4},
+		{parser.ultraviolet, []string{remote, ""}, 4},
+		{parser.solarRadiation, []string{remote, ""}, 612.5},
+		{parser.observationTimestamp, []string{remote, ""}, 1717243200},
+	} {
+		if got := gaugeValue(test.gauge, test.labels...); !approxEqual(got, test.want, 0.01) {
+			t.Errorf("%v: got %v, want %v", test.labels, got, test.want)
+		}
+	}
+
+	// the device's key must not show up anywhere
+	const key = "5e8d1c0f7a2b4963"
+	if strings.Contains(logs.String(), key) {
+		t.Errorf("the key was logged: %s", logs.String())
+	}
+	if metrics := gatherText(t, registry); strings.Contains(metrics, key) {
+		t.Errorf("the key is in the metrics: %s", metrics)
+	}
+	if len(observations) != 1 {
+		t.Fatalf("got %d observations, want 1", len(observations))
+	}
+	for _, sample := range observations[0].Samples {
+		for _, value := range sample.Labels {
+			if strings.Contains(value, key) {
+				t.Errorf("the key is in the labels of %s", sample.Key())
+			}
+		}
+	}
+}
+
+// TestWeatherCloudReadsValuesLikeParse checks that the translated values honor
+// -duplicates last and -decimal-comma.
+func TestWeatherCloudReadsValuesLikeParse(t *testing.T) {
+	parser, _ := newTestParser(t, UnitsImperial, LabelOptions{})
+	parser.SetDuplicates(DuplicatesLast)
+	parser.SetDecimalComma(true)
+	req := httptest.NewRequest(http.MethodGet, "/v01/set/wid/3f9c2a7b81d04e65/temp/100/temp/215/hum/50/hum/55/bar/10132,5", nil)
+	req.RemoteAddr = "192.168.1.20:41234"
+	NewWeatherCloudHandler(parser).ServeHTTP(httptest.NewRecorder(), req)
+
+	const remote = "192.168.1.20"
+	for _, test := range []struct {
+		gauge  *stationGaugeVec
+		labels []string
+		want   float64
+	}{
+		{parser.temperature, []string{remote, "", "outdoor"}, 70.7},
+		{parser.humidity, []string{remote, "", "outdoor"}, 55},
+		{parser.barometer, []string{remote, "", "relative"}, 1013.25 / 33.8639},
+	} {
+		if got := gaugeValue(test.gauge, test.labels...); !approxEqual(got, test.want, 0.01) {
+			t.Errorf("%v: got %v, want %v", test.labels, got, test.want)
+		}
+	}
+}
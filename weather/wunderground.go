@@ -0,0 +1,51 @@
+package weather
+
+import (
+	"net/http"
+)
+
+// WundergroundPath is where Weather Underground compatible consoles upload to.
+const WundergroundPath = "/weatherstation/updateweatherstation.php"
+
+// WundergroundHandler accepts reports in the Weather Underground upload protocol
+// and feeds them through the Parser.
+type WundergroundHandler struct {
+	parser *Parser
+}
+
+func NewWundergroundHandler(parser *Parser) *WundergroundHandler {
+	return &WundergroundHandler{parser: parser}
+}
+
+func (h *WundergroundHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if !h.parser.allowed(resp, req) || !reportMethod(resp, req) {
+		return
+	}
+	remote_address, values, err := h.parser.readReport(resp, req)
+	if h.parser.rejectTooLarge(resp, remote_address, err) {
+		return
+	}
+	// weather underground clients check for this body
+	resp.Header().Set("Content-Type", "text/plain")
+	resp.WriteHeader(http.StatusOK)
+	resp.Write([]byte("success\n"))
+	translated := h.parser.translateFields(values, wundergroundFields)
+	h.parser.countReport(remote_address, translated, err)
+	h.parser.handleReport(req, remote_address, translated, false)
+}
+
+// wundergroundCredentials are the fields of a Weather Underground upload that identify
+// and authenticate the station.
+var wundergroundCredentials = []string{"ID", "PASSWORD", "action"}
+
+// wundergroundFields holds the Weather Underground fields whose name differs
+// from the Ambient Weather protocol.
+var wundergroundFields = map[string]fieldTranslation{
+	"baromin":        {"baromrelin", nil},
+	"rainin":         {"hourlyrainin", nil},
+	"UV":             {"uv", nil},
+	"indoortempf":    {"tempinf", nil},
+	"indoorhumidity": {"humidityin", nil},
+	"soiltempf":      {"soiltemp1f", nil},
+	"soilmoisture":   {"soilhum1", nil},
+}